@@ -0,0 +1,62 @@
+package config
+
+import (
+	"context"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/model"
+)
+
+// SetBucket 设置后续操作所在的床，name为空时是默认床
+func SetBucket(ctx context.Context, name string) context.Context {
+	return util.SetCtxValue(ctx, model.BucketKey, name)
+}
+
+// GetBucketName 获取ctx所在的床名，未设置时为默认床
+func GetBucketName(ctx context.Context) string {
+	name, _ := util.GetCtxValue(ctx, model.BucketKey).(string)
+	return name
+}
+
+// GetBucket 获取ctx所在的床配置，床不存在时退回默认床
+func GetBucket(ctx context.Context) model.Bucket {
+	bucket, ok := GetBucketByName(GetBucketName(ctx))
+	if !ok {
+		return getDefaultBucket(Config)
+	}
+	return bucket
+}
+
+func GetBucketByName(name string) (model.Bucket, bool) {
+	if name == "" {
+		return getDefaultBucket(Config), true
+	}
+	for i := range Config.Buckets {
+		if Config.Buckets[i].Name == name {
+			return Config.Buckets[i], true
+		}
+	}
+	return model.Bucket{}, false
+}
+
+// ListBucket 罗列全部床，默认床在最前
+func ListBucket() []model.Bucket {
+	buckets := make([]model.Bucket, 0, len(Config.Buckets)+1)
+	buckets = append(buckets, getDefaultBucket(Config))
+	buckets = append(buckets, Config.Buckets...)
+	return buckets
+}
+
+func getDefaultBucket(config model.Config) model.Bucket {
+	var bucket model.Bucket
+	bucket.Root = model.FileBedPath
+	bucket.Secret = config.Secret
+	bucket.TrashEnable = config.TrashEnable
+	bucket.TrashSaveTime = config.TrashSaveTime
+	bucket.ImageTargetSize = config.ImageTargetSize
+	bucket.JpegMinQuality = config.JpegMinQuality
+	bucket.JpegMaxQuality = config.JpegMaxQuality
+	bucket.ImageSaveFormat = config.ImageSaveFormat
+	bucket.EncryptKeyId = config.EncryptKeyId
+	bucket.Quotas = config.Quotas
+	return bucket
+}
@@ -9,6 +9,9 @@ import (
 	"github.com/cellargalaxy/server_center/sdk"
 	"github.com/disintegration/imaging"
 	"github.com/sirupsen/logrus"
+	"path"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -77,6 +80,12 @@ func checkAndResetConfig(ctx context.Context, config model.Config) (model.Config
 	if config.MaxHashLimit <= 0 {
 		config.MaxHashLimit = 1024 * 1024 * 128 //128M
 	}
+	if config.SyncHashType == "" {
+		config.SyncHashType = model.HashMd5
+	}
+	if config.SyncLargeSize <= 0 {
+		config.SyncLargeSize = 1024 * 1024 * 64 //64M
+	}
 	if config.TrashSaveTime <= 0 {
 		config.TrashSaveTime = 30 * 24 * time.Hour
 	}
@@ -98,11 +107,147 @@ func checkAndResetConfig(ctx context.Context, config model.Config) (model.Config
 		config.ImageSaveFormat = imaging.JPEG
 	}
 
+	if config.UploadExpireTime <= 0 {
+		config.UploadExpireTime = 24 * time.Hour
+	}
+	if config.UploadClearCron == "" {
+		config.UploadClearCron = "0 * * * *"
+	}
+
+	if config.WatchDelay <= 0 {
+		config.WatchDelay = time.Second
+	}
+	for i := range config.Quotas {
+		config.Quotas[i].Path = util.ClearPath(ctx, path.Join("/", config.Quotas[i].Path))
+	}
+
+	for i := range config.EncryptPrefixKeys {
+		config.EncryptPrefixKeys[i].Path = util.ClearPath(ctx, path.Join("/", config.EncryptPrefixKeys[i].Path))
+	}
+
+	if config.CompressMinSize <= 0 {
+		config.CompressMinSize = 1024 //1K
+	}
+	if len(config.CompressMimes) == 0 {
+		config.CompressMimes = []string{"text/", "application/json", "application/xml", "application/javascript", "application/x-ndjson", "image/svg+xml"}
+	}
+
+	if config.AuditPath == "" {
+		config.AuditPath = "audit/audit.log"
+	}
+	if config.AuditMaxSize <= 0 {
+		config.AuditMaxSize = 100
+	}
+	if config.AuditMaxBackups <= 0 {
+		config.AuditMaxBackups = 10
+	}
+
+	if config.StorageType == "" {
+		config.StorageType = model.StorageLocal
+	}
+	if config.SymlinkPolicy == "" {
+		config.SymlinkPolicy = model.SymlinkFollowWithinRoot
+	}
+	if config.StorageType == model.StorageS3 && config.S3Region == "" {
+		config.S3Region = "us-east-1"
+	}
+
 	if config.Secret == "" {
 		logrus.WithContext(ctx).WithFields(logrus.Fields{}).Error("secret为空")
 		return config, fmt.Errorf("secret为空")
 	}
 
 	err := util.CreateFolderPath(ctx, model.FileBedPath)
-	return config, err
+	if err != nil {
+		return config, err
+	}
+	return checkAndResetBucket(ctx, config)
+}
+
+// checkAndResetBucket 命名床未配置的项沿用默认床的配置
+func checkAndResetBucket(ctx context.Context, config model.Config) (model.Config, error) {
+	defaultBucket := getDefaultBucket(config)
+	names := make(map[string]bool)
+	for i := range config.Buckets {
+		bucket := &config.Buckets[i]
+		if bucket.Name == "" || bucket.Name == "." || bucket.Name == ".." || strings.ContainsAny(bucket.Name, "/\\") {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"name": bucket.Name}).Error("床名非法")
+			return config, fmt.Errorf("床名非法: %+v", bucket.Name)
+		}
+		if names[bucket.Name] {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"name": bucket.Name}).Error("床名重复")
+			return config, fmt.Errorf("床名重复: %+v", bucket.Name)
+		}
+		names[bucket.Name] = true
+
+		if bucket.Root == "" {
+			bucket.Root = path.Join(model.BucketPath, bucket.Name)
+		}
+		if bucket.Secret == "" {
+			bucket.Secret = defaultBucket.Secret
+		}
+		if bucket.TrashSaveTime <= 0 {
+			bucket.TrashSaveTime = defaultBucket.TrashSaveTime
+		}
+		if bucket.ImageTargetSize <= 0 {
+			bucket.ImageTargetSize = defaultBucket.ImageTargetSize
+		}
+		if bucket.JpegMinQuality <= 0 || bucket.JpegMaxQuality <= 0 || bucket.JpegMaxQuality <= bucket.JpegMinQuality {
+			bucket.JpegMinQuality = defaultBucket.JpegMinQuality
+			bucket.JpegMaxQuality = defaultBucket.JpegMaxQuality
+		}
+		if bucket.ImageSaveFormat < imaging.JPEG || imaging.BMP < bucket.ImageSaveFormat {
+			bucket.ImageSaveFormat = defaultBucket.ImageSaveFormat
+		}
+		if bucket.EncryptKeyId == "" {
+			bucket.EncryptKeyId = defaultBucket.EncryptKeyId
+		}
+		for j := range bucket.Quotas {
+			bucket.Quotas[j].Path = util.ClearPath(ctx, path.Join("/", bucket.Quotas[j].Path))
+		}
+
+		err := util.CreateFolderPath(ctx, bucket.Root)
+		if err != nil {
+			return config, err
+		}
+	}
+	err := checkBucketRoot(ctx, config)
+	if err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// checkBucketRoot 各床的根目录不能相同或互相嵌套，否则一个床的文件会出现在另一个床里
+func checkBucketRoot(ctx context.Context, config model.Config) error {
+	buckets := append([]model.Bucket{getDefaultBucket(config)}, config.Buckets...)
+	roots := make([]string, len(buckets))
+	for i := range buckets {
+		root, err := filepath.Abs(buckets[i].Root)
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"root": buckets[i].Root, "err": err}).Error("床根目录非法")
+			return fmt.Errorf("床根目录非法: %+v", err)
+		}
+		roots[i] = root
+	}
+	for i := range roots {
+		for j := i + 1; j < len(roots); j++ {
+			if !isSubRoot(roots[i], roots[j]) && !isSubRoot(roots[j], roots[i]) {
+				continue
+			}
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"bucket": buckets[i].Name, "root": buckets[i].Root, "otherBucket": buckets[j].Name, "otherRoot": buckets[j].Root}).Error("床根目录重叠")
+			return fmt.Errorf("床根目录重叠: %+v与%+v", buckets[i].Root, buckets[j].Root)
+		}
+	}
+	return nil
+}
+
+func isSubRoot(parent, root string) bool {
+	if parent == root {
+		return true
+	}
+	if !strings.HasSuffix(parent, string(filepath.Separator)) {
+		parent += string(filepath.Separator)
+	}
+	return strings.HasPrefix(root, parent)
 }
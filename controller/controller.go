@@ -12,11 +12,23 @@ import (
 )
 
 func Controller() error {
+	engine := NewEngine()
+	err := engine.Run(model.ListenAddress)
+	if err != nil {
+		panic(fmt.Errorf("web服务启动，异常: %+v", err))
+	}
+	return nil
+}
+
+// NewEngine 注册全部路由，不监听端口
+func NewEngine() *gin.Engine {
 	engine := gin.Default()
+	engine.Use(bucket)
+	engine.Use(clientIp)
 	engine.Use(claims)
 	engine.Use(util.GinLog)
 
-	debug := engine.Group(util.DebugPath, validate)
+	debug := engine.Group(util.DebugPath, validateAdmin)
 	pprof.RouteRegister(debug, util.PprofPath)
 
 	engine.GET("/ping", util.Ping)
@@ -25,29 +37,58 @@ func Controller() error {
 	engine.Use(staticCache)
 	engine.StaticFS("/static", http.FS(static.StaticFile))
 
-	engine.Static(model.FileUrl, model.FileBedPath)
+	engine.GET(model.FileUrl+"/*path", getFile)
+	engine.HEAD(model.FileUrl+"/*path", getFile)
+	engine.GET(model.BucketFileUrl+"/:"+model.BucketKey+"/*path", getFile)
+	engine.HEAD(model.BucketFileUrl+"/:"+model.BucketKey+"/*path", getFile)
+
+	engine.GET(model.FileV2Url+"/*path", validateRest, restGetFile)
+	engine.HEAD(model.FileV2Url+"/*path", validateRest, restGetFile)
+	engine.PUT(model.FileV2Url+"/*path", validateRest, restPutFile)
+	engine.DELETE(model.FileV2Url+"/*path", validateRest, restDeleteFile)
 
 	engine.POST(model.AddUrlUrl, validate, addUrl)
 	engine.POST(model.AddFileUrl, validate, addFile)
 	engine.POST(model.RemoveFileUrl, validate, removeFile)
+	engine.POST(model.SetFileMetaUrl, validate, setFileMeta)
+	engine.POST(model.MoveFileUrl, validate, moveFile)
+	engine.POST(model.CopyFileUrl, validate, copyFile)
+	engine.POST(model.AddFolderUrl, validate, addFolder)
+	engine.POST(model.RemoveFolderUrl, validate, removeFolder)
+	engine.GET(model.ListFileVersionUrl, validate, listFileVersion)
+	engine.GET(model.GetFileVersionUrl, validate, getFileVersion)
+	engine.POST(model.RestoreFileVersionUrl, validate, restoreFileVersion)
 	engine.GET(model.GetFileCompleteInfoUrl, validate, getFileCompleteInfo)
 	engine.GET(model.ListFileSimpleInfoUrl, validate, listFileSimpleInfo)
 	engine.GET(model.ListLastFileInfoUrl, validate, listLastFileInfo)
+	engine.GET(model.ListFileHistoryUrl, validate, listFileHistory)
+	engine.GET(model.SearchAuditUrl, validateAdmin, searchAudit)
+	engine.GET(model.ManifestUrl, validate, getManifest)
+	engine.GET(model.SearchFileUrl, validate, searchFile)
+	engine.POST(model.RebuildFileIndexUrl, validate, rebuildFileIndex)
+	engine.GET(model.ListQuotaUsageUrl, validate, listQuotaUsage)
+	engine.GET(model.GetScrubReportUrl, validate, getScrubReport)
+	engine.POST(model.ReencryptFileUrl, validate, reencryptFile)
+
+	engine.POST(model.CreateMultipartUrl, validate, createMultipartUpload)
+	engine.POST(model.UploadMultipartPartUrl, validate, uploadMultipartPart)
+	engine.POST(model.CompleteMultipartUrl, validate, completeMultipartUpload)
+	engine.POST(model.AbortMultipartUrl, validate, abortMultipartUpload)
+	engine.OPTIONS(model.TusUploadUrl, tusOptions)
+	engine.POST(model.TusUploadUrl, validate, tusCreate)
+	engine.HEAD(model.TusUploadUrl+"/:id", validate, tusHead)
+	engine.PATCH(model.TusUploadUrl+"/:id", validate, tusPatch)
+	engine.DELETE(model.TusUploadUrl+"/:id", validate, tusDelete)
 
 	engine.POST(model.PushSyncFileUrl, validate, pushSyncFile)
 	engine.POST(model.PullSyncFileUrl, validate, pullSyncFile)
-
-	err := engine.Run(model.ListenAddress)
-	if err != nil {
-		panic(fmt.Errorf("web服务启动，异常: %+v", err))
-	}
-	return nil
+	return engine
 }
 
 func staticCache(c *gin.Context) {
 	if strings.HasPrefix(c.Request.RequestURI, "/static") {
 		c.Header("Cache-Control", "max-age=86400")
-	} else if strings.HasPrefix(c.Request.RequestURI, model.FileUrl) {
+	} else if strings.HasPrefix(c.Request.RequestURI, model.FileUrl) || strings.HasPrefix(c.Request.RequestURI, model.BucketFileUrl) {
 		c.Header("Cache-Control", "max-age=31536000")
 	}
 }
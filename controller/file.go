@@ -2,14 +2,103 @@ package controller
 
 import (
 	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/config"
 	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/cellargalaxy/go_file_bed/service"
 	"github.com/cellargalaxy/go_file_bed/service/controller"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"io"
+	"mime"
 	"net/http"
+	"path"
 	"strings"
 )
 
+func getFile(ctx *gin.Context) {
+	filePath := ctx.Param("path")
+	info, err := service.GetStorageInfo(ctx, filePath)
+	if err != nil {
+		ctx.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if info == nil || info.IsDir {
+		ctx.Status(http.StatusNotFound)
+		return
+	}
+	serveBedFile(ctx, info, filePath)
+}
+
+// serveBedFile 输出床里的文件内容，客户端支持时透传压缩存储的数据
+func serveBedFile(ctx *gin.Context, info *model.StorageInfo, filePath string) {
+	if config.Config.CompressEnable {
+		ctx.Header("Vary", "Accept-Encoding")
+		if serveGzipFile(ctx, info, filePath) {
+			return
+		}
+	}
+	reader, err := service.GetReadFile(ctx, filePath)
+	if err != nil {
+		ctx.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer reader.Close()
+	serveFile(ctx, info, reader)
+}
+
+func getFileVersion(ctx *gin.Context) {
+	var request model.FileVersionGetRequest
+	err := ctx.Bind(&request)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("下载历史版本，请求参数解析异常")
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"request": request}).Info("下载历史版本")
+	info, reader, err := service.GetFileVersion(ctx, request.Path, request.VersionId)
+	if err != nil {
+		ctx.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if info == nil {
+		ctx.Status(http.StatusNotFound)
+		return
+	}
+	defer reader.Close()
+	serveFile(ctx, info, reader)
+}
+
+// serveGzipFile 客户端支持gzip且不是范围请求时，直接透传压缩存储的数据
+func serveGzipFile(ctx *gin.Context, info *model.StorageInfo, filePath string) bool {
+	if ctx.GetHeader("Range") != "" || !strings.Contains(ctx.GetHeader("Accept-Encoding"), "gzip") {
+		return false
+	}
+	reader, err := service.GetGzipReadFile(ctx, filePath)
+	if err != nil || reader == nil {
+		return false
+	}
+	defer reader.Close()
+	contentType := mime.TypeByExtension(path.Ext(info.Name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	ctx.Header("Content-Encoding", "gzip")
+	ctx.DataFromReader(http.StatusOK, -1, contentType, reader, nil)
+	return true
+}
+
+func serveFile(ctx *gin.Context, info *model.StorageInfo, reader io.Reader) {
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		http.ServeContent(ctx.Writer, ctx.Request, info.Name, info.ModTime, seeker)
+		return
+	}
+	contentType := mime.TypeByExtension(path.Ext(info.Name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	ctx.DataFromReader(http.StatusOK, info.Size, contentType, reader, nil)
+}
+
 func addUrl(ctx *gin.Context) {
 	var request model.UrlAddRequest
 	err := ctx.Bind(&request)
@@ -32,9 +121,20 @@ func addFile(ctx *gin.Context) {
 		return
 	}
 	defer file.Close()
-	logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath, "filename": header.Filename, "raw": rawString}).Info("添加文件")
+	//tags可以重复传，metadata为json对象
+	tags := ctx.Request.MultipartForm.Value["tags"]
+	var metadata map[string]string
+	if metadataString := ctx.Request.FormValue("metadata"); metadataString != "" {
+		err = util.UnmarshalJsonString(metadataString, &metadata)
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("添加文件，解析metadata异常")
+			ctx.JSON(http.StatusOK, util.CreateResponseByErr(err))
+			return
+		}
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath, "filename": header.Filename, "raw": rawString, "tags": tags, "metadata": metadata}).Info("添加文件")
 
-	ctx.JSON(http.StatusOK, util.CreateResponse(controller.AddFile(ctx, filePath, file, strings.ToLower(rawString) == "true")))
+	ctx.JSON(http.StatusOK, util.CreateResponse(controller.AddFile(ctx, filePath, file, strings.ToLower(rawString) == "true", tags, metadata)))
 }
 
 func removeFile(ctx *gin.Context) {
@@ -73,6 +173,54 @@ func listFileSimpleInfo(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, util.CreateResponse(controller.ListFileSimpleInfo(ctx, request)))
 }
 
+func setFileMeta(ctx *gin.Context) {
+	var request model.FileMetaSetRequest
+	err := ctx.Bind(&request)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("设置文件元数据，请求参数解析异常")
+		ctx.JSON(http.StatusOK, util.CreateResponseByErr(err))
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"request": request}).Info("设置文件元数据")
+	ctx.JSON(http.StatusOK, util.CreateResponse(controller.SetFileMeta(ctx, request)))
+}
+
+func listFileHistory(ctx *gin.Context) {
+	var request model.FileHistoryListRequest
+	err := ctx.Bind(&request)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询上传历史，请求参数解析异常")
+		ctx.JSON(http.StatusOK, util.CreateResponseByErr(err))
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"request": request}).Info("查询上传历史")
+	ctx.JSON(http.StatusOK, util.CreateResponse(controller.ListFileHistory(ctx, request)))
+}
+
+func searchAudit(ctx *gin.Context) {
+	var request model.AuditSearchRequest
+	err := ctx.Bind(&request)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询审计记录，请求参数解析异常")
+		ctx.JSON(http.StatusOK, util.CreateResponseByErr(err))
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"request": request}).Info("查询审计记录")
+	ctx.JSON(http.StatusOK, util.CreateResponse(controller.SearchAudit(ctx, request)))
+}
+
+func searchFile(ctx *gin.Context) {
+	var request model.FileSearchRequest
+	err := ctx.Bind(&request)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("搜索文件，请求参数解析异常")
+		ctx.JSON(http.StatusOK, util.CreateResponseByErr(err))
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"request": request}).Info("搜索文件")
+	ctx.JSON(http.StatusOK, util.CreateResponse(controller.SearchFile(ctx, request)))
+}
+
 func listLastFileInfo(ctx *gin.Context) {
 	var request model.LastFileInfoListRequest
 	err := ctx.Bind(&request)
@@ -84,3 +232,171 @@ func listLastFileInfo(ctx *gin.Context) {
 	logrus.WithContext(ctx).WithFields(logrus.Fields{"request": request}).Info("查询最近文件信息")
 	ctx.JSON(http.StatusOK, util.CreateResponse(controller.ListLastFileInfo(ctx, request)))
 }
+
+func rebuildFileIndex(ctx *gin.Context) {
+	var request model.FileIndexRebuildRequest
+	err := ctx.Bind(&request)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("重建文件索引，请求参数解析异常")
+		ctx.JSON(http.StatusOK, util.CreateResponseByErr(err))
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"request": request}).Info("重建文件索引")
+	ctx.JSON(http.StatusOK, util.CreateResponse(controller.RebuildFileIndex(ctx, request)))
+}
+
+func moveFile(ctx *gin.Context) {
+	var request model.FileMoveRequest
+	err := ctx.Bind(&request)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("移动文件，请求参数解析异常")
+		ctx.JSON(http.StatusOK, util.CreateResponseByErr(err))
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"request": request}).Info("移动文件")
+	ctx.JSON(http.StatusOK, util.CreateResponse(controller.MoveFile(ctx, request)))
+}
+
+func copyFile(ctx *gin.Context) {
+	var request model.FileCopyRequest
+	err := ctx.Bind(&request)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("复制文件，请求参数解析异常")
+		ctx.JSON(http.StatusOK, util.CreateResponseByErr(err))
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"request": request}).Info("复制文件")
+	ctx.JSON(http.StatusOK, util.CreateResponse(controller.CopyFile(ctx, request)))
+}
+
+func addFolder(ctx *gin.Context) {
+	var request model.FolderAddRequest
+	err := ctx.Bind(&request)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("添加文件夹，请求参数解析异常")
+		ctx.JSON(http.StatusOK, util.CreateResponseByErr(err))
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"request": request}).Info("添加文件夹")
+	ctx.JSON(http.StatusOK, util.CreateResponse(controller.AddFolder(ctx, request)))
+}
+
+func removeFolder(ctx *gin.Context) {
+	var request model.FolderRemoveRequest
+	err := ctx.Bind(&request)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("删除文件夹，请求参数解析异常")
+		ctx.JSON(http.StatusOK, util.CreateResponseByErr(err))
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"request": request}).Info("删除文件夹")
+	ctx.JSON(http.StatusOK, util.CreateResponse(controller.RemoveFolder(ctx, request)))
+}
+
+func listFileVersion(ctx *gin.Context) {
+	var request model.FileVersionListRequest
+	err := ctx.Bind(&request)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询历史版本，请求参数解析异常")
+		ctx.JSON(http.StatusOK, util.CreateResponseByErr(err))
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"request": request}).Info("查询历史版本")
+	ctx.JSON(http.StatusOK, util.CreateResponse(controller.ListFileVersion(ctx, request)))
+}
+
+func restoreFileVersion(ctx *gin.Context) {
+	var request model.FileVersionRestoreRequest
+	err := ctx.Bind(&request)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("恢复历史版本，请求参数解析异常")
+		ctx.JSON(http.StatusOK, util.CreateResponseByErr(err))
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"request": request}).Info("恢复历史版本")
+	ctx.JSON(http.StatusOK, util.CreateResponse(controller.RestoreFileVersion(ctx, request)))
+}
+
+func listQuotaUsage(ctx *gin.Context) {
+	var request model.QuotaUsageListRequest
+	err := ctx.Bind(&request)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询配额用量，请求参数解析异常")
+		ctx.JSON(http.StatusOK, util.CreateResponseByErr(err))
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"request": request}).Info("查询配额用量")
+	ctx.JSON(http.StatusOK, util.CreateResponse(controller.ListQuotaUsage(ctx, request)))
+}
+
+func getScrubReport(ctx *gin.Context) {
+	var request model.ScrubReportGetRequest
+	err := ctx.Bind(&request)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询校验报告，请求参数解析异常")
+		ctx.JSON(http.StatusOK, util.CreateResponseByErr(err))
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"request": request}).Info("查询校验报告")
+	ctx.JSON(http.StatusOK, util.CreateResponse(controller.GetScrubReport(ctx, request)))
+}
+
+func reencryptFile(ctx *gin.Context) {
+	var request model.FileReencryptRequest
+	err := ctx.Bind(&request)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("重新加密文件，请求参数解析异常")
+		ctx.JSON(http.StatusOK, util.CreateResponseByErr(err))
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"request": request}).Info("重新加密文件")
+	ctx.JSON(http.StatusOK, util.CreateResponse(controller.ReencryptFile(ctx, request)))
+}
+
+func createMultipartUpload(ctx *gin.Context) {
+	var request model.MultipartUploadCreateRequest
+	err := ctx.Bind(&request)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("创建Multipart上传，请求参数解析异常")
+		ctx.JSON(http.StatusOK, util.CreateResponseByErr(err))
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"request": request}).Info("创建Multipart上传")
+	ctx.JSON(http.StatusOK, util.CreateResponse(controller.CreateMultipartUpload(ctx, request)))
+}
+
+func uploadMultipartPart(ctx *gin.Context) {
+	var request model.MultipartUploadPartRequest
+	err := ctx.BindQuery(&request)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("上传Multipart分片，请求参数解析异常")
+		ctx.JSON(http.StatusOK, util.CreateResponseByErr(err))
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"request": request, "length": ctx.Request.ContentLength}).Info("上传Multipart分片")
+	ctx.JSON(http.StatusOK, util.CreateResponse(controller.UploadMultipartPart(ctx, request, ctx.Request.Body)))
+}
+
+func completeMultipartUpload(ctx *gin.Context) {
+	var request model.MultipartUploadCompleteRequest
+	err := ctx.Bind(&request)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("完成Multipart上传，请求参数解析异常")
+		ctx.JSON(http.StatusOK, util.CreateResponseByErr(err))
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"request": request}).Info("完成Multipart上传")
+	ctx.JSON(http.StatusOK, util.CreateResponse(controller.CompleteMultipartUpload(ctx, request)))
+}
+
+func abortMultipartUpload(ctx *gin.Context) {
+	var request model.MultipartUploadAbortRequest
+	err := ctx.Bind(&request)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("取消Multipart上传，请求参数解析异常")
+		ctx.JSON(http.StatusOK, util.CreateResponseByErr(err))
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"request": request}).Info("取消Multipart上传")
+	ctx.JSON(http.StatusOK, util.CreateResponse(controller.AbortMultipartUpload(ctx, request)))
+}
@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/cellargalaxy/go_file_bed/service"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+const manifestContentType = "application/x-ndjson"
+
+// getManifest 以NDJSON流式返回路径下全部文件的清单，每行一个model.ManifestEntry
+func getManifest(ctx *gin.Context) {
+	var request model.ManifestGetRequest
+	err := ctx.Bind(&request)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询文件清单，请求参数解析异常")
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"request": request}).Info("查询文件清单")
+
+	ctx.Header("Content-Type", manifestContentType)
+	writer := bufio.NewWriter(ctx.Writer)
+	encoder := json.NewEncoder(writer)
+	var count int
+	err = service.WalkManifest(ctx, request.Path, func(entry model.ManifestEntry) error {
+		count++
+		return encoder.Encode(entry)
+	})
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"count": count, "err": err}).Error("查询文件清单，异常")
+		if !ctx.Writer.Written() {
+			//还没有输出任何内容时可以改为返回错误
+			ctx.Writer.Header().Del("Content-Type")
+			ctx.String(http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	err = writer.Flush()
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询文件清单，输出异常")
+		return
+	}
+	ctx.Status(http.StatusOK)
+}
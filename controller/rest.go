@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"errors"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/cellargalaxy/go_file_bed/service"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// validateRest 没有合法token时返回401，便于通用HTTP工具识别
+func validateRest(ctx *gin.Context) {
+	if util.GetClaims(ctx) == nil {
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+	}
+}
+
+// restGetFile GET返回文件内容，HEAD只返回大小、摘要与修改时间等头部
+func restGetFile(ctx *gin.Context) {
+	filePath := ctx.Param("path")
+	if service.IsReservedPath(ctx, filePath) {
+		ctx.Status(http.StatusNotFound)
+		return
+	}
+	info, err := service.GetStorageInfo(ctx, filePath)
+	if err != nil {
+		ctx.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if info == nil {
+		ctx.Status(http.StatusNotFound)
+		return
+	}
+	if info.IsDir {
+		ctx.String(http.StatusConflict, "路径为文件夹")
+		return
+	}
+	completeInfo, err := service.GetFileCompleteInfo(ctx, filePath)
+	if err != nil {
+		ctx.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if completeInfo != nil {
+		setRestHashHeader(ctx, completeInfo)
+	}
+	if ctx.Request.Method != http.MethodHead {
+		serveBedFile(ctx, info, filePath)
+		return
+	}
+	contentType := mime.TypeByExtension(path.Ext(info.Name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	ctx.Header("Content-Type", contentType)
+	ctx.Header("Content-Length", strconv.FormatInt(info.Size, 10))
+	ctx.Header("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	ctx.Header("Accept-Ranges", "bytes")
+	ctx.Status(http.StatusOK)
+}
+
+// restPutFile 请求体即文件内容，新建返回201，覆盖返回200；默认原样保存，raw参数为false时压缩图片
+func restPutFile(ctx *gin.Context) {
+	filePath := ctx.Param("path")
+	raw := strings.ToLower(ctx.Query("raw")) != "false"
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath, "raw": raw, "length": ctx.Request.ContentLength}).Info("REST上传文件")
+	if strings.HasSuffix(filePath, "/") {
+		ctx.String(http.StatusBadRequest, "路径不能以/结尾")
+		return
+	}
+	oldInfo, err := service.GetFileSimpleInfo(ctx, filePath)
+	if err != nil {
+		ctx.String(getRestErrorStatus(err), err.Error())
+		return
+	}
+	info, err := service.AddFile(ctx, filePath, ctx.Request.Body, raw, nil)
+	if err != nil {
+		ctx.String(getRestErrorStatus(err), err.Error())
+		return
+	}
+	if info == nil {
+		ctx.Status(http.StatusInternalServerError)
+		return
+	}
+	ctx.Header("Location", info.Url)
+	if oldInfo == nil {
+		ctx.JSON(http.StatusCreated, info)
+		return
+	}
+	ctx.JSON(http.StatusOK, info)
+}
+
+func restDeleteFile(ctx *gin.Context) {
+	filePath := ctx.Param("path")
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath}).Info("REST删除文件")
+	if service.IsReservedPath(ctx, filePath) {
+		ctx.Status(http.StatusNotFound)
+		return
+	}
+	info, err := service.GetFileSimpleInfo(ctx, filePath)
+	if err != nil {
+		ctx.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if info == nil {
+		ctx.Status(http.StatusNotFound)
+		return
+	}
+	if !info.IsFile {
+		ctx.String(http.StatusConflict, "路径为文件夹")
+		return
+	}
+	_, err = service.RemoveFile(ctx, filePath)
+	if err != nil {
+		ctx.String(getRestErrorStatus(err), err.Error())
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func setRestHashHeader(ctx *gin.Context, info *model.FileCompleteInfo) {
+	md5 := info.GetHash(model.HashMd5)
+	sha256 := info.GetHash(model.HashSha256)
+	crc32c := info.GetHash(model.HashCrc32c)
+	if md5 != "" {
+		ctx.Header("X-File-Md5", md5)
+	}
+	if sha256 != "" {
+		ctx.Header("X-File-Sha256", sha256)
+		ctx.Header("ETag", `"`+sha256+`"`)
+	}
+	if crc32c != "" {
+		ctx.Header("X-File-Crc32c", crc32c)
+	}
+}
+
+// getRestErrorStatus 按服务层错误的类别返回响应码
+func getRestErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, model.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, model.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, model.ErrReservedPath):
+		return http.StatusForbidden
+	case errors.Is(err, model.ErrInvalidPath):
+		return http.StatusBadRequest
+	case errors.Is(err, model.ErrPathConflict), errors.Is(err, model.ErrUploadConflict):
+		return http.StatusConflict
+	case errors.Is(err, model.ErrUploadNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, model.ErrUploadLocked):
+		return http.StatusLocked
+	default:
+		return http.StatusInternalServerError
+	}
+}
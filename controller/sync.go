@@ -17,7 +17,7 @@ func pushSyncFile(ctx *gin.Context) {
 		ctx.JSON(http.StatusOK, util.CreateResponseByErr(err))
 		return
 	}
-	logrus.WithContext(ctx).WithFields(logrus.Fields{"address": request.Address, "path": request.Path}).Info("push同步文件")
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"address": request.Address, "remoteBucket": request.RemoteBucket, "path": request.Path}).Info("push同步文件")
 	ctx.JSON(http.StatusOK, util.CreateResponse(controller.PushSyncFile(ctx, request)))
 }
 
@@ -29,6 +29,6 @@ func pullSyncFile(ctx *gin.Context) {
 		ctx.JSON(http.StatusOK, util.CreateResponseByErr(err))
 		return
 	}
-	logrus.WithContext(ctx).WithFields(logrus.Fields{"address": request.Address, "path": request.Path}).Info("pull同步文件")
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"address": request.Address, "remoteBucket": request.RemoteBucket, "path": request.Path}).Info("pull同步文件")
 	ctx.JSON(http.StatusOK, util.CreateResponse(controller.PullSyncFile(ctx, request)))
 }
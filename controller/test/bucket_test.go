@@ -0,0 +1,47 @@
+package test
+
+import (
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"strings"
+	"testing"
+)
+
+// TestBucketListFileSimpleInfo 命名床与默认床的文件互不可见，不存在的床直接拒绝
+func TestBucketListFileSimpleInfo(test *testing.T) {
+	ctx := util.GenCtx()
+	buckets := config.Config.Buckets
+	config.Config.Buckets = append(config.Config.Buckets, model.Bucket{Name: "bucket", Root: test.TempDir(), Secret: config.Config.Secret})
+	defer func() {
+		config.Config.Buckets = buckets
+	}()
+
+	client := newClient(test, "bucket")
+	_, err := client.AddFile(ctx, "/bucket_list/a.txt", strings.NewReader("aaa"), true)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	var request model.FileSimpleInfoListRequest
+	request.Path = "/bucket_list"
+	infos, err := client.ListFileSimpleInfo(ctx, request)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if len(infos) != 1 || infos[0].Path != "/bucket_list/a.txt" || !infos[0].IsFile {
+		test.Errorf("命名床罗列的文件不正确: %+v", infos)
+		test.FailNow()
+	}
+	if existFile(test, "/bucket_list/a.txt") {
+		test.Error("命名床的文件不应该出现在默认床")
+		test.FailNow()
+	}
+
+	_, err = newClient(test, "none_bucket").ListFileSimpleInfo(ctx, request)
+	if err == nil {
+		test.Error("不存在的床应该拒绝")
+		test.FailNow()
+	}
+}
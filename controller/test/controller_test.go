@@ -0,0 +1,60 @@
+package test
+
+import (
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/controller"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+var engine *gin.Engine
+
+// 接口跑在内存存储上，落在当前目录的索引与审计日志在结束后清掉
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	engine = controller.NewEngine()
+	code := m.Run()
+	os.RemoveAll(model.FileBedPath)
+	os.RemoveAll(path.Dir(config.Config.AuditPath))
+	os.Exit(code)
+}
+
+// doRequest token为false时不带Authorization
+func doRequest(test *testing.T, method, url string, body io.Reader, header map[string]string, token bool) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, url, body)
+	for key, value := range header {
+		request.Header.Set(key, value)
+	}
+	if token {
+		key, value := util.GenAuthorizationJWT(util.GenCtx(), time.Minute, config.Config.Secret)
+		request.Header.Set(key, value)
+	}
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// getFile 通过REST接口读取文件，不存在时第二个返回值为false
+func getFile(test *testing.T, filePath string) (string, bool) {
+	recorder := doRequest(test, http.MethodGet, model.FileV2Url+filePath, nil, nil, true)
+	if recorder.Code == http.StatusNotFound {
+		return "", false
+	}
+	checkStatus(test, recorder, http.StatusOK)
+	return recorder.Body.String(), true
+}
+
+func checkStatus(test *testing.T, recorder *httptest.ResponseRecorder, status int) {
+	if recorder.Code != status {
+		test.Errorf("状态码应为%+v: %+v %+v", status, recorder.Code, recorder.Body.String())
+		test.FailNow()
+	}
+}
@@ -0,0 +1,73 @@
+package test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// TestReencryptFile 默认床没有开启加密时拒绝；开启加密前写入的明文文件，重新加密后以当前密钥加密且仍能读出原内容。
+// 密文要在磁盘上检查，加密用本地存储的命名床
+func TestReencryptFile(test *testing.T) {
+	ctx := util.GenCtx()
+	_, err := newClient(test, "").ReencryptFile(ctx, model.FileReencryptRequest{})
+	if err == nil {
+		test.Error("没有开启加密时应该拒绝重新加密")
+		test.FailNow()
+	}
+
+	root := test.TempDir()
+	plainPath := path.Join(root, "/encrypt/a.txt")
+	err = os.MkdirAll(path.Dir(plainPath), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(plainPath, []byte("aaa"), 0644)
+	}
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	buckets := config.Config.Buckets
+	storageType := config.Config.StorageType
+	config.Config.Buckets = append(config.Config.Buckets, model.Bucket{Name: "encrypt_bucket", Root: root, Secret: config.Config.Secret, EncryptKeyId: "key"})
+	config.Config.StorageType = model.StorageLocal
+	config.Config.EncryptKeys = []model.EncryptKey{{Id: "key", Key: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("k"), 16))}}
+	defer func() {
+		config.Config.Buckets = buckets
+		config.Config.StorageType = storageType
+		config.Config.EncryptKeys = nil
+	}()
+
+	client := newClient(test, "encrypt_bucket")
+	_, err = client.ReencryptFile(ctx, model.FileReencryptRequest{})
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	//重新加密在后台执行，等磁盘上的文件带上密文头部
+	header := []byte("GFBE\x01\x03key")
+	for i := 0; i < 100; i++ {
+		data, err := ioutil.ReadFile(plainPath)
+		if err == nil && bytes.HasPrefix(data, header) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	data, err := ioutil.ReadFile(plainPath)
+	if err != nil || !bytes.HasPrefix(data, header) {
+		test.Errorf("文件没有被重新加密: %+v, %+v", data, err)
+		test.FailNow()
+	}
+	var buffer bytes.Buffer
+	err = client.DownloadFile(ctx, "/encrypt/a.txt", &buffer)
+	if err != nil || buffer.String() != "aaa" {
+		test.Errorf("重新加密后应能读出原内容: %+v, %+v", buffer.String(), err)
+		test.FailNow()
+	}
+}
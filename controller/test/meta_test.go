@@ -0,0 +1,58 @@
+package test
+
+import (
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"strings"
+	"testing"
+)
+
+// TestSetFileMeta 标签去重排序后保存，能在文件信息里读出并按标签搜索
+func TestSetFileMeta(test *testing.T) {
+	ctx := util.GenCtx()
+	client := newClient(test, "")
+	_, err := client.AddFile(ctx, "/meta/a.txt", strings.NewReader("aaa"), true)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	_, err = client.AddFile(ctx, "/meta/b.txt", strings.NewReader("bbb"), true)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	var setRequest model.FileMetaSetRequest
+	setRequest.Path = "/meta/a.txt"
+	setRequest.Tags = []string{"red", " blue ", "red", ""}
+	setRequest.Metadata = map[string]string{"owner": "cellargalaxy"}
+	_, err = client.SetFileMeta(ctx, setRequest)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+
+	var getRequest model.FileCompleteInfoGetRequest
+	getRequest.Path = "/meta/a.txt"
+	info, err := client.GetFileCompleteInfo(ctx, getRequest)
+	if err != nil || info == nil {
+		test.Error("文件信息应该存在", err)
+		test.FailNow()
+	}
+	if strings.Join(info.Tags, ",") != "blue,red" || info.Metadata["owner"] != "cellargalaxy" {
+		test.Errorf("文件元数据不正确: %+v", info.FileMeta)
+		test.FailNow()
+	}
+
+	var searchRequest model.FileSearchRequest
+	searchRequest.Path = "/meta"
+	searchRequest.Tags = []string{"blue"}
+	response, err := client.SearchFile(ctx, searchRequest)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if len(response.Infos) != 1 || response.Infos[0].Path != "/meta/a.txt" {
+		test.Errorf("按标签搜索的结果不正确: %+v", response.Infos)
+		test.FailNow()
+	}
+}
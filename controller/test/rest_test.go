@@ -0,0 +1,58 @@
+package test
+
+import (
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRestPutFile(test *testing.T) {
+	url := model.FileV2Url + "/rest/a.png"
+	recorder := doRequest(test, http.MethodPut, url, strings.NewReader("aaa"), nil, true)
+	checkStatus(test, recorder, http.StatusCreated)
+	if recorder.Header().Get("Location") == "" {
+		test.Error("新建应该返回Location")
+		test.FailNow()
+	}
+	recorder = doRequest(test, http.MethodPut, url, strings.NewReader("bbbb"), nil, true)
+	checkStatus(test, recorder, http.StatusOK)
+	//默认原样保存，图片拓展名的文件也不压缩
+	if data, ok := getFile(test, "/rest/a.png"); !ok || data != "bbbb" {
+		test.Error("覆盖后内容不正确")
+		test.FailNow()
+	}
+
+	recorder = doRequest(test, http.MethodHead, url, nil, nil, true)
+	checkStatus(test, recorder, http.StatusOK)
+	header := recorder.Header()
+	sha256 := "81cc5b17018674b401b42f35ba07bb79e211239c23bffe658da1577e3e646877"
+	if header.Get("Content-Length") != "4" || header.Get("X-File-Sha256") != sha256 || header.Get("ETag") != `"`+sha256+`"` || header.Get("Last-Modified") == "" {
+		test.Errorf("HEAD头部不正确: %+v", header)
+		test.FailNow()
+	}
+}
+
+func TestRestError(test *testing.T) {
+	recorder := doRequest(test, http.MethodPut, model.FileV2Url+"/rest_error/a.txt", strings.NewReader("aaa"), nil, false)
+	checkStatus(test, recorder, http.StatusUnauthorized)
+
+	recorder = doRequest(test, http.MethodPut, model.FileV2Url+"/rest_error/a.txt", strings.NewReader("aaa"), nil, true)
+	checkStatus(test, recorder, http.StatusCreated)
+	recorder = doRequest(test, http.MethodDelete, model.FileV2Url+"/rest_error", nil, nil, true)
+	checkStatus(test, recorder, http.StatusConflict)
+	recorder = doRequest(test, http.MethodPut, model.FileV2Url+"/rest_error", strings.NewReader("aaa"), nil, true)
+	checkStatus(test, recorder, http.StatusConflict)
+	recorder = doRequest(test, http.MethodPut, model.FileV2Url+"/rest_error/a.txt/b.txt", strings.NewReader("aaa"), nil, true)
+	checkStatus(test, recorder, http.StatusConflict)
+	recorder = doRequest(test, http.MethodPut, model.FileV2Url+model.TmpPath+"/a.txt", strings.NewReader("aaa"), nil, true)
+	checkStatus(test, recorder, http.StatusForbidden)
+
+	config.Config.Quotas = []model.Quota{{Path: "/rest_quota", MaxSize: 2}}
+	defer func() {
+		config.Config.Quotas = nil
+	}()
+	recorder = doRequest(test, http.MethodPut, model.FileV2Url+"/rest_quota/a.txt", strings.NewReader("aaa"), nil, true)
+	checkStatus(test, recorder, http.StatusInsufficientStorage)
+}
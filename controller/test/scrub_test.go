@@ -0,0 +1,91 @@
+package test
+
+import (
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/cellargalaxy/go_file_bed/service"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// TestGetScrubReport 默认床是内存存储，文件完好时报告里没有结果；内存存储无法绕过接口改动文件，
+// 损坏与缺失用本地存储的命名床，直接改写磁盘上的文件模拟
+func TestGetScrubReport(test *testing.T) {
+	ctx := util.GenCtx()
+	root := test.TempDir()
+	buckets := config.Config.Buckets
+	storageType := config.Config.StorageType
+	config.Config.Buckets = append(config.Config.Buckets, model.Bucket{Name: "scrub_bucket", Root: root, Secret: config.Config.Secret})
+	config.Config.StorageType = model.StorageLocal
+	defer func() {
+		config.Config.Buckets = buckets
+		config.Config.StorageType = storageType
+	}()
+
+	client := newClient(test, "scrub_bucket")
+	for _, filePath := range []string{"/scrub/a.txt", "/scrub/b.txt", "/scrub/c.txt"} {
+		_, err := client.AddFile(ctx, filePath, strings.NewReader("aaa"), true)
+		if err != nil {
+			test.Error(err)
+			test.FailNow()
+		}
+	}
+	config.Config.StorageType = storageType
+
+	//内容改写但大小与修改时间不变，只有重新计算摘要才能发现
+	corruptPath := path.Join(root, "/scrub/b.txt")
+	info, err := os.Stat(corruptPath)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	err = ioutil.WriteFile(corruptPath, []byte("bbb"), 0644)
+	if err == nil {
+		err = os.Chtimes(corruptPath, info.ModTime(), info.ModTime())
+	}
+	if err == nil {
+		err = os.Remove(path.Join(root, "/scrub/c.txt"))
+	}
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+
+	_, err = service.AddFile(ctx, "/scrub/a.txt", strings.NewReader("aaa"), true, nil)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+
+	service.ScrubFile(ctx)
+	report, err := newClient(test, "").GetScrubReport(ctx, model.ScrubReportGetRequest{})
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if report == nil || report.Checked < 1 || len(report.Results) != 0 {
+		test.Errorf("默认床的校验报告不正确: %+v", report)
+		test.FailNow()
+	}
+	report, err = client.GetScrubReport(ctx, model.ScrubReportGetRequest{})
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if report == nil || report.Checked < 1 || len(report.Results) != 2 {
+		test.Errorf("校验报告不正确: %+v", report)
+		test.FailNow()
+	}
+	statuses := make(map[string]string)
+	for i := range report.Results {
+		statuses[report.Results[i].Path] = report.Results[i].Status
+	}
+	if statuses["/scrub/b.txt"] != model.ScrubCorrupted || statuses["/scrub/c.txt"] != model.ScrubMissing {
+		test.Errorf("校验结果不正确: %+v", report.Results)
+		test.FailNow()
+	}
+}
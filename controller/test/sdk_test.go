@@ -0,0 +1,77 @@
+package test
+
+import (
+	"bytes"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/cellargalaxy/go_file_bed/sdk"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newClient 起一个httptest服务，返回连到bucket床的客户端，测试结束时关闭服务
+func newClient(test *testing.T, bucket string) *sdk.FileBedClient {
+	server := httptest.NewServer(engine)
+	test.Cleanup(server.Close)
+	client, err := sdk.NewDefaultBucketFileBedClient(util.GenCtx(), server.URL, config.Config.Secret, bucket)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	return client
+}
+
+func TestMatchFileHash(test *testing.T) {
+	ctx := util.GenCtx()
+	client := newClient(test, "")
+	_, err := client.AddFile(ctx, "/hash/a.txt", strings.NewReader("aaa"), true)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	hashes := map[string]string{
+		model.HashMd5:    "47bce5c74f589f4867dbd57e9ca9f808",
+		model.HashSha256: "9834876dcfb05cb167a5c24953eba58c4ac89b1adf57f28f2f9d09af107ee8f0",
+		model.HashCrc32c: "e397e7d9",
+	}
+	for hashType, hash := range hashes {
+		match, err := client.MatchFileHash(ctx, "/hash/a.txt", hashType, strings.ToUpper(hash))
+		if err != nil {
+			test.Error(err)
+			test.FailNow()
+		}
+		if !match {
+			test.Errorf("%+v摘要应该一致", hashType)
+			test.FailNow()
+		}
+	}
+	match, err := client.MatchFileHash(ctx, "/hash/a.txt", model.HashSha256, hashes[model.HashMd5])
+	if err != nil || match {
+		test.Errorf("摘要不同时不应该一致: %+v", err)
+		test.FailNow()
+	}
+	match, err = client.MatchFileHash(ctx, "/hash/none.txt", model.HashSha256, hashes[model.HashSha256])
+	if err != nil || match {
+		test.Errorf("文件不存在时不应该一致: %+v", err)
+		test.FailNow()
+	}
+}
+
+func TestUploadLarge(test *testing.T) {
+	ctx := util.GenCtx()
+	client := newClient(test, "")
+	//两个半分片，最后一个分片不满
+	data := bytes.Repeat([]byte("large"), sdk.UploadLargePartSize/2)
+	_, err := client.UploadLarge(ctx, "/large/large.txt", bytes.NewReader(data), true)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	content, ok := getFile(test, "/large/large.txt")
+	if !ok || content != string(data) {
+		test.Error("大文件上传后内容不一致", len(content))
+		test.FailNow()
+	}
+}
@@ -0,0 +1,47 @@
+package test
+
+import (
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"strings"
+	"testing"
+)
+
+// TestListFileStatInfo stat为true时按Stats返回大小与mime，文件夹大小为0
+func TestListFileStatInfo(test *testing.T) {
+	ctx := util.GenCtx()
+	client := newClient(test, "")
+	_, err := client.AddFile(ctx, "/stat_list/a.txt", strings.NewReader("aaa"), true)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	_, err = client.AddFile(ctx, "/stat_list/b/c.txt", strings.NewReader("cc"), true)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	var request model.FileSimpleInfoListRequest
+	request.Path = "/stat_list"
+	request.Sort = model.ListSortName
+	request.Stat = true
+	response, err := client.ListFileSimpleInfoPage(ctx, request)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if len(response.Stats) != 2 {
+		test.Errorf("罗列的元信息数量不正确: %+v", response)
+		test.FailNow()
+	}
+	file := response.Stats[0]
+	if file.Path != "/stat_list/a.txt" || !file.IsFile || file.Size != 3 || !strings.HasPrefix(file.MimeType, "text/plain") || file.ModTime.IsZero() {
+		test.Errorf("文件的元信息不正确: %+v", file)
+		test.FailNow()
+	}
+	folder := response.Stats[1]
+	if folder.Path != "/stat_list/b" || folder.IsFile || folder.Size != 0 {
+		test.Errorf("文件夹的元信息不正确: %+v", folder)
+		test.FailNow()
+	}
+}
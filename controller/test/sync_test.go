@@ -0,0 +1,63 @@
+package test
+
+import (
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/cellargalaxy/go_file_bed/service"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func existFile(test *testing.T, filePath string) bool {
+	_, ok := getFile(test, filePath)
+	return ok
+}
+
+// TestSyncPath 两端是同一个床，路径不以/开头或为空时也按床的根目录解析
+func TestSyncPath(test *testing.T) {
+	ctx := util.GenCtx()
+	server := httptest.NewServer(engine)
+	defer server.Close()
+	client, err := service.NewFileSyncClient(ctx, server.URL, config.Config.Secret, "", model.HashMd5)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	_, err = service.AddFile(ctx, "/sync/a.txt", strings.NewReader("aaa"), true, nil)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+
+	err = client.Push(ctx, "sync", "sync_push")
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if !existFile(test, "/sync_push/a.txt") || existFile(test, "/sync_push/sync") {
+		test.Error("不以/开头的路径没有按根目录解析")
+		test.FailNow()
+	}
+
+	err = client.Pull(ctx, "/sync_pull", "/sync")
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if !existFile(test, "/sync_pull/a.txt") || existFile(test, "/sync_pull/sync") {
+		test.Error("以/开头的路径没有按根目录解析")
+		test.FailNow()
+	}
+
+	err = client.Pull(ctx, "sync_root", "")
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if data, ok := getFile(test, "/sync_root/sync/a.txt"); !ok || data != "aaa" {
+		test.Error("空路径没有按根目录解析")
+		test.FailNow()
+	}
+}
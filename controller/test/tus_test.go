@@ -0,0 +1,118 @@
+package test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// breakReader 读出data后返回错误，模拟请求体中断
+type breakReader struct {
+	reader io.Reader
+}
+
+func (this *breakReader) Read(p []byte) (int, error) {
+	n, err := this.reader.Read(p)
+	if err == io.EOF {
+		return n, fmt.Errorf("模拟请求体中断")
+	}
+	return n, err
+}
+
+func createTusUpload(test *testing.T, filePath string, size int) string {
+	header := map[string]string{
+		"Tus-Resumable":   model.TusResumable,
+		"Upload-Length":   fmt.Sprint(size),
+		"Upload-Metadata": "path " + base64.StdEncoding.EncodeToString([]byte(filePath)) + ",raw " + base64.StdEncoding.EncodeToString([]byte("true")),
+	}
+	recorder := doRequest(test, http.MethodPost, model.TusUploadUrl, nil, header, true)
+	checkStatus(test, recorder, http.StatusCreated)
+	location := recorder.Header().Get("Location")
+	if !strings.HasPrefix(location, model.TusUploadUrl+"/") {
+		test.Errorf("Location不正确: %+v", location)
+		test.FailNow()
+	}
+	return location
+}
+
+func patchTusUpload(test *testing.T, location string, offset int, body io.Reader) *http.Response {
+	header := map[string]string{
+		"Tus-Resumable": model.TusResumable,
+		"Upload-Offset": fmt.Sprint(offset),
+		"Content-Type":  "application/offset+octet-stream",
+	}
+	return doRequest(test, http.MethodPatch, location, body, header, true).Result()
+}
+
+func TestTusResume(test *testing.T) {
+	location := createTusUpload(test, "/tus/resume.txt", 10)
+
+	response := patchTusUpload(test, location, 0, strings.NewReader("0123"))
+	if response.StatusCode != http.StatusNoContent || response.Header.Get("Upload-Offset") != "4" {
+		test.Errorf("写入部分数据后偏移量应为4: %+v %+v", response.StatusCode, response.Header)
+		test.FailNow()
+	}
+
+	response = patchTusUpload(test, location, 0, strings.NewReader("0123"))
+	if response.StatusCode != http.StatusConflict {
+		test.Errorf("偏移量不一致时应为409: %+v", response.StatusCode)
+		test.FailNow()
+	}
+
+	//请求体中断时没写完的分片不计入偏移量
+	patchTusUpload(test, location, 4, &breakReader{reader: strings.NewReader("45")})
+
+	recorder := doRequest(test, http.MethodHead, location, nil, map[string]string{"Tus-Resumable": model.TusResumable}, true)
+	checkStatus(test, recorder, http.StatusOK)
+	if recorder.Header().Get("Upload-Offset") != "4" || recorder.Header().Get("Upload-Length") != "10" {
+		test.Errorf("续传偏移量不正确: %+v", recorder.Header())
+		test.FailNow()
+	}
+
+	response = patchTusUpload(test, location, 4, strings.NewReader("456789"))
+	if response.StatusCode != http.StatusNoContent || response.Header.Get("Upload-Offset") != "10" {
+		test.Errorf("续传后偏移量应为10: %+v %+v", response.StatusCode, response.Header)
+		test.FailNow()
+	}
+	if data, ok := getFile(test, "/tus/resume.txt"); !ok || data != "0123456789" {
+		test.Error("续传后内容不一致")
+		test.FailNow()
+	}
+}
+
+func TestTusEmpty(test *testing.T) {
+	location := createTusUpload(test, "/tus/empty.txt", 0)
+	if data, ok := getFile(test, "/tus/empty.txt"); !ok || data != "" {
+		test.Error("空文件应直接完成上传")
+		test.FailNow()
+	}
+	//完成后上传任务即删除
+	recorder := doRequest(test, http.MethodHead, location, nil, map[string]string{"Tus-Resumable": model.TusResumable}, true)
+	checkStatus(test, recorder, http.StatusNotFound)
+}
+
+func TestTusError(test *testing.T) {
+	header := map[string]string{
+		"Tus-Resumable":   model.TusResumable,
+		"Upload-Length":   "3",
+		"Upload-Metadata": "path " + base64.StdEncoding.EncodeToString([]byte(model.TmpPath+"/a.txt")),
+	}
+	recorder := doRequest(test, http.MethodPost, model.TusUploadUrl, nil, header, true)
+	checkStatus(test, recorder, http.StatusForbidden)
+
+	config.Config.Quotas = []model.Quota{{Path: "/tus_quota", MaxSize: 2}}
+	defer func() {
+		config.Config.Quotas = nil
+	}()
+	location := createTusUpload(test, "/tus_quota/a.txt", 3)
+	response := patchTusUpload(test, location, 0, strings.NewReader("aaa"))
+	if response.StatusCode != http.StatusInsufficientStorage {
+		test.Errorf("超出配额时状态码应为507: %+v", response.StatusCode)
+		test.FailNow()
+	}
+}
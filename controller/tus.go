@@ -0,0 +1,185 @@
+package controller
+
+import (
+	"encoding/base64"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/cellargalaxy/go_file_bed/service"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+)
+
+const tusContentType = "application/offset+octet-stream"
+
+func tusOptions(ctx *gin.Context) {
+	ctx.Header("Tus-Resumable", model.TusResumable)
+	ctx.Header("Tus-Version", model.TusResumable)
+	ctx.Header("Tus-Extension", model.TusExtensions)
+	if config.Config.UploadMaxSize > 0 {
+		ctx.Header("Tus-Max-Size", strconv.FormatInt(config.Config.UploadMaxSize, 10))
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// tusCreate Upload-Metadata里的path为床里的路径，没有时取filename，raw为true时不压缩图片
+func tusCreate(ctx *gin.Context) {
+	if !checkTusResumable(ctx) {
+		return
+	}
+	size, err := strconv.ParseInt(ctx.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		ctx.String(http.StatusBadRequest, "Upload-Length非法")
+		return
+	}
+	if 0 < config.Config.UploadMaxSize && config.Config.UploadMaxSize < size {
+		ctx.Status(http.StatusRequestEntityTooLarge)
+		return
+	}
+	metadata := parseTusMetadata(ctx.GetHeader("Upload-Metadata"))
+	filePath := metadata["path"]
+	if filePath == "" {
+		filePath = metadata["filename"]
+	}
+	if filePath == "" {
+		ctx.String(http.StatusBadRequest, "Upload-Metadata缺少path")
+		return
+	}
+	raw := strings.ToLower(metadata["raw"]) == "true"
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath, "size": size, "raw": raw}).Info("tus创建上传任务")
+
+	upload, _, err := service.CreateUpload(ctx, filePath, size, raw)
+	if err != nil {
+		ctx.String(getRestErrorStatus(err), err.Error())
+		return
+	}
+	location := model.TusUploadUrl + "/" + upload.Id
+	if name := config.GetBucketName(ctx); name != "" {
+		location += "?" + model.BucketKey + "=" + neturl.QueryEscape(name)
+	}
+	ctx.Header("Location", location)
+	setTusUploadHeader(ctx, upload)
+	ctx.Status(http.StatusCreated)
+}
+
+func tusHead(ctx *gin.Context) {
+	if !checkTusResumable(ctx) {
+		return
+	}
+	upload, err := service.GetUpload(ctx, ctx.Param("id"))
+	if err != nil {
+		ctx.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if upload == nil || upload.Multipart {
+		ctx.Status(http.StatusNotFound)
+		return
+	}
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Upload-Length", strconv.FormatInt(upload.Size, 10))
+	setTusUploadHeader(ctx, upload)
+	ctx.Status(http.StatusOK)
+}
+
+func tusPatch(ctx *gin.Context) {
+	if !checkTusResumable(ctx) {
+		return
+	}
+	if ctx.ContentType() != tusContentType {
+		ctx.Status(http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(ctx.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		ctx.String(http.StatusBadRequest, "Upload-Offset非法")
+		return
+	}
+	id := ctx.Param("id")
+	upload, err := service.GetUpload(ctx, id)
+	if err != nil {
+		ctx.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if upload == nil || upload.Multipart {
+		ctx.Status(http.StatusNotFound)
+		return
+	}
+	if upload.Offset != offset {
+		ctx.Status(http.StatusConflict)
+		return
+	}
+	if upload.Size-offset < ctx.Request.ContentLength {
+		ctx.Status(http.StatusRequestEntityTooLarge)
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"id": id, "offset": offset, "length": ctx.Request.ContentLength}).Info("tus写入上传分片")
+
+	upload, _, err = service.WriteUpload(ctx, id, offset, ctx.Request.Body)
+	if err != nil {
+		ctx.String(getRestErrorStatus(err), err.Error())
+		return
+	}
+	setTusUploadHeader(ctx, upload)
+	ctx.Status(http.StatusNoContent)
+}
+
+func tusDelete(ctx *gin.Context) {
+	if !checkTusResumable(ctx) {
+		return
+	}
+	id := ctx.Param("id")
+	upload, err := service.GetUpload(ctx, id)
+	if err != nil {
+		ctx.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if upload == nil || upload.Multipart {
+		ctx.Status(http.StatusNotFound)
+		return
+	}
+	err = service.RemoveUpload(ctx, id)
+	if err != nil {
+		ctx.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func checkTusResumable(ctx *gin.Context) bool {
+	ctx.Header("Tus-Resumable", model.TusResumable)
+	if ctx.GetHeader("Tus-Resumable") == model.TusResumable {
+		return true
+	}
+	ctx.Header("Tus-Version", model.TusResumable)
+	ctx.Status(http.StatusPreconditionFailed)
+	return false
+}
+
+func setTusUploadHeader(ctx *gin.Context, upload *model.Upload) {
+	ctx.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	ctx.Header("Upload-Expires", upload.ExpireTime.UTC().Format(http.TimeFormat))
+}
+
+// parseTusMetadata Upload-Metadata为逗号分隔的`key base64(value)`
+func parseTusMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 {
+			continue
+		}
+		var value string
+		if len(fields) > 1 {
+			data, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				continue
+			}
+			value = string(data)
+		}
+		metadata[fields[0]] = value
+	}
+	return metadata
+}
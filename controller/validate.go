@@ -3,12 +3,45 @@ package controller
 import (
 	"github.com/cellargalaxy/go_common/util"
 	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/model"
 	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
 )
 
+// bucket 命名床的文件链接取`/bucket/<bucket>/...`的第一级路径，默认床的文件链接为`/file/...`，接口取bucket参数，不存在的床直接拒绝
+func bucket(ctx *gin.Context) {
+	var name string
+	if strings.HasPrefix(ctx.Request.URL.Path, model.BucketFileUrl+"/") {
+		name = strings.SplitN(strings.TrimPrefix(ctx.Request.URL.Path, model.BucketFileUrl+"/"), "/", 2)[0]
+		if _, ok := config.GetBucketByName(name); !ok || name == "" {
+			ctx.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+	} else if !strings.HasPrefix(ctx.Request.URL.Path, model.FileUrl+"/") {
+		name = ctx.Query(model.BucketKey)
+		if _, ok := config.GetBucketByName(name); !ok {
+			ctx.Abort()
+			ctx.JSON(http.StatusOK, util.CreateFailResponse("床不存在"))
+			return
+		}
+	}
+	ctx.Set(model.BucketKey, name)
+}
+
+// clientIp 客户端IP放入ctx，供审计记录使用
+func clientIp(ctx *gin.Context) {
+	ctx.Set(model.ClientIpKey, ctx.ClientIP())
+}
+
 func claims(ctx *gin.Context) {
-	util.ClaimsHttp(ctx, config.Config.Secret)
+	util.ClaimsHttp(ctx, config.GetBucket(ctx).Secret)
 }
 func validate(ctx *gin.Context) {
+	util.ValidateHttp(ctx, config.GetBucket(ctx).Secret)
+}
+
+// validateAdmin 只接受默认床密钥签发的token，命名床的token无权访问
+func validateAdmin(ctx *gin.Context) {
 	util.ValidateHttp(ctx, config.Config.Secret)
 }
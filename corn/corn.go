@@ -43,6 +43,33 @@ func Init(ctx context.Context) {
 		logrus.WithContext(ctx).WithFields(logrus.Fields{"trashClearJob": job, "entryId": entryId}).Info("定时任务，添加定时")
 	}
 
+	if config.Config.VersionClearCron != "" {
+		var job versionClearJob
+		entryId, err := cronObject.AddJob(config.Config.VersionClearCron, &job)
+		if err != nil {
+			panic(err)
+		}
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"versionClearJob": job, "entryId": entryId}).Info("定时任务，添加定时")
+	}
+
+	if config.Config.ScrubCron != "" {
+		var job scrubJob
+		entryId, err := cronObject.AddJob(config.Config.ScrubCron, &job)
+		if err != nil {
+			panic(err)
+		}
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"scrubJob": job, "entryId": entryId}).Info("定时任务，添加定时")
+	}
+
+	if config.Config.UploadClearCron != "" {
+		var job uploadClearJob
+		entryId, err := cronObject.AddJob(config.Config.UploadClearCron, &job)
+		if err != nil {
+			panic(err)
+		}
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"uploadClearJob": job, "entryId": entryId}).Info("定时任务，添加定时")
+	}
+
 	cronObject.Start()
 	logrus.WithContext(ctx).WithFields(logrus.Fields{}).Info("定时任务，添加完成")
 }
@@ -59,7 +86,7 @@ func (this pushSyncFileJob) String() string {
 func (this *pushSyncFileJob) Run() {
 	ctx := util.GenCtx()
 	logrus.WithContext(ctx).WithFields(logrus.Fields{"pushSyncFileJob": this}).Info("定时任务，执行任务开完")
-	service.PushSyncFile(ctx, this.Address, this.Secret, "")
+	service.PushSyncFile(ctx, this.Address, this.Secret, "", "", config.Config.SyncHashType)
 	logrus.WithContext(ctx).WithFields(logrus.Fields{"pushSyncFileJob": this}).Info("定时任务，执行任务完成")
 }
 
@@ -75,7 +102,7 @@ func (this pullSyncFileJob) String() string {
 func (this *pullSyncFileJob) Run() {
 	ctx := util.GenCtx()
 	logrus.WithContext(ctx).WithFields(logrus.Fields{"pullSyncFileJob": this}).Info("定时任务，执行任务开完")
-	service.PullSyncFile(ctx, this.Address, this.Secret, "")
+	service.PullSyncFile(ctx, this.Address, this.Secret, "", "", config.Config.SyncHashType)
 	logrus.WithContext(ctx).WithFields(logrus.Fields{"pullSyncFileJob": this}).Info("定时任务，执行任务完成")
 }
 
@@ -92,3 +119,45 @@ func (this *trashClearJob) Run() {
 	service.ClearTrash(ctx)
 	logrus.WithContext(ctx).WithFields(logrus.Fields{"trashClearJob": this}).Info("定时任务，执行任务完成")
 }
+
+type versionClearJob struct {
+}
+
+func (this versionClearJob) String() string {
+	return util.ToJsonString(this)
+}
+
+func (this *versionClearJob) Run() {
+	ctx := util.GenCtx()
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"versionClearJob": this}).Info("定时任务，执行任务开完")
+	service.ClearVersion(ctx)
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"versionClearJob": this}).Info("定时任务，执行任务完成")
+}
+
+type scrubJob struct {
+}
+
+func (this scrubJob) String() string {
+	return util.ToJsonString(this)
+}
+
+func (this *scrubJob) Run() {
+	ctx := util.GenCtx()
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"scrubJob": this}).Info("定时任务，执行任务开完")
+	service.ScrubFile(ctx)
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"scrubJob": this}).Info("定时任务，执行任务完成")
+}
+
+type uploadClearJob struct {
+}
+
+func (this uploadClearJob) String() string {
+	return util.ToJsonString(this)
+}
+
+func (this *uploadClearJob) Run() {
+	ctx := util.GenCtx()
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"uploadClearJob": this}).Info("定时任务，执行任务开完")
+	service.ClearUpload(ctx)
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"uploadClearJob": this}).Info("定时任务，执行任务完成")
+}
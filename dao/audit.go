@@ -0,0 +1,215 @@
+package dao
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/natefinch/lumberjack"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditStore 全部床共用一个审计日志，按大小滚动
+type auditStore struct {
+	lock    sync.Mutex
+	logPath string
+	logger  *lumberjack.Logger
+	lastId  int64
+}
+
+// auditReadSize 从日志末尾往前读取的块大小
+const auditReadSize = 64 * 1024
+
+var audit *auditStore
+var auditOnce sync.Once
+
+// getAuditStore 日志路径与滚动配置在第一次使用时确定，修改后需要重启；lastId从最新的一条记录恢复
+func getAuditStore() *auditStore {
+	auditOnce.Do(func() {
+		ctx := util.GenCtx()
+		logPath := config.Config.AuditPath
+		audit = &auditStore{logPath: logPath, logger: &lumberjack.Logger{
+			Filename:   logPath,
+			MaxSize:    config.Config.AuditMaxSize,
+			MaxBackups: config.Config.AuditMaxBackups,
+			MaxAge:     config.Config.AuditMaxAge,
+		}}
+		files, err := openAuditFiles(ctx, logPath)
+		if err != nil {
+			return
+		}
+		defer closeAuditFiles(files)
+		for i := range files {
+			stop, err := scanAuditFile(ctx, files[i], func(record model.AuditRecord) bool {
+				audit.lastId = record.Id
+				return false
+			})
+			if stop || err != nil {
+				break
+			}
+		}
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"lastId": audit.lastId}).Info("恢复审计日志，完成")
+	})
+	return audit
+}
+
+// InsertAudit Id取生成时间的ID，与上一条相同或更小时顺延，重启后仍然递增
+func InsertAudit(ctx context.Context, record model.AuditRecord) error {
+	store := getAuditStore()
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	record.Id = util.GenIdByTime(record.Time)
+	if record.Id <= store.lastId {
+		record.Id = store.lastId + 1
+	}
+	_, err := store.logger.Write(append(util.ToJson(record), '\n'))
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"record": record, "err": err}).Error("写入审计日志，异常")
+		return fmt.Errorf("写入审计日志，异常: %+v", err)
+	}
+	store.lastId = record.Id
+	return nil
+}
+
+// SelectAudit 从新到旧查找Id小于beforeId（为0时不限制）且满足match的审计记录，最多返回limit个，
+// 第二个返回值表示后面是否还有满足的记录；从最新的日志末尾往前读，找够一页就停止
+func SelectAudit(ctx context.Context, beforeId int64, limit int, match func(record model.AuditRecord) bool) ([]model.AuditRecord, bool, error) {
+	store := getAuditStore()
+	store.lock.Lock()
+	files, err := openAuditFiles(ctx, store.logPath)
+	store.lock.Unlock()
+	if err != nil {
+		return nil, false, err
+	}
+	defer closeAuditFiles(files)
+
+	var records []model.AuditRecord
+	var more bool
+	for i := range files {
+		stop, err := scanAuditFile(ctx, files[i], func(record model.AuditRecord) bool {
+			if beforeId > 0 && beforeId <= record.Id {
+				return true
+			}
+			if !match(record) {
+				return true
+			}
+			if len(records) >= limit {
+				more = true
+				return false
+			}
+			records = append(records, record)
+			return true
+		})
+		if err != nil {
+			return nil, false, err
+		}
+		if stop {
+			break
+		}
+	}
+	return records, more, nil
+}
+
+// openAuditFiles 按从新到旧打开当前日志与滚动出的日志；要持有store.lock打开，避免与滚动交错，打开后滚动不影响读取
+func openAuditFiles(ctx context.Context, logPath string) ([]*os.File, error) {
+	dir := path.Dir(logPath)
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"dir": dir, "err": err}).Error("查询审计日志，罗列日志异常")
+		return nil, fmt.Errorf("查询审计日志，罗列日志异常: %+v", err)
+	}
+	//滚动出的日志名为`audit-时间.log`，时间格式按字典序即按时间排序
+	name := path.Base(logPath)
+	ext := path.Ext(name)
+	prefix := strings.TrimSuffix(name, ext) + "-"
+	var backups []string
+	for i := range infos {
+		if infos[i].IsDir() || !strings.HasPrefix(infos[i].Name(), prefix) || !strings.HasSuffix(infos[i].Name(), ext) {
+			continue
+		}
+		backups = append(backups, infos[i].Name())
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	names := append([]string{name}, backups...)
+
+	files := make([]*os.File, 0, len(names))
+	for i := range names {
+		file, err := os.Open(path.Join(dir, names[i]))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			closeAuditFiles(files)
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"name": names[i], "err": err}).Error("查询审计日志，打开日志异常")
+			return nil, fmt.Errorf("查询审计日志，打开日志异常: %+v", err)
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+func closeAuditFiles(files []*os.File) {
+	for i := range files {
+		files[i].Close()
+	}
+}
+
+// scanAuditFile 从日志末尾往前按块读取，逐条从新到旧交给handle，handle返回false时停止，返回值表示是否已停止；
+// 跳过写到一半中断的行
+func scanAuditFile(ctx context.Context, file *os.File, handle func(record model.AuditRecord) bool) (bool, error) {
+	info, err := file.Stat()
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"name": file.Name(), "err": err}).Error("读取审计日志，异常")
+		return false, fmt.Errorf("读取审计日志，异常: %+v", err)
+	}
+	handleLine := func(line []byte) bool {
+		if len(bytes.TrimSpace(line)) == 0 {
+			return true
+		}
+		var record model.AuditRecord
+		err := util.UnmarshalJson(line, &record)
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"line": string(line), "err": err}).Warn("读取审计日志，跳过非法行")
+			return true
+		}
+		return handle(record)
+	}
+	buffer := make([]byte, auditReadSize)
+	var rest []byte //块开头不完整的行，与前一块的结尾拼接
+	for offset := info.Size(); offset > 0; {
+		size := int64(len(buffer))
+		if offset < size {
+			size = offset
+		}
+		offset -= size
+		_, err = file.ReadAt(buffer[:size], offset)
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"name": file.Name(), "err": err}).Error("读取审计日志，异常")
+			return false, fmt.Errorf("读取审计日志，异常: %+v", err)
+		}
+		lines := bytes.Split(append(buffer[:size:size], rest...), []byte{'\n'})
+		for i := len(lines) - 1; i > 0; i-- {
+			if !handleLine(lines[i]) {
+				return true, nil
+			}
+		}
+		rest = append([]byte(nil), lines[0]...)
+	}
+	return !handleLine(rest), nil
+}
@@ -0,0 +1,134 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/dao/storage"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+	"io"
+	"sync"
+)
+
+// bucketStore 每个床独立的存储驱动、文件索引与上传历史
+type bucketStore struct {
+	fileStorage model.StorageInter
+	fileIndexDb *bolt.DB
+	history     *historyStore
+}
+
+var bucketStores = make(map[string]*bucketStore)
+var bucketStoreLock sync.Mutex
+
+func init() {
+	ctx := util.GenCtx()
+	buckets := config.ListBucket()
+	for i := range buckets {
+		getBucketStore(config.SetBucket(ctx, buckets[i].Name))
+	}
+}
+
+// getBucketStore 获取ctx所在床的存储，配置热更新新增的床在第一次使用时创建；创建失败时不缓存，下次使用时重试
+func getBucketStore(ctx context.Context) (*bucketStore, error) {
+	bucket := config.GetBucket(ctx)
+	bucketStoreLock.Lock()
+	store, ok := bucketStores[bucket.Name]
+	if !ok {
+		var err error
+		store, err = createBucketStore(ctx, bucket)
+		if err != nil {
+			bucketStoreLock.Unlock()
+			return nil, err
+		}
+		bucketStores[bucket.Name] = store
+	}
+	bucketStoreLock.Unlock()
+	if !ok {
+		initBucketStore(config.SetBucket(ctx, bucket.Name))
+	}
+	return store, nil
+}
+
+func createBucketStore(ctx context.Context, bucket model.Bucket) (*bucketStore, error) {
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"bucket": bucket.Name, "root": bucket.Root}).Info("创建床存储")
+	fileStorage, err := storage.NewStorage(ctx, config.Config, bucket, lookupFileIndexSize)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"bucket": bucket.Name, "err": err}).Error("创建床存储，创建存储驱动异常")
+		return nil, fmt.Errorf("创建床存储，创建存储驱动异常: %+v", err)
+	}
+	fileIndexDb, err := initFileIndex(ctx, bucket.Root)
+	if err != nil {
+		return nil, err
+	}
+	return &bucketStore{fileStorage: fileStorage, fileIndexDb: fileIndexDb, history: initHistory(ctx, bucket.Root)}, nil
+}
+
+func initBucketStore(ctx context.Context) {
+	clearTmpFile(ctx)
+	if isFileIndexBuilt(ctx) {
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"bucket": config.GetBucketName(ctx)}).Info("文件索引未构建，异步构建")
+	go RebuildFileIndex(config.SetBucket(util.GenCtx(), config.GetBucketName(ctx)))
+}
+
+// getFileStorage 床存储创建失败时返回的存储驱动每个操作都返回创建时的错误
+func getFileStorage(ctx context.Context) model.StorageInter {
+	store, err := getBucketStore(ctx)
+	if err != nil {
+		return &bucketErrorStorage{err: err}
+	}
+	return store.fileStorage
+}
+
+func viewFileIndex(ctx context.Context, fn func(tx *bolt.Tx) error) error {
+	store, err := getBucketStore(ctx)
+	if err != nil {
+		return err
+	}
+	return store.fileIndexDb.View(fn)
+}
+
+func updateFileIndex(ctx context.Context, fn func(tx *bolt.Tx) error) error {
+	store, err := getBucketStore(ctx)
+	if err != nil {
+		return err
+	}
+	return store.fileIndexDb.Update(fn)
+}
+
+// bucketErrorStorage 代替没能创建的床存储，调用方照常处理返回的错误，不会因为一个床不可用而退出
+type bucketErrorStorage struct {
+	err error
+}
+
+func (this *bucketErrorStorage) Stat(ctx context.Context, fileOrFolderPath string) (*model.StorageInfo, error) {
+	return nil, this.err
+}
+
+func (this *bucketErrorStorage) List(ctx context.Context, folderPath string) ([]model.StorageInfo, error) {
+	return nil, this.err
+}
+
+func (this *bucketErrorStorage) Read(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	return nil, this.err
+}
+
+func (this *bucketErrorStorage) Write(ctx context.Context, filePath string, reader io.Reader) (int64, error) {
+	return 0, this.err
+}
+
+func (this *bucketErrorStorage) Remove(ctx context.Context, filePath string) error {
+	return this.err
+}
+
+func (this *bucketErrorStorage) Move(ctx context.Context, formPath, toPath string) error {
+	return this.err
+}
+
+func (this *bucketErrorStorage) Mkdir(ctx context.Context, folderPath string) error {
+	return this.err
+}
@@ -0,0 +1,89 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+)
+
+// ReencryptFile 按当前的密钥配置重新加密床里的全部文件，包括回收站、历史版本与去重blob，返回重新加密的文件数
+func ReencryptFile(ctx context.Context) (int, error) {
+	storage, ok := getFileStorage(ctx).(model.StorageReencryptInter)
+	if !ok {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{}).Error("重新加密文件，未开启加密")
+		return 0, fmt.Errorf("重新加密文件，未开启加密")
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{}).Info("重新加密文件，开始")
+	count := 0
+	err := reencryptFolder(ctx, storage, "/", &count)
+	if err != nil {
+		return count, err
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"count": count}).Info("重新加密文件，完成")
+	return count, nil
+}
+
+func reencryptFolder(ctx context.Context, storage model.StorageReencryptInter, folderPath string, count *int) error {
+	infos, err := getFileStorage(ctx).List(ctx, folderPath)
+	if err != nil {
+		return err
+	}
+	for i := range infos {
+		if infos[i].Path == model.TmpPath || infos[i].Path == model.FileIndexPath {
+			continue
+		}
+		if infos[i].IsDir {
+			err = reencryptFolder(ctx, storage, infos[i].Path, count)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		reencrypted, err := storage.Reencrypt(ctx, infos[i].Path)
+		if err != nil {
+			return err
+		}
+		if !reencrypted {
+			continue
+		}
+		*count++
+		//重新加密会改变修改时间，同步到索引，避免被当成外部修改
+		err = refreshFileIndexModTime(ctx, infos[i].Path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func refreshFileIndexModTime(ctx context.Context, bedPath string) error {
+	index, err := selectFileIndex(ctx, bedPath)
+	if index == nil || err != nil {
+		return err
+	}
+	info, err := getFileStorage(ctx).Stat(ctx, bedPath)
+	if info == nil || err != nil {
+		return err
+	}
+	index.ModTime = info.ModTime
+	return insertFileIndex(ctx, *index)
+}
+
+func refreshMovedFileIndexModTime(ctx context.Context, bedPath string) error {
+	indexes, err := listFileIndex(ctx, bedPath)
+	if err != nil {
+		return err
+	}
+	err = refreshFileIndexModTime(ctx, bedPath)
+	if err != nil {
+		return err
+	}
+	for i := range indexes {
+		err = refreshFileIndexModTime(ctx, indexes[i].Path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -5,21 +5,76 @@ import (
 	"fmt"
 	"github.com/cellargalaxy/go_common/util"
 	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/dao/storage"
 	"github.com/cellargalaxy/go_file_bed/model"
 	"github.com/sirupsen/logrus"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
-	"strings"
 )
 
+// clearTmpFile 清理上次进程异常退出遗留的临时文件
+func clearTmpFile(ctx context.Context) {
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"tmpPath": model.TmpPath}).Info("清理临时文件")
+	err := deleteAll(ctx, model.TmpPath)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Warn("清理临时文件，异常")
+	}
+	if config.Config.StorageType != model.StorageS3 {
+		return
+	}
+	//S3写入前暂存在本地床根目录的临时路径下
+	err = os.RemoveAll(path.Join(config.GetBucket(ctx).Root, model.TmpPath))
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Warn("清理临时文件，清理本地暂存异常")
+	}
+}
+
+func deleteAll(ctx context.Context, bedPath string) error {
+	pathInfo, err := getFileStorage(ctx).Stat(ctx, bedPath)
+	if pathInfo == nil || err != nil {
+		return err
+	}
+	if pathInfo.IsDir {
+		files, err := getFileStorage(ctx).List(ctx, bedPath)
+		if err != nil {
+			return err
+		}
+		for i := range files {
+			err = deleteAll(ctx, files[i].Path)
+			if err != nil {
+				return err
+			}
+		}
+		pathInfo, err = getFileStorage(ctx).Stat(ctx, bedPath)
+		if pathInfo == nil || err != nil {
+			return err
+		}
+	}
+	err = getFileStorage(ctx).Remove(ctx, bedPath)
+	if err != nil {
+		return err
+	}
+	return deleteFileIndex(ctx, bedPath)
+}
+
 func InsertFile(ctx context.Context, filePath string, reader io.Reader) (*model.FileSimpleInfo, error) {
-	bedPath, err := createBedPath(ctx, filePath)
+	bedPath := createBedPath(ctx, filePath)
+	reader = util.NewTimeoutReader(reader, config.Config.Timeout)
+	writer := newFileIndexWriter(ctx)
+	_, err := getFileStorage(ctx).Write(ctx, bedPath, io.TeeReader(reader, writer))
 	if err != nil {
 		return nil, err
 	}
-	reader = util.NewTimeoutReader(reader, config.Config.Timeout)
-	err = util.WriteFileWithReader(ctx, bedPath, reader)
+	index := writer.createFileIndex(ctx, bedPath)
+	//索引的修改时间与文件一致，监听文件夹时据此区分接口写入与外部写入
+	info, _ := getFileStorage(ctx).Stat(ctx, bedPath)
+	if info != nil {
+		index.ModTime = info.ModTime
+		index.StoredSize = getStoredSize(*info)
+	}
+	err = insertFileIndex(ctx, index)
 	if err != nil {
 		return nil, err
 	}
@@ -27,58 +82,86 @@ func InsertFile(ctx context.Context, filePath string, reader io.Reader) (*model.
 }
 
 func DeleteFile(ctx context.Context, filePath string) (*model.FileSimpleInfo, error) {
-	bedPath, err := createBedPath(ctx, filePath)
-	if err != nil {
-		return nil, err
-	}
+	bedPath := createBedPath(ctx, filePath)
 
 	info, err := SelectFileSimpleInfo(ctx, filePath)
 	if info == nil || err != nil {
 		return info, err
 	}
 
-	err = deleteFile(ctx, bedPath)
-	return info, err
+	err = getFileStorage(ctx).Remove(ctx, bedPath)
+	if err != nil {
+		return info, err
+	}
+	deleteFileIndex(ctx, bedPath)
+	return info, nil
 }
 
-func deleteFile(ctx context.Context, bedPath string) error {
-	err := util.RemoveFile(ctx, bedPath)
+func InsertFolder(ctx context.Context, folderPath string) (*model.FileSimpleInfo, error) {
+	bedPath := createBedPath(ctx, folderPath)
+	err := getFileStorage(ctx).Mkdir(ctx, bedPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return SelectFileSimpleInfo(ctx, folderPath)
+}
 
-	//将`/aaa/bbb/text.txt`变为`/aaa/bbb/`
-	folderPath, _ := path.Split(bedPath)
-	//将`/aaa/bbb/`变为`/aaa/bbb`
-	folderPath = util.ClearPath(ctx, folderPath)
-	for i := 0; i < 1024; i++ {
-		logrus.WithContext(ctx).WithFields(logrus.Fields{"folderPath": folderPath}).Info("删除文件，删除父文件夹")
-		files, err := util.ListFile(ctx, folderPath)
-		if err != nil {
-			return err
-		}
-		if len(files) > 0 {
-			logrus.WithContext(ctx).WithFields(logrus.Fields{"folderPath": folderPath}).Info("删除文件，父文件夹不为空")
-			return nil
-		}
-		err = util.RemoveFile(ctx, folderPath)
-		if err != nil {
-			return err
-		}
-		//将`/aaa/bbb`变为`/aaa`
-		//如果上面不将`/aaa/bbb/`变为`/aaa/bbb`
-		//这里`/aaa/bbb/`依然会返回`/aaa/bbb/`
-		folderPath = path.Dir(folderPath)
+// DeleteFolder 递归删除文件夹及其下全部文件
+func DeleteFolder(ctx context.Context, folderPath string) error {
+	bedPath := createBedPath(ctx, folderPath)
+	return deleteAll(ctx, bedPath)
+}
+
+// DeleteEmptyFolder 只删除空文件夹，不存在、不是文件夹或不为空时不做改动，返回是否删除了
+func DeleteEmptyFolder(ctx context.Context, folderPath string) (bool, error) {
+	bedPath := createBedPath(ctx, folderPath)
+	pathInfo, err := getFileStorage(ctx).Stat(ctx, bedPath)
+	if pathInfo == nil || !pathInfo.IsDir || err != nil {
+		return false, err
+	}
+	files, err := getFileStorage(ctx).List(ctx, bedPath)
+	if len(files) > 0 || err != nil {
+		return false, err
+	}
+	err = getFileStorage(ctx).Remove(ctx, bedPath)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func SelectStorageInfo(ctx context.Context, fileOrFolderPath string) (*model.StorageInfo, error) {
+	bedPath := createBedPath(ctx, fileOrFolderPath)
+	return getFileStorage(ctx).Stat(ctx, bedPath)
+}
+
+// SelectFolderStorageInfo 罗列文件夹下的存储信息，不过滤保留路径，供内部使用；有有效索引的文件取索引里的文件大小
+func SelectFolderStorageInfo(ctx context.Context, folderPath string) ([]model.StorageInfo, error) {
+	bedPath := createBedPath(ctx, folderPath)
+	pathInfo, err := getFileStorage(ctx).Stat(ctx, bedPath)
+	if pathInfo == nil || err != nil {
+		return nil, err
 	}
-	return err
+	if !pathInfo.IsDir {
+		return nil, nil
+	}
+	infos, err := getFileStorage(ctx).List(ctx, bedPath)
+	if err != nil {
+		return nil, err
+	}
+	err = resolveStorageInfoSize(ctx, infos)
+	if err != nil {
+		return nil, err
+	}
+	return infos, nil
 }
 
 func SelectFileSimpleInfo(ctx context.Context, fileOrFolderPath string) (*model.FileSimpleInfo, error) {
-	bedPath, err := createBedPath(ctx, fileOrFolderPath)
+	bedPath := createBedPath(ctx, fileOrFolderPath)
+	pathInfo, err := getFileStorage(ctx).Stat(ctx, bedPath)
 	if err != nil {
 		return nil, err
 	}
-	pathInfo := util.GetPathInfo(ctx, bedPath)
 	if pathInfo == nil {
 		logrus.WithContext(ctx).WithFields(logrus.Fields{}).Warn("查询文件简单信息，路径不存在")
 		return nil, nil
@@ -86,65 +169,113 @@ func SelectFileSimpleInfo(ctx context.Context, fileOrFolderPath string) (*model.
 
 	var info model.FileSimpleInfo
 	info.Path = fileOrFolderPath
-	info.Name = pathInfo.Name()
-	info.IsFile = !pathInfo.IsDir()
+	info.Name = pathInfo.Name
+	info.IsFile = !pathInfo.IsDir
 	return &info, nil
 }
 
+// SelectFileCompleteInfo 索引的大小与修改时间和存储一致时直接使用索引，否则按存储重新计算并更新索引
 func SelectFileCompleteInfo(ctx context.Context, fileOrFolderPath string) (*model.FileCompleteInfo, error) {
-	bedPath, err := createBedPath(ctx, fileOrFolderPath)
+	bedPath := createBedPath(ctx, fileOrFolderPath)
+	pathInfo, err := getFileStorage(ctx).Stat(ctx, bedPath)
 	if err != nil {
 		return nil, err
 	}
-	pathInfo := util.GetPathInfo(ctx, bedPath)
 	if pathInfo == nil {
 		logrus.WithContext(ctx).WithFields(logrus.Fields{}).Warn("查询文件完整信息，路径不存在")
 		return nil, nil
 	}
+	var index *model.FileIndex
+	if !pathInfo.IsDir {
+		index, err = selectFileIndex(ctx, bedPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if isFileIndexFresh(index, *pathInfo) {
+		var info model.FileCompleteInfo
+		info.Path = fileOrFolderPath
+		info.Name = path.Base(bedPath)
+		info.IsFile = true
+		info.Size = index.Size
+		info.StoredSize = index.GetStoredSize()
+		info.Count = 1
+		info.Md5 = index.Md5
+		info.Sha256 = index.Sha256
+		info.Crc32c = index.Crc32c
+		info.FileMeta = index.FileMeta
+		return &info, nil
+	}
 
 	var info model.FileCompleteInfo
 	info.Path = fileOrFolderPath
-	info.Name = pathInfo.Name()
-	info.IsFile = !pathInfo.IsDir()
+	info.Name = pathInfo.Name
+	info.IsFile = !pathInfo.IsDir
 
-	if !pathInfo.IsDir() {
-		info.Size = pathInfo.Size()
+	if !pathInfo.IsDir {
+		info.Size = pathInfo.Size
+		info.StoredSize = getStoredSize(*pathInfo)
 		info.Count = 1
 
-		info.Md5 = "out_max_hash_limit"
+		//不在索引里或索引已过期的文件（如外部写入）需要现读现算，超过上限时不计算
+		info.Md5 = model.OutMaxHashLimit
+		info.Sha256 = model.OutMaxHashLimit
+		info.Crc32c = model.OutMaxHashLimit
+		if index != nil {
+			info.FileMeta = index.FileMeta
+		}
 		if info.Size <= config.Config.MaxHashLimit {
-			md5, err := util.GetFileMd5(ctx, bedPath)
+			oldIndex := index
+			index, err := createFileIndex(ctx, *pathInfo)
+			if err != nil {
+				return nil, err
+			}
+			if oldIndex != nil {
+				index.Uploader = oldIndex.Uploader
+				index.FileMeta = oldIndex.FileMeta
+			}
+			err = insertFileIndex(ctx, *index)
 			if err != nil {
 				return nil, err
 			}
-			info.Md5 = md5
+			info.Md5 = index.Md5
+			info.Sha256 = index.Sha256
+			info.Crc32c = index.Crc32c
 		}
 
 		return &info, nil
 	}
 
-	size, count, err := selectFolderSizeAndCount(ctx, bedPath)
+	var size int64
+	var storedSize int64
+	var count int32
+	if isFileIndexBuilt(ctx) {
+		size, storedSize, count, err = selectFileIndexSizeAndCount(ctx, bedPath)
+	} else {
+		size, storedSize, count, err = selectFolderSizeAndCount(ctx, bedPath)
+	}
 	if err != nil {
 		return nil, err
 	}
 	info.Size = size
+	info.StoredSize = storedSize
 	info.Count = count
 	return &info, nil
 }
 
 func SelectFolderSimpleInfo(ctx context.Context, folderPath string) ([]model.FileSimpleInfo, error) {
-	bedPath, err := createBedPath(ctx, folderPath)
+	bedPath := createBedPath(ctx, folderPath)
+	pathInfo, err := getFileStorage(ctx).Stat(ctx, bedPath)
 	if err != nil {
 		return nil, err
 	}
-	pathInfo := util.GetPathInfo(ctx, bedPath)
 	if pathInfo == nil {
 		logrus.WithContext(ctx).WithFields(logrus.Fields{}).Warn("查询文件简单信息，路径不存在")
 		return nil, nil
 	}
 
 	var infos []model.FileSimpleInfo
-	if !pathInfo.IsDir() {
+	if !pathInfo.IsDir {
 		info, err := SelectFileSimpleInfo(ctx, folderPath)
 		if info == nil || err != nil {
 			return nil, err
@@ -153,34 +284,36 @@ func SelectFolderSimpleInfo(ctx context.Context, folderPath string) ([]model.Fil
 		return infos, nil
 	}
 
-	files, err := util.ListFile(ctx, bedPath)
+	files, err := getFileStorage(ctx).List(ctx, bedPath)
 	if err != nil {
 		return nil, err
 	}
 	for _, childFile := range files {
-		childFilePath := path.Join(folderPath, childFile.Name())
-		info, err := SelectFileSimpleInfo(ctx, childFilePath)
-		if info == nil || err != nil {
+		if model.IsReservedPath(childFile.Path) {
 			continue
 		}
-		infos = append(infos, *info)
+		var info model.FileSimpleInfo
+		info.Path = path.Join(folderPath, childFile.Name)
+		info.Name = childFile.Name
+		info.IsFile = !childFile.IsDir
+		infos = append(infos, info)
 	}
 	return infos, nil
 }
 
 func SelectFolderCompleteInfo(ctx context.Context, folderPath string) ([]model.FileCompleteInfo, error) {
-	bedPath, err := createBedPath(ctx, folderPath)
+	bedPath := createBedPath(ctx, folderPath)
+	pathInfo, err := getFileStorage(ctx).Stat(ctx, bedPath)
 	if err != nil {
 		return nil, err
 	}
-	pathInfo := util.GetPathInfo(ctx, bedPath)
 	if pathInfo == nil {
 		logrus.WithContext(ctx).WithFields(logrus.Fields{}).Warn("查询文件完整信息，路径不存在")
 		return nil, nil
 	}
 
 	var infos []model.FileCompleteInfo
-	if !pathInfo.IsDir() {
+	if !pathInfo.IsDir {
 		info, err := SelectFileCompleteInfo(ctx, folderPath)
 		if info == nil || err != nil {
 			return nil, err
@@ -189,12 +322,15 @@ func SelectFolderCompleteInfo(ctx context.Context, folderPath string) ([]model.F
 		return infos, nil
 	}
 
-	files, err := util.ListFile(ctx, bedPath)
+	files, err := getFileStorage(ctx).List(ctx, bedPath)
 	if err != nil {
 		return nil, err
 	}
 	for _, childFile := range files {
-		childFilePath := path.Join(folderPath, childFile.Name())
+		if model.IsReservedPath(childFile.Path) {
+			continue
+		}
+		childFilePath := path.Join(folderPath, childFile.Name)
 		info, err := SelectFileCompleteInfo(ctx, childFilePath)
 		if info == nil || err != nil {
 			continue
@@ -204,85 +340,88 @@ func SelectFolderCompleteInfo(ctx context.Context, folderPath string) ([]model.F
 	return infos, nil
 }
 
-func selectFolderSizeAndCount(ctx context.Context, folderPath string) (int64, int32, error) {
-	files, err := util.ListFile(ctx, folderPath)
+func selectFolderSizeAndCount(ctx context.Context, folderPath string) (int64, int64, int32, error) {
+	files, err := getFileStorage(ctx).List(ctx, folderPath)
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, 0, err
 	}
 	size := int64(0)
+	storedSize := int64(0)
 	count := int32(0)
 	for _, childFile := range files {
-		childFilePath := path.Join(folderPath, childFile.Name())
-		pathInfo := util.GetPathInfo(ctx, childFilePath)
-		if pathInfo == nil {
-			continue
-		}
-		if !pathInfo.IsDir() {
-			size += pathInfo.Size()
+		if !childFile.IsDir {
+			size += childFile.Size
+			storedSize += getStoredSize(childFile)
 			count += 1
 			continue
 		}
-		childSize, childCount, err := selectFolderSizeAndCount(ctx, childFilePath)
+		childSize, childStoredSize, childCount, err := selectFolderSizeAndCount(ctx, childFile.Path)
 		if err != nil {
 			continue
 		}
 		size += childSize
+		storedSize += childStoredSize
 		count += childCount
 	}
-	return size, count, nil
+	return size, storedSize, count, nil
+}
+
+func getStoredSize(info model.StorageInfo) int64 {
+	if info.StoredSize > 0 {
+		return info.StoredSize
+	}
+	return info.Size
 }
 
 func GetFileData(ctx context.Context, filePath string) ([]byte, error) {
-	bedPath, err := createBedPath(ctx, filePath)
+	reader, err := GetReadFile(ctx, filePath)
 	if err != nil {
 		return nil, err
 	}
-	return util.ReadFileWithData(ctx, bedPath, nil)
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath, "err": err}).Error("读取文件数据异常")
+		return nil, fmt.Errorf("读取文件数据异常: %+v", err)
+	}
+	return data, nil
 }
 
-func GetReadFile(ctx context.Context, filePath string) (*os.File, error) {
-	bedPath, err := createBedPath(ctx, filePath)
-	if err != nil {
-		return nil, err
+func GetReadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	bedPath := createBedPath(ctx, filePath)
+	return getFileStorage(ctx).Read(ctx, bedPath)
+}
+
+// GetGzipReadFile 读取文件压缩存储的gzip数据，文件没有压缩存储时返回nil
+func GetGzipReadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	storage, ok := getFileStorage(ctx).(model.StorageGzipInter)
+	if !ok {
+		return nil, nil
 	}
-	return util.GetReadFile(ctx, bedPath)
+	bedPath := createBedPath(ctx, filePath)
+	return storage.ReadGzip(ctx, bedPath)
 }
 
 func MoveFile(ctx context.Context, formPath, toPath string) error {
-	formBedPath, err := createBedPath(ctx, formPath)
-	if err != nil {
-		return err
-	}
-	toBedPath, err := createBedPath(ctx, toPath)
+	formBedPath := createBedPath(ctx, formPath)
+	toBedPath := createBedPath(ctx, toPath)
+	err := getFileStorage(ctx).Move(ctx, formBedPath, toBedPath)
 	if err != nil {
 		return err
 	}
-	err = moveFile(ctx, formBedPath, toBedPath)
-	deleteFile(ctx, formBedPath)
-	return err
-}
-
-func moveFile(ctx context.Context, formBedPath, toBedPath string) error {
-	_, err := util.GetReadFile(ctx, toBedPath)
+	err = moveFileIndex(ctx, formBedPath, toBedPath)
 	if err != nil {
 		return err
 	}
-	err = os.Rename(formBedPath, toBedPath)
-	if err != nil {
-		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("移动文件，异常")
-		return fmt.Errorf("移动文件，异常: %+v", err)
+	if _, ok := getFileStorage(ctx).(model.StorageReencryptInter); ok {
+		//移动时可能按目标路径的密钥重新加密了
+		return refreshMovedFileIndexModTime(ctx, toBedPath)
 	}
 	return nil
 }
 
-func createBedPath(ctx context.Context, fileOrFolderPath string) (string, error) {
-	bedPath := util.ClearPath(ctx, path.Join(model.FileBedPath, fileOrFolderPath))
+func createBedPath(ctx context.Context, fileOrFolderPath string) string {
+	bedPath := storage.ClearStoragePath(ctx, fileOrFolderPath)
 	logrus.WithContext(ctx).WithFields(logrus.Fields{"bedPath": bedPath}).Info("创建床文件路径")
-
-	if !strings.HasPrefix(bedPath, model.FileBedPath) {
-		logrus.WithContext(ctx).WithFields(logrus.Fields{"bedPath": bedPath}).Error("文件路径不在床路径下")
-		return "", fmt.Errorf("文件路径不在床路径下")
-	}
-
-	return bedPath, nil
+	return bedPath
 }
@@ -0,0 +1,271 @@
+package dao
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historyMaxLine 日志单行的上限，超过的行跳过
+const historyMaxLine = 1024 * 1024
+
+// historyCompactMinLine 日志行数超过它且超过仍然存在的记录数的两倍时，用内存里的记录重写日志
+const historyCompactMinLine = 1024
+
+// historyStore 床的上传历史；日志只追加，创建时回放一遍得到仍然存在的上传记录
+type historyStore struct {
+	lock      sync.Mutex
+	logPath   string
+	lastId    int64
+	histories []model.FileHistory //按Id升序，Id为0的是已经去掉的记录
+	positions map[string]int      //路径在histories里的下标
+	lineCount int
+	//compactable 日志没能完整回放时不重写，避免丢掉读不出来的记录
+	compactable bool
+}
+
+func initHistory(ctx context.Context, root string) *historyStore {
+	store := &historyStore{logPath: path.Join(root, model.HistoryPath), positions: make(map[string]int), compactable: true}
+	file, err := os.Open(store.logPath)
+	if os.IsNotExist(err) {
+		return store
+	}
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"logPath": store.logPath, "err": err}).Error("打开上传历史，异常")
+		store.compactable = false
+		return store
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	for {
+		line, err := readHistoryLine(reader)
+		if len(line) > 0 {
+			store.lineCount++
+		}
+		if len(line) > historyMaxLine {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"length": len(line)}).Warn("回放上传历史，跳过超长行")
+		} else if len(line) > 0 {
+			var history model.FileHistory
+			err := util.UnmarshalJson(line, &history)
+			if err == nil {
+				store.apply(history)
+			} else {
+				//写到一半中断的行
+				logrus.WithContext(ctx).WithFields(logrus.Fields{"line": string(line), "err": err}).Warn("回放上传历史，跳过非法行")
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"logPath": store.logPath, "err": err}).Error("回放上传历史，异常")
+			store.compactable = false
+			break
+		}
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"lastId": store.lastId, "count": len(store.positions), "lineCount": store.lineCount}).Info("回放上传历史，完成")
+	store.tryCompact(ctx)
+	return store
+}
+
+// readHistoryLine 读取一行，超过historyMaxLine的部分不保留，只返回比上限多一个字节的内容供调用方跳过
+func readHistoryLine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		data, isPrefix, err := reader.ReadLine()
+		if len(line) <= historyMaxLine {
+			line = append(line, data...)
+			if len(line) > historyMaxLine {
+				line = line[:historyMaxLine+1]
+			}
+		}
+		if err != nil || !isPrefix {
+			return line, err
+		}
+	}
+}
+
+// apply 上传去掉同一路径的旧记录再追加，删除去掉路径本身以及路径下的记录，移动去掉被覆盖的记录后把记录改为新路径
+func (this *historyStore) apply(history model.FileHistory) {
+	if this.lastId < history.Id {
+		this.lastId = history.Id
+	}
+	switch history.Type {
+	case model.FileEventAdd:
+		this.remove(history.Path, false)
+		this.histories = append(this.histories, history)
+		this.positions[history.Path] = len(this.histories) - 1
+	case model.FileEventRemove:
+		this.remove(history.Path, true)
+	case model.FileEventMove:
+		this.remove(history.Path, true)
+		for _, filePath := range this.listSubPath(history.FromPath) {
+			i := this.positions[filePath]
+			delete(this.positions, filePath)
+			this.histories[i].Path = history.Path + strings.TrimPrefix(filePath, history.FromPath)
+			this.positions[this.histories[i].Path] = i
+		}
+	}
+}
+
+// remove 去掉路径的记录，sub为true时连同路径下的记录
+func (this *historyStore) remove(filePath string, sub bool) {
+	filePaths := []string{filePath}
+	if sub {
+		filePaths = this.listSubPath(filePath)
+	}
+	for _, filePath := range filePaths {
+		i, ok := this.positions[filePath]
+		if !ok {
+			continue
+		}
+		this.histories[i] = model.FileHistory{}
+		delete(this.positions, filePath)
+	}
+}
+
+// listSubPath 路径本身有记录时就是文件，不会再有路径下的记录，否则按文件夹找出路径下的记录
+func (this *historyStore) listSubPath(parentPath string) []string {
+	if _, ok := this.positions[parentPath]; ok {
+		return []string{parentPath}
+	}
+	var filePaths []string
+	for filePath := range this.positions {
+		if model.IsSubPath(parentPath, filePath) {
+			filePaths = append(filePaths, filePath)
+		}
+	}
+	return filePaths
+}
+
+// tryCompact 日志里的行远多于仍然存在的记录时，用仍然存在的记录重写日志；重写失败不影响已有日志
+func (this *historyStore) tryCompact(ctx context.Context) {
+	if !this.compactable || this.lineCount <= historyCompactMinLine || this.lineCount <= 2*len(this.positions) {
+		return
+	}
+	histories := make([]model.FileHistory, 0, len(this.positions))
+	for i := range this.histories {
+		if this.histories[i].Id == 0 {
+			continue
+		}
+		histories = append(histories, this.histories[i])
+	}
+	lines := make([]byte, 0, len(histories)*128)
+	for i := range histories {
+		lines = append(lines, util.ToJson(histories[i])...)
+		lines = append(lines, '\n')
+	}
+	lineCount := len(histories)
+	if len(histories) == 0 || histories[len(histories)-1].Id < this.lastId {
+		//没有类型的记录回放时只用来恢复lastId，避免重写后Id重复
+		lines = append(lines, util.ToJson(model.FileHistory{Id: this.lastId})...)
+		lines = append(lines, '\n')
+		lineCount++
+	}
+	err := writeHistoryLog(this.logPath, lines)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"logPath": this.logPath, "err": err}).Error("压缩上传历史，异常")
+		return
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"lineCount": this.lineCount, "count": len(histories)}).Info("压缩上传历史，完成")
+	this.histories = histories
+	this.positions = make(map[string]int, len(histories))
+	for i := range histories {
+		this.positions[histories[i].Path] = i
+	}
+	this.lineCount = lineCount
+}
+
+// writeHistoryLog 先完整写入临时文件再替换日志，中途失败时原日志不变
+func writeHistoryLog(logPath string, data []byte) error {
+	tmpPath := logPath + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, logPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
+
+// InsertFileHistory 先追加写入日志再更新内存里的记录
+func InsertFileHistory(ctx context.Context, history model.FileHistory) error {
+	object, err := getBucketStore(ctx)
+	if err != nil {
+		return err
+	}
+	store := object.history
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	history.Id = store.lastId + 1
+	if history.Time.IsZero() {
+		history.Time = time.Now()
+	}
+	file, err := os.OpenFile(store.logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"logPath": store.logPath, "err": err}).Error("写入上传历史，打开日志异常")
+		return fmt.Errorf("写入上传历史，打开日志异常: %+v", err)
+	}
+	defer file.Close()
+	_, err = file.Write(append(util.ToJson(history), '\n'))
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"history": history, "err": err}).Error("写入上传历史，异常")
+		return fmt.Errorf("写入上传历史，异常: %+v", err)
+	}
+	store.apply(history)
+	store.lineCount++
+	store.tryCompact(ctx)
+	return nil
+}
+
+// SelectFileHistory 从新到旧查找Id小于beforeId（为0时不限制）且满足match的上传记录，最多返回limit个，
+// 第二个返回值表示后面是否还有满足的记录
+func SelectFileHistory(ctx context.Context, beforeId int64, limit int, match func(history model.FileHistory) bool) ([]model.FileHistory, bool, error) {
+	object, err := getBucketStore(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	store := object.history
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	var histories []model.FileHistory
+	for i := len(store.histories) - 1; i >= 0; i-- {
+		if store.histories[i].Id == 0 || (beforeId > 0 && beforeId <= store.histories[i].Id) {
+			continue
+		}
+		if !match(store.histories[i]) {
+			continue
+		}
+		if len(histories) >= limit {
+			return histories, true, nil
+		}
+		histories = append(histories, store.histories[i])
+	}
+	return histories, false, nil
+}
@@ -0,0 +1,603 @@
+package dao
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/dao/storage"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+	"hash"
+	"hash/crc32"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// fileIndexSniffSize 保留文件开头的字节数，用于识别MIME与解析图片宽高，jpeg的宽高可能在较大的exif之后
+const fileIndexSniffSize = 64 * 1024
+
+// fileIndexRebuildBatch 重建索引时每个事务写入的索引数
+const fileIndexRebuildBatch = 256
+
+var fileIndexBucket = []byte("file")
+var fileIndexMetaBucket = []byte("meta")
+var fileIndexBuiltKey = []byte("built_at")
+
+func initFileIndex(ctx context.Context, root string) (*bolt.DB, error) {
+	err := util.CreateFolderPath(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	dbPath := path.Join(root, model.FileIndexPath)
+	db, err := bolt.Open(dbPath, 0666, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"dbPath": dbPath, "err": err}).Error("打开文件索引，异常")
+		return nil, fmt.Errorf("打开文件索引，异常: %+v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(fileIndexBucket)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists(fileIndexMetaBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("初始化文件索引，异常")
+		return nil, fmt.Errorf("初始化文件索引，异常: %+v", err)
+	}
+	return db, nil
+}
+
+func isFileIndexBuilt(ctx context.Context) bool {
+	var built bool
+	viewFileIndex(ctx, func(tx *bolt.Tx) error {
+		built = len(tx.Bucket(fileIndexMetaBucket).Get(fileIndexBuiltKey)) > 0
+		return nil
+	})
+	return built
+}
+
+// RebuildFileIndex 遍历存储逐个计算文件索引，分批写入，保留已有索引里的上传者与元数据，最后清理文件已不存在的索引；
+// 不清空索引，重建期间接口写入的索引不会丢失
+func RebuildFileIndex(ctx context.Context) (int, error) {
+	logrus.WithContext(ctx).WithFields(logrus.Fields{}).Info("重建文件索引，开始")
+	seen := make(map[string]bool)
+	var rebuilts []rebuiltFileIndex
+	err := walkStorage(ctx, "/", func(info model.StorageInfo) error {
+		seen[info.Path] = true
+		oldIndex, err := selectFileIndex(ctx, info.Path)
+		if err != nil {
+			return err
+		}
+		if isFileIndexFresh(oldIndex, info) {
+			return nil
+		}
+		index, err := createFileIndex(ctx, info)
+		if err != nil {
+			return err
+		}
+		//计算期间文件被改写时跳过，由写入方更新索引
+		current, err := getFileStorage(ctx).Stat(ctx, info.Path)
+		if err != nil {
+			return err
+		}
+		if current == nil || current.IsDir || getStoredSize(*current) != index.StoredSize || !current.ModTime.Equal(index.ModTime) {
+			return nil
+		}
+		rebuilts = append(rebuilts, rebuiltFileIndex{index: *index, oldIndex: oldIndex})
+		if len(rebuilts) < fileIndexRebuildBatch {
+			return nil
+		}
+		err = upsertRebuiltFileIndex(ctx, rebuilts)
+		rebuilts = rebuilts[:0]
+		return err
+	})
+	if err == nil {
+		err = upsertRebuiltFileIndex(ctx, rebuilts)
+	}
+	if err != nil {
+		return 0, err
+	}
+	err = sweepFileIndex(ctx, seen)
+	if err != nil {
+		return 0, err
+	}
+
+	err = updateFileIndex(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(fileIndexMetaBucket).Put(fileIndexBuiltKey, []byte(time.Now().Format(time.RFC3339)))
+	})
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("重建文件索引，异常")
+		return 0, fmt.Errorf("重建文件索引，异常: %+v", err)
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"count": len(seen)}).Info("重建文件索引，完成")
+	return len(seen), nil
+}
+
+// rebuiltFileIndex 重建算出的索引，oldIndex为计算前读到的索引
+type rebuiltFileIndex struct {
+	index    model.FileIndex
+	oldIndex *model.FileIndex
+}
+
+// isFileIndexFresh 索引的存储大小与修改时间和存储一致时认为索引仍然有效；
+// 比较存储大小，List不解析加密或压缩头部时也能判断
+func isFileIndexFresh(index *model.FileIndex, info model.StorageInfo) bool {
+	return index != nil && index.GetStoredSize() == getStoredSize(info) && index.ModTime.Equal(info.ModTime)
+}
+
+// lookupFileIndexSize 压缩存储Stat时先用有效索引里的文件大小，避免每次都读取文件头部
+func lookupFileIndexSize(ctx context.Context, info model.StorageInfo) (int64, bool) {
+	index, err := selectFileIndex(ctx, info.Path)
+	if err != nil || !isFileIndexFresh(index, info) {
+		return 0, false
+	}
+	return index.Size, true
+}
+
+// resolveStorageInfoSize 把List得到的存储大小换成有效索引里的文件大小，没有有效索引的保持原样
+func resolveStorageInfoSize(ctx context.Context, infos []model.StorageInfo) error {
+	err := viewFileIndex(ctx, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(fileIndexBucket)
+		for i := range infos {
+			if infos[i].IsDir {
+				continue
+			}
+			data := bucket.Get([]byte(infos[i].Path))
+			if data == nil {
+				continue
+			}
+			var index model.FileIndex
+			err := util.UnmarshalJson(data, &index)
+			if err != nil {
+				return err
+			}
+			if !isFileIndexFresh(&index, infos[i]) {
+				continue
+			}
+			infos[i].StoredSize = getStoredSize(infos[i])
+			infos[i].Size = index.Size
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询文件大小，异常")
+		return fmt.Errorf("查询文件大小，异常: %+v", err)
+	}
+	return nil
+}
+
+// upsertRebuiltFileIndex 一个事务写入一批重建的索引，合并已有索引的上传者与元数据；
+// 事务里只比较索引，计算期间索引被写入方更新过的以写入方为准
+func upsertRebuiltFileIndex(ctx context.Context, rebuilts []rebuiltFileIndex) error {
+	if len(rebuilts) == 0 {
+		return nil
+	}
+	err := updateFileIndex(ctx, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(fileIndexBucket)
+		for i := range rebuilts {
+			index := rebuilts[i].index
+			current, err := getFileIndex(bucket, index.Path)
+			if err != nil {
+				return err
+			}
+			if !isSameFileIndexVersion(current, rebuilts[i].oldIndex) {
+				continue
+			}
+			if current != nil {
+				index.Uploader = current.Uploader
+				index.FileMeta = current.FileMeta
+			}
+			err = bucket.Put([]byte(index.Path), util.ToJson(index))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"count": len(rebuilts), "err": err}).Error("重建文件索引，更新索引异常")
+		return fmt.Errorf("重建文件索引，更新索引异常: %+v", err)
+	}
+	return nil
+}
+
+// isSameFileIndexVersion 两个索引对应的是否是同一次写入，都为空时也相同
+func isSameFileIndexVersion(index, other *model.FileIndex) bool {
+	if index == nil || other == nil {
+		return index == nil && other == nil
+	}
+	return index.Size == other.Size && index.GetStoredSize() == other.GetStoredSize() && index.ModTime.Equal(other.ModTime)
+}
+
+func getFileIndex(bucket *bolt.Bucket, bedPath string) (*model.FileIndex, error) {
+	data := bucket.Get([]byte(bedPath))
+	if data == nil {
+		return nil, nil
+	}
+	var index model.FileIndex
+	err := util.UnmarshalJson(data, &index)
+	if err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
+
+// sweepFileIndex 删除遍历时没有见到、且存储里已经不存在的文件的索引；保留路径下的索引不在遍历范围，只按存储判断
+func sweepFileIndex(ctx context.Context, seen map[string]bool) error {
+	unseen := make(map[string]model.FileIndex)
+	err := viewFileIndex(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(fileIndexBucket).ForEach(func(key, data []byte) error {
+			if seen[string(key)] {
+				return nil
+			}
+			var index model.FileIndex
+			err := util.UnmarshalJson(data, &index)
+			if err != nil {
+				return err
+			}
+			unseen[string(key)] = index
+			return nil
+		})
+	})
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("清理文件索引，异常")
+		return fmt.Errorf("清理文件索引，异常: %+v", err)
+	}
+	var stale []string
+	for key := range unseen {
+		info, err := getFileStorage(ctx).Stat(ctx, key)
+		if err != nil {
+			return err
+		}
+		if info == nil || info.IsDir {
+			stale = append(stale, key)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	//索引在确认文件不存在之后被更新过的，是期间新写入的文件，不删除
+	err = updateFileIndex(ctx, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(fileIndexBucket)
+		for i := range stale {
+			current, err := getFileIndex(bucket, stale[i])
+			if err != nil {
+				return err
+			}
+			index := unseen[stale[i]]
+			if current == nil || !isSameFileIndexVersion(current, &index) {
+				continue
+			}
+			err = bucket.Delete([]byte(stale[i]))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("清理文件索引，异常")
+		return fmt.Errorf("清理文件索引，异常: %+v", err)
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"count": len(stale)}).Info("清理文件索引，完成")
+	return nil
+}
+
+func walkStorage(ctx context.Context, folderPath string, handle func(info model.StorageInfo) error) error {
+	infos, err := getFileStorage(ctx).List(ctx, folderPath)
+	if err != nil {
+		return err
+	}
+	for i := range infos {
+		if model.IsReservedPath(infos[i].Path) {
+			continue
+		}
+		if infos[i].IsDir {
+			err = walkStorage(ctx, infos[i].Path, handle)
+		} else {
+			err = handle(infos[i])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createFileIndex(ctx context.Context, info model.StorageInfo) (*model.FileIndex, error) {
+	reader, err := getFileStorage(ctx).Read(ctx, info.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	writer := newFileIndexWriter(ctx)
+	_, err = io.Copy(writer, reader)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"bedPath": info.Path, "err": err}).Error("计算文件索引，读取文件异常")
+		return nil, fmt.Errorf("计算文件索引，读取文件异常: %+v", err)
+	}
+	index := writer.createFileIndex(ctx, info.Path)
+	index.ModTime = info.ModTime
+	index.StoredSize = getStoredSize(info)
+	return &index, nil
+}
+
+// fileIndexWriter 在写入数据流的同时计算索引需要的大小、哈希与MIME
+type fileIndexWriter struct {
+	size   int64
+	md5    hash.Hash
+	sha256 hash.Hash
+	crc32c hash.Hash32
+	sniff  []byte
+}
+
+func newFileIndexWriter(ctx context.Context) *fileIndexWriter {
+	return &fileIndexWriter{md5: md5.New(), sha256: sha256.New(), crc32c: crc32.New(crc32.MakeTable(crc32.Castagnoli))}
+}
+
+func (this *fileIndexWriter) Write(p []byte) (int, error) {
+	this.size += int64(len(p))
+	this.md5.Write(p)
+	this.sha256.Write(p)
+	this.crc32c.Write(p)
+	if len(this.sniff) < fileIndexSniffSize {
+		n := fileIndexSniffSize - len(this.sniff)
+		if n > len(p) {
+			n = len(p)
+		}
+		this.sniff = append(this.sniff, p[:n]...)
+	}
+	return len(p), nil
+}
+
+func (this *fileIndexWriter) createFileIndex(ctx context.Context, bedPath string) model.FileIndex {
+	var index model.FileIndex
+	index.Path = bedPath
+	index.Size = this.size
+	index.Md5 = hex.EncodeToString(this.md5.Sum(nil))
+	index.Sha256 = hex.EncodeToString(this.sha256.Sum(nil))
+	index.Crc32c = hex.EncodeToString(this.crc32c.Sum(nil))
+	index.ModTime = time.Now()
+	setFileIndexMime(&index, this.sniff)
+	index.Uploader = getUploader(ctx)
+	return index
+}
+
+// setFileIndexMime 按拓展名或文件头部判断MIME，图片再解析宽高
+func setFileIndexMime(index *model.FileIndex, sniff []byte) {
+	index.Mime = mime.TypeByExtension(path.Ext(index.Path))
+	if index.Mime == "" {
+		index.Mime = http.DetectContentType(sniff)
+	}
+	if strings.HasPrefix(index.Mime, "image/") {
+		index.Width, index.Height = decodeImageSize(bytes.NewReader(sniff))
+	}
+}
+
+// createSizeFileIndex 超过摘要上限的文件只记录大小与修改时间，不计算哈希，MIME只读取头部判断
+func createSizeFileIndex(ctx context.Context, info model.StorageInfo) (*model.FileIndex, error) {
+	reader, err := getFileStorage(ctx).Read(ctx, info.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	sniff := make([]byte, fileIndexSniffSize)
+	n, err := io.ReadFull(reader, sniff)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"bedPath": info.Path, "err": err}).Error("计算文件索引，读取文件异常")
+		return nil, fmt.Errorf("计算文件索引，读取文件异常: %+v", err)
+	}
+	var index model.FileIndex
+	index.Path = info.Path
+	index.Size = info.Size
+	index.Md5 = model.OutMaxHashLimit
+	index.Sha256 = model.OutMaxHashLimit
+	index.Crc32c = model.OutMaxHashLimit
+	index.ModTime = info.ModTime
+	index.StoredSize = getStoredSize(info)
+	setFileIndexMime(&index, sniff[:n])
+	index.Uploader = getUploader(ctx)
+	return &index, nil
+}
+
+// decodeImageSize 只解析图片头部，无法解析时返回0
+func decodeImageSize(reader io.Reader) (int, int) {
+	config, _, err := image.DecodeConfig(reader)
+	if err != nil {
+		return 0, 0
+	}
+	return config.Width, config.Height
+}
+
+func getUploader(ctx context.Context) string {
+	claims := util.GetClaims(ctx)
+	if claims == nil {
+		return ""
+	}
+	return claims.ServerName
+}
+
+func insertFileIndex(ctx context.Context, index model.FileIndex) error {
+	err := updateFileIndex(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(fileIndexBucket).Put([]byte(index.Path), util.ToJson(index))
+	})
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"index": index, "err": err}).Error("插入文件索引，异常")
+		return fmt.Errorf("插入文件索引，异常: %+v", err)
+	}
+	return nil
+}
+
+func selectFileIndex(ctx context.Context, bedPath string) (*model.FileIndex, error) {
+	var index *model.FileIndex
+	err := viewFileIndex(ctx, func(tx *bolt.Tx) error {
+		var err error
+		index, err = getFileIndex(tx.Bucket(fileIndexBucket), bedPath)
+		return err
+	})
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"bedPath": bedPath, "err": err}).Error("查询文件索引，异常")
+		return nil, fmt.Errorf("查询文件索引，异常: %+v", err)
+	}
+	return index, nil
+}
+
+// SelectFileIndexes 批量查询文件索引，没有索引的文件不在结果里
+func SelectFileIndexes(ctx context.Context, filePaths []string) (map[string]model.FileIndex, error) {
+	indexes := make(map[string]model.FileIndex, len(filePaths))
+	err := viewFileIndex(ctx, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(fileIndexBucket)
+		for i := range filePaths {
+			data := bucket.Get([]byte(storage.ClearStoragePath(ctx, filePaths[i])))
+			if data == nil {
+				continue
+			}
+			var index model.FileIndex
+			err := util.UnmarshalJson(data, &index)
+			if err != nil {
+				return err
+			}
+			indexes[filePaths[i]] = index
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePaths": filePaths, "err": err}).Error("批量查询文件索引，异常")
+		return nil, fmt.Errorf("批量查询文件索引，异常: %+v", err)
+	}
+	return indexes, nil
+}
+
+// listFileIndex 查询文件夹下（含子文件夹）的全部文件索引
+func listFileIndex(ctx context.Context, folderPath string) ([]model.FileIndex, error) {
+	var indexes []model.FileIndex
+	err := viewFileIndex(ctx, func(tx *bolt.Tx) error {
+		prefix := createFileIndexPrefix(folderPath)
+		cursor := tx.Bucket(fileIndexBucket).Cursor()
+		for key, data := cursor.Seek([]byte(prefix)); key != nil && strings.HasPrefix(string(key), prefix); key, data = cursor.Next() {
+			var index model.FileIndex
+			err := util.UnmarshalJson(data, &index)
+			if err != nil {
+				return err
+			}
+			indexes = append(indexes, index)
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"folderPath": folderPath, "err": err}).Error("罗列文件索引，异常")
+		return nil, fmt.Errorf("罗列文件索引，异常: %+v", err)
+	}
+	return indexes, nil
+}
+
+// deleteFileIndex 删除路径本身以及路径下的全部文件索引
+func deleteFileIndex(ctx context.Context, bedPath string) error {
+	err := updateFileIndex(ctx, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(fileIndexBucket)
+		err := bucket.Delete([]byte(bedPath))
+		if err != nil {
+			return err
+		}
+		prefix := createFileIndexPrefix(bedPath)
+		var keys [][]byte
+		cursor := bucket.Cursor()
+		for key, _ := cursor.Seek([]byte(prefix)); key != nil && strings.HasPrefix(string(key), prefix); key, _ = cursor.Next() {
+			keys = append(keys, append([]byte{}, key...))
+		}
+		for i := range keys {
+			err = bucket.Delete(keys[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"bedPath": bedPath, "err": err}).Error("删除文件索引，异常")
+		return fmt.Errorf("删除文件索引，异常: %+v", err)
+	}
+	return nil
+}
+
+// moveFileIndex 移动路径本身以及路径下的全部文件索引
+func moveFileIndex(ctx context.Context, formBedPath, toBedPath string) error {
+	err := updateFileIndex(ctx, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(fileIndexBucket)
+		prefix := createFileIndexPrefix(formBedPath)
+		moved := make(map[string]model.FileIndex)
+		cursor := bucket.Cursor()
+		//formBedPath排在它的子路径之前，中间可能夹着同前缀的其他路径，如`/a.txt`夹在`/a`与`/a/`之间
+		for key, data := cursor.Seek([]byte(formBedPath)); key != nil && strings.HasPrefix(string(key), formBedPath); key, data = cursor.Next() {
+			keyPath := string(key)
+			if keyPath != formBedPath && !strings.HasPrefix(keyPath, prefix) {
+				continue
+			}
+			var index model.FileIndex
+			err := util.UnmarshalJson(data, &index)
+			if err != nil {
+				return err
+			}
+			index.Path = toBedPath + strings.TrimPrefix(keyPath, formBedPath)
+			moved[keyPath] = index
+		}
+		for keyPath, index := range moved {
+			err := bucket.Delete([]byte(keyPath))
+			if err != nil {
+				return err
+			}
+			err = bucket.Put([]byte(index.Path), util.ToJson(index))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"formBedPath": formBedPath, "toBedPath": toBedPath, "err": err}).Error("移动文件索引，异常")
+		return fmt.Errorf("移动文件索引，异常: %+v", err)
+	}
+	return nil
+}
+
+func selectFileIndexSizeAndCount(ctx context.Context, folderPath string) (int64, int64, int32, error) {
+	indexes, err := listFileIndex(ctx, folderPath)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	size := int64(0)
+	storedSize := int64(0)
+	count := int32(0)
+	for i := range indexes {
+		if model.IsReservedPath(indexes[i].Path) {
+			continue
+		}
+		size += indexes[i].Size
+		storedSize += indexes[i].GetStoredSize()
+		count += 1
+	}
+	return size, storedSize, count, nil
+}
+
+func createFileIndexPrefix(folderPath string) string {
+	if folderPath == "/" {
+		return "/"
+	}
+	return folderPath + "/"
+}
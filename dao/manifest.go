@@ -0,0 +1,91 @@
+package dao
+
+import (
+	"context"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/model"
+)
+
+// WalkManifest 遍历路径下（含子文件夹）的全部文件，路径为文件时只有该文件；
+// 摘要优先取索引，不在索引里的文件现读现算，超过上限时不计算
+func WalkManifest(ctx context.Context, fileOrFolderPath string, handle func(entry model.ManifestEntry) error) error {
+	bedPath := createBedPath(ctx, fileOrFolderPath)
+	if model.IsReservedPath(bedPath) {
+		return nil
+	}
+	pathInfo, err := getFileStorage(ctx).Stat(ctx, bedPath)
+	if pathInfo == nil || err != nil {
+		return err
+	}
+	indexes, err := listFileIndex(ctx, bedPath)
+	if err != nil {
+		return err
+	}
+	indexMap := make(map[string]model.FileIndex, len(indexes))
+	for i := range indexes {
+		indexMap[indexes[i].Path] = indexes[i]
+	}
+	walk := func(info model.StorageInfo) error {
+		entry, err := createManifestEntry(ctx, info, indexMap)
+		if err != nil {
+			return err
+		}
+		return handle(entry)
+	}
+	if !pathInfo.IsDir {
+		index, err := selectFileIndex(ctx, bedPath)
+		if err != nil {
+			return err
+		}
+		if index != nil {
+			indexMap[bedPath] = *index
+		}
+		pathInfo.Path = bedPath
+		return walk(*pathInfo)
+	}
+	return walkStorage(ctx, bedPath, walk)
+}
+
+func createManifestEntry(ctx context.Context, info model.StorageInfo, indexes map[string]model.FileIndex) (model.ManifestEntry, error) {
+	var entry model.ManifestEntry
+	entry.Path = info.Path
+	entry.Size = info.Size
+	entry.ModTime = info.ModTime
+	index, ok := indexes[info.Path]
+	if ok && !isFileIndexFresh(&index, info) {
+		ok = false
+	}
+	if !ok && getStoredSize(info) <= config.Config.MaxHashLimit {
+		object, err := createFileIndex(ctx, info)
+		if err != nil {
+			return entry, err
+		}
+		if oldIndex, exist := indexes[info.Path]; exist {
+			object.Uploader = oldIndex.Uploader
+			object.FileMeta = oldIndex.FileMeta
+		}
+		err = insertFileIndex(ctx, *object)
+		if err != nil {
+			return entry, err
+		}
+		index, ok = *object, true
+	}
+	if !ok {
+		//List得到的可能是加密或压缩后的大小
+		pathInfo, err := getFileStorage(ctx).Stat(ctx, info.Path)
+		if err != nil {
+			return entry, err
+		}
+		if pathInfo != nil {
+			entry.Size = pathInfo.Size
+		}
+	}
+	if ok {
+		entry.Size = index.Size
+		entry.Md5 = index.Md5
+		entry.Sha256 = index.Sha256
+		entry.Crc32c = index.Crc32c
+		entry.FileMeta = index.FileMeta
+	}
+	return entry, nil
+}
@@ -0,0 +1,48 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+)
+
+// SelectFileMeta 文件没有索引时返回nil
+func SelectFileMeta(ctx context.Context, filePath string) (*model.FileMeta, error) {
+	bedPath := createBedPath(ctx, filePath)
+	index, err := selectFileIndex(ctx, bedPath)
+	if index == nil || err != nil {
+		return nil, err
+	}
+	return &index.FileMeta, nil
+}
+
+// UpdateFileMeta 元数据保存在文件索引里，文件不在索引里时先计算索引
+func UpdateFileMeta(ctx context.Context, filePath string, meta model.FileMeta) error {
+	bedPath := createBedPath(ctx, filePath)
+	index, err := selectFileIndex(ctx, bedPath)
+	if err != nil {
+		return err
+	}
+	if index == nil {
+		info, err := getFileStorage(ctx).Stat(ctx, bedPath)
+		if err != nil {
+			return err
+		}
+		if info == nil || info.IsDir {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"bedPath": bedPath}).Error("设置文件元数据，文件不存在")
+			return fmt.Errorf("设置文件元数据，文件不存在")
+		}
+		if config.Config.MaxHashLimit < info.Size {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"bedPath": bedPath}).Error("设置文件元数据，文件不在索引里且超过摘要上限")
+			return fmt.Errorf("设置文件元数据，文件不在索引里且超过摘要上限")
+		}
+		index, err = createFileIndex(ctx, *info)
+		if err != nil {
+			return err
+		}
+	}
+	index.FileMeta = meta
+	return insertFileIndex(ctx, *index)
+}
@@ -0,0 +1,133 @@
+package dao
+
+import (
+	"context"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+	"io"
+	"time"
+)
+
+// ScrubFile 按索引重新计算文件摘要，返回缺失或损坏的文件；rateLimit为每秒读取字节数，小于等于0时不限速；
+// 单个文件查询或读取异常时记为缺失或损坏，继续校验其余文件
+func ScrubFile(ctx context.Context, rateLimit int64) (*model.ScrubReport, error) {
+	indexes, err := listFileIndex(ctx, "/")
+	if err != nil {
+		return nil, err
+	}
+	var report model.ScrubReport
+	report.StartTime = time.Now()
+	limiter := &rateLimiter{limit: rateLimit, start: time.Now()}
+	indexed := make(map[string]bool, len(indexes))
+	for i := range indexes {
+		if model.IsReservedPath(indexes[i].Path) {
+			continue
+		}
+		indexed[indexes[i].Path] = true
+		result, checked := scrubOneFile(ctx, indexes[i], limiter)
+		if !checked {
+			report.Skipped++
+			continue
+		}
+		report.Checked++
+		if result != nil {
+			report.Results = append(report.Results, *result)
+		}
+	}
+	//不在索引里的文件没有可对比的摘要，记为跳过
+	err = walkStorage(ctx, "/", func(info model.StorageInfo) error {
+		if !indexed[info.Path] {
+			report.Skipped++
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Warn("校验文件，遍历未索引文件异常")
+	}
+	report.EndTime = time.Now()
+	return &report, nil
+}
+
+func scrubOneFile(ctx context.Context, index model.FileIndex, limiter *rateLimiter) (*model.ScrubResult, bool) {
+	var result model.ScrubResult
+	result.Path = index.Path
+	result.ExpectSize = index.Size
+	result.ExpectSha256 = index.Sha256
+	info, err := getFileStorage(ctx).Stat(ctx, index.Path)
+	if err != nil {
+		result.Status = model.ScrubMissing
+		result.Error = err.Error()
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"result": result, "err": err}).Error("校验文件，查询文件异常")
+		return &result, true
+	}
+	if info == nil || info.IsDir {
+		result.Status = model.ScrubMissing
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"result": result}).Error("校验文件，文件缺失")
+		return &result, true
+	}
+	if index.Sha256 == model.OutMaxHashLimit {
+		//超过摘要上限的文件索引里没有哈希，无从校验
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"bedPath": index.Path}).Info("校验文件，索引没有摘要，跳过")
+		return nil, false
+	}
+	if !index.ModTime.Equal(info.ModTime) {
+		//修改时间不一致是正常改动而不是损坏，等监听或重建索引更新
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"bedPath": index.Path}).Info("校验文件，索引已过期，跳过")
+		return nil, false
+	}
+	reader, err := getFileStorage(ctx).Read(ctx, index.Path)
+	if err != nil {
+		result.Status = model.ScrubCorrupted
+		result.Error = err.Error()
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"result": result, "err": err}).Error("校验文件，打开文件异常")
+		return &result, true
+	}
+	defer reader.Close()
+	writer := newFileIndexWriter(ctx)
+	_, err = io.Copy(writer, &rateReader{reader: reader, limiter: limiter})
+	if err != nil {
+		result.Status = model.ScrubCorrupted
+		result.Error = err.Error()
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"result": result, "err": err}).Error("校验文件，读取文件异常")
+		return &result, true
+	}
+	actual := writer.createFileIndex(ctx, index.Path)
+	result.ActualSize = actual.Size
+	result.ActualSha256 = actual.Sha256
+	if actual.Size == index.Size && actual.Sha256 == index.Sha256 {
+		return nil, true
+	}
+	result.Status = model.ScrubCorrupted
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"result": result}).Error("校验文件，文件损坏")
+	return &result, true
+}
+
+// rateLimiter 读取速度超过limit时休眠，让平均速度不超过limit
+type rateLimiter struct {
+	limit int64
+	start time.Time
+	read  int64
+}
+
+func (this *rateLimiter) wait(n int) {
+	if this.limit <= 0 {
+		return
+	}
+	this.read += int64(n)
+	expect := time.Duration(float64(this.read) / float64(this.limit) * float64(time.Second))
+	elapsed := time.Now().Sub(this.start)
+	if elapsed < expect {
+		time.Sleep(expect - elapsed)
+	}
+}
+
+type rateReader struct {
+	reader  io.Reader
+	limiter *rateLimiter
+}
+
+func (this *rateReader) Read(p []byte) (int, error) {
+	n, err := this.reader.Read(p)
+	this.limiter.wait(n)
+	return n, err
+}
@@ -0,0 +1,98 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+	"mime"
+	"path"
+	"sort"
+	"strings"
+)
+
+// SearchFileIndex 按路径顺序查找文件夹下（含子文件夹）满足match的文件，从after之后开始，最多返回limit个，
+// 第二个返回值表示后面是否还有满足的文件；不在回收站下搜索时跳过回收站。
+// 索引未建立时遍历存储，此时没有摘要，MIME按扩展名识别；索引建立后只查索引，
+// 绕过接口直接改动存储且没被监听到的文件，要等重建索引后才能搜到
+func SearchFileIndex(ctx context.Context, folderPath, after string, limit int, match func(index model.FileIndex) bool) ([]model.FileIndex, bool, error) {
+	bedPath := createBedPath(ctx, folderPath)
+	skipTrash := !model.IsSubPath(model.TrashPath, bedPath)
+	if !isFileIndexBuilt(ctx) {
+		return searchStorage(ctx, bedPath, after, limit, skipTrash, match)
+	}
+	prefix := createFileIndexPrefix(bedPath)
+	start := prefix
+	if start < after {
+		start = after
+	}
+	var indexes []model.FileIndex
+	var more bool
+	err := viewFileIndex(ctx, func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(fileIndexBucket).Cursor()
+		key, data := cursor.Seek([]byte(start))
+		if key != nil && string(key) == after {
+			key, data = cursor.Next()
+		}
+		for ; key != nil && strings.HasPrefix(string(key), prefix); key, data = cursor.Next() {
+			if model.IsReservedPath(string(key)) || (skipTrash && model.IsSubPath(model.TrashPath, string(key))) {
+				continue
+			}
+			var index model.FileIndex
+			err := util.UnmarshalJson(data, &index)
+			if err != nil {
+				return err
+			}
+			if !match(index) {
+				continue
+			}
+			if len(indexes) >= limit {
+				more = true
+				return nil
+			}
+			indexes = append(indexes, index)
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"folderPath": folderPath, "err": err}).Error("搜索文件索引，异常")
+		return nil, false, fmt.Errorf("搜索文件索引，异常: %+v", err)
+	}
+	return indexes, more, nil
+}
+
+func searchStorage(ctx context.Context, bedPath, after string, limit int, skipTrash bool, match func(index model.FileIndex) bool) ([]model.FileIndex, bool, error) {
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"bedPath": bedPath}).Warn("搜索文件，索引未建立，遍历存储")
+	pathInfo, err := getFileStorage(ctx).Stat(ctx, bedPath)
+	if pathInfo == nil || !pathInfo.IsDir || err != nil {
+		return nil, false, err
+	}
+	var all []model.FileIndex
+	err = walkStorage(ctx, bedPath, func(info model.StorageInfo) error {
+		if info.Path <= after || (skipTrash && model.IsSubPath(model.TrashPath, info.Path)) {
+			return nil
+		}
+		var index model.FileIndex
+		index.Path = info.Path
+		index.Size = info.Size
+		index.ModTime = info.ModTime
+		index.Mime = mime.TypeByExtension(path.Ext(info.Path))
+		index.StoredSize = getStoredSize(info)
+		if match(index) {
+			all = append(all, index)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Path < all[j].Path
+	})
+	if len(all) <= limit {
+		return all, false, nil
+	}
+	return all[:limit], true, nil
+}
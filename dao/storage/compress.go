@@ -0,0 +1,272 @@
+package storage
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+	"hash/crc32"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+const (
+	compressComment    = "go_file_bed"
+	compressHeaderSize = 10 + 2 + compressExtraSize
+	compressExtraSize  = 4 + 8
+	compressSniffSize  = 512
+)
+
+// CompressStorage 可压缩的文件以gzip格式存储，gzip头部的extra字段记录原始大小，
+// 存储的数据本身就是标准gzip，客户端支持gzip时可以直接透传
+// 没有extra标记的文件（包括用户上传的.gz文件）原样读取
+type CompressStorage struct {
+	storage    model.StorageInter
+	minSize    int64
+	mimes      []string
+	sizeLookup SizeLookup
+}
+
+// NewCompressStorage 不小于minSize且MIME以mimes中任一项开头的文件才压缩
+// sizeLookup能给出原始大小时Stat不再读取文件头部，可以为nil
+func NewCompressStorage(ctx context.Context, storage model.StorageInter, minSize int64, mimes []string, sizeLookup SizeLookup) (*CompressStorage, error) {
+	if storage == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{}).Error("创建压缩存储，底层存储为空")
+		return nil, fmt.Errorf("创建压缩存储，底层存储为空")
+	}
+	return &CompressStorage{storage: storage, minSize: minSize, mimes: mimes, sizeLookup: sizeLookup}, nil
+}
+
+func (this *CompressStorage) Stat(ctx context.Context, fileOrFolderPath string) (*model.StorageInfo, error) {
+	info, err := this.storage.Stat(ctx, fileOrFolderPath)
+	if info == nil || err != nil {
+		return info, err
+	}
+	return this.resolveInfo(ctx, *info)
+}
+
+// List 不读取子文件的头部，Size为压缩后的大小；原始大小以Stat或文件索引为准
+func (this *CompressStorage) List(ctx context.Context, folderPath string) ([]model.StorageInfo, error) {
+	return this.storage.List(ctx, folderPath)
+}
+
+func (this *CompressStorage) Read(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	reader, err := this.storage.Read(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	data, size, err := this.parseHeader(ctx, reader)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	if size < 0 {
+		return &prefixReadCloser{reader: io.MultiReader(bytes.NewReader(data), reader), closer: reader}, nil
+	}
+	gzipReader, err := gzip.NewReader(io.MultiReader(bytes.NewReader(data), reader))
+	if err != nil {
+		reader.Close()
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath, "err": err}).Error("解压文件，创建gzip读取异常")
+		return nil, fmt.Errorf("解压文件，创建gzip读取异常: %+v", err)
+	}
+	return &prefixReadCloser{reader: gzipReader, closer: reader}, nil
+}
+
+// ReadGzip 读取存储的gzip数据，不解压
+func (this *CompressStorage) ReadGzip(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	reader, err := this.storage.Read(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	data, size, err := this.parseHeader(ctx, reader)
+	if err != nil || size < 0 {
+		reader.Close()
+		return nil, err
+	}
+	return &prefixReadCloser{reader: io.MultiReader(bytes.NewReader(data), reader), closer: reader}, nil
+}
+
+func (this *CompressStorage) Write(ctx context.Context, filePath string, reader io.Reader) (int64, error) {
+	filePath = ClearStoragePath(ctx, filePath)
+	headSize := this.minSize
+	if headSize < compressSniffSize {
+		headSize = compressSniffSize
+	}
+	head := make([]byte, headSize)
+	n, err := io.ReadFull(reader, head)
+	head = head[:n]
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+		reader = bytes.NewReader(nil)
+	}
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath, "err": err}).Error("压缩文件，读取数据异常")
+		return int64(n), fmt.Errorf("压缩文件，读取数据异常: %+v", err)
+	}
+	reader = io.MultiReader(bytes.NewReader(head), reader)
+	if int64(n) < this.minSize || !this.isCompressible(ctx, filePath, head) {
+		return this.storage.Write(ctx, filePath, reader)
+	}
+
+	//先压缩到床的临时路径，得到原始大小后才能写gzip头部；进程异常退出遗留的临时文件随临时路径一起清理
+	tmpPath := path.Join(model.TmpPath, "gzip", util.GenStringId())
+	defer this.storage.Remove(ctx, tmpPath)
+	crc := crc32.NewIEEE()
+	pipeReader, pipeWriter := io.Pipe()
+	result := make(chan int64, 1)
+	go this.compress(ctx, filePath, io.TeeReader(reader, crc), pipeWriter, result)
+	compressedSize, err := this.storage.Write(ctx, tmpPath, pipeReader)
+	pipeReader.CloseWithError(err)
+	written := <-result
+	if err != nil {
+		return written, err
+	}
+	tmpReader, err := this.storage.Read(ctx, tmpPath)
+	if err != nil {
+		return written, err
+	}
+	defer tmpReader.Close()
+	if written <= compressedSize+compressHeaderSize {
+		//压缩后没有变小，解压回去按原样存储
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath, "written": written, "compressedSize": compressedSize}).Info("压缩文件，压缩无收益，原样存储")
+		flateReader := flate.NewReader(tmpReader)
+		defer flateReader.Close()
+		return this.storage.Write(ctx, filePath, flateReader)
+	}
+	trailer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(trailer[:4], crc.Sum32())
+	binary.LittleEndian.PutUint32(trailer[4:], uint32(written))
+	_, err = this.storage.Write(ctx, filePath, io.MultiReader(bytes.NewReader(this.createHeader(written)), tmpReader, bytes.NewReader(trailer)))
+	return written, err
+}
+
+// compress 把reader压缩写入writer，结束后通过result返回读取的原始字节数
+func (this *CompressStorage) compress(ctx context.Context, filePath string, reader io.Reader, writer *io.PipeWriter, result chan<- int64) {
+	flateWriter, err := flate.NewWriter(writer, flate.DefaultCompression)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("压缩文件，创建压缩异常")
+		writer.CloseWithError(fmt.Errorf("压缩文件，创建压缩异常: %+v", err))
+		result <- 0
+		return
+	}
+	written, err := io.Copy(flateWriter, reader)
+	if err == nil {
+		err = flateWriter.Close()
+	}
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath, "err": err}).Error("压缩文件，压缩异常")
+		err = fmt.Errorf("压缩文件，压缩异常: %+v", err)
+	}
+	writer.CloseWithError(err)
+	result <- written
+}
+
+func (this *CompressStorage) Remove(ctx context.Context, filePath string) error {
+	return this.storage.Remove(ctx, filePath)
+}
+
+func (this *CompressStorage) Move(ctx context.Context, formPath, toPath string) error {
+	return this.storage.Move(ctx, formPath, toPath)
+}
+
+func (this *CompressStorage) Mkdir(ctx context.Context, folderPath string) error {
+	return this.storage.Mkdir(ctx, folderPath)
+}
+
+func (this *CompressStorage) Reencrypt(ctx context.Context, filePath string) (bool, error) {
+	storage, ok := this.storage.(model.StorageReencryptInter)
+	if !ok {
+		return false, nil
+	}
+	return storage.Reencrypt(ctx, filePath)
+}
+
+func (this *CompressStorage) isCompressible(ctx context.Context, filePath string, head []byte) bool {
+	contentType := mime.TypeByExtension(path.Ext(filePath))
+	if contentType == "" {
+		sniff := head
+		if len(sniff) > compressSniffSize {
+			sniff = sniff[:compressSniffSize]
+		}
+		contentType = http.DetectContentType(sniff)
+	}
+	for i := range this.mimes {
+		if strings.HasPrefix(contentType, this.mimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// createHeader gzip头部：FEXTRA里的GB子字段记录原始大小，FCOMMENT标记由本存储写入
+func (this *CompressStorage) createHeader(size int64) []byte {
+	var buffer bytes.Buffer
+	buffer.Write([]byte{0x1f, 0x8b, 8, 0x04 | 0x10, 0, 0, 0, 0, 0, 255})
+	binary.Write(&buffer, binary.LittleEndian, uint16(compressExtraSize))
+	buffer.Write([]byte{'G', 'B'})
+	binary.Write(&buffer, binary.LittleEndian, uint16(8))
+	binary.Write(&buffer, binary.LittleEndian, uint64(size))
+	buffer.WriteString(compressComment)
+	buffer.WriteByte(0)
+	return buffer.Bytes()
+}
+
+// parseHeader 读取gzip头部，返回已读取的数据与原始大小，不是本存储压缩的文件原始大小为-1
+func (this *CompressStorage) parseHeader(ctx context.Context, reader io.Reader) ([]byte, int64, error) {
+	data := make([]byte, compressHeaderSize)
+	n, err := io.ReadFull(reader, data)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return data[:n], -1, nil
+	}
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("读取gzip头部，异常")
+		return nil, -1, fmt.Errorf("读取gzip头部，异常: %+v", err)
+	}
+	if data[0] != 0x1f || data[1] != 0x8b || data[2] != 8 || data[3] != 0x04|0x10 ||
+		binary.LittleEndian.Uint16(data[10:12]) != compressExtraSize ||
+		data[12] != 'G' || data[13] != 'B' || binary.LittleEndian.Uint16(data[14:16]) != 8 {
+		return data, -1, nil
+	}
+	return data, int64(binary.LittleEndian.Uint64(data[16:24])), nil
+}
+
+func (this *CompressStorage) resolveInfo(ctx context.Context, info model.StorageInfo) (*model.StorageInfo, error) {
+	if info.IsDir {
+		return &info, nil
+	}
+	if this.sizeLookup != nil {
+		size, ok := this.sizeLookup(ctx, info)
+		if ok {
+			return this.setSize(info, size), nil
+		}
+	}
+	reader, err := this.storage.Read(ctx, info.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	_, size, err := this.parseHeader(ctx, reader)
+	if err != nil {
+		return nil, err
+	}
+	if size < 0 {
+		return &info, nil
+	}
+	return this.setSize(info, size), nil
+}
+
+func (this *CompressStorage) setSize(info model.StorageInfo, size int64) *model.StorageInfo {
+	if info.StoredSize <= 0 && size != info.Size {
+		info.StoredSize = info.Size
+	}
+	info.Size = size
+	return &info
+}
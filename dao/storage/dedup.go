@@ -0,0 +1,431 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	dedupBlobPath     = model.BlobPath
+	dedupRefPath      = model.BlobPath + "/ref"
+	dedupRefPrefix    = "go_file_bed_blob:"
+	dedupRefMaxSize   = 256
+	dedupRefExtension = ".ref"
+)
+
+// DedupStorage 内容寻址存储，文件内容按sha256只存一份在`/.blob`下，
+// 用户路径下存的是指向blob的引用文件，blob按引用计数回收；
+// 引用另外登记在`/.blob/ref`下对应的路径，不按内容识别，外部放入的同样格式的文件不会被当作引用
+type DedupStorage struct {
+	storage model.StorageInter
+	lock    sync.RWMutex //改动引用与blob时写锁，读取时读锁，避免解析出引用后blob被回收
+}
+
+func NewDedupStorage(ctx context.Context, storage model.StorageInter) (*DedupStorage, error) {
+	if storage == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{}).Error("创建去重存储，底层存储为空")
+		return nil, fmt.Errorf("创建去重存储，底层存储为空")
+	}
+	return &DedupStorage{storage: storage}, nil
+}
+
+type dedupRef struct {
+	Hash string
+	Size int64
+}
+
+func (this *DedupStorage) Stat(ctx context.Context, fileOrFolderPath string) (*model.StorageInfo, error) {
+	info, err := this.storage.Stat(ctx, fileOrFolderPath)
+	if info == nil || err != nil {
+		return info, err
+	}
+	return this.resolveInfo(ctx, *info), nil
+}
+
+func (this *DedupStorage) List(ctx context.Context, folderPath string) ([]model.StorageInfo, error) {
+	infos, err := this.storage.List(ctx, folderPath)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]model.StorageInfo, 0, len(infos))
+	for i := range infos {
+		if infos[i].Path == dedupBlobPath {
+			continue
+		}
+		if isRefSize(infos[i].Size) {
+			list = append(list, *this.resolveInfo(ctx, infos[i]))
+		} else {
+			list = append(list, infos[i])
+		}
+	}
+	return list, nil
+}
+
+// Read 持有读锁解析引用并打开blob，打开后blob被回收也不影响读取
+func (this *DedupStorage) Read(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	ref, err := this.readRef(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if ref == nil {
+		return this.storage.Read(ctx, filePath)
+	}
+	return this.storage.Read(ctx, this.createBlobPath(ref.Hash))
+}
+
+func (this *DedupStorage) Write(ctx context.Context, filePath string, reader io.Reader) (int64, error) {
+	filePath = ClearStoragePath(ctx, filePath)
+	if model.IsSubPath(dedupBlobPath, filePath) {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath}).Error("写入文件，路径为保留路径")
+		return 0, fmt.Errorf("写入文件，路径为保留路径")
+	}
+
+	tmpPath := path.Join(model.TmpPath, "blob", util.GenStringId())
+	hash := sha256.New()
+	written, err := this.storage.Write(ctx, tmpPath, io.TeeReader(reader, hash))
+	if err != nil {
+		this.storage.Remove(ctx, tmpPath)
+		return written, err
+	}
+	ref := dedupRef{Hash: hex.EncodeToString(hash.Sum(nil)), Size: written}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	blobPath := this.createBlobPath(ref.Hash)
+	blobInfo, err := this.storage.Stat(ctx, blobPath)
+	if err != nil {
+		this.storage.Remove(ctx, tmpPath)
+		return written, err
+	}
+	if blobInfo != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath, "hash": ref.Hash}).Info("写入文件，blob已存在")
+		err = this.storage.Remove(ctx, tmpPath)
+	} else {
+		err = this.storage.Move(ctx, tmpPath, blobPath)
+	}
+	if err != nil {
+		return written, err
+	}
+
+	oldRef, err := this.readRef(ctx, filePath)
+	if err != nil {
+		return written, err
+	}
+	err = this.addRefCount(ctx, ref.Hash, 1)
+	if err != nil {
+		return written, err
+	}
+	//先登记引用再写引用文件，写入失败时内容与登记不一致，不会被当作引用
+	_, err = this.storage.Write(ctx, this.createRefPath(ctx, filePath), strings.NewReader(this.formatRef(ref)))
+	if err == nil {
+		_, err = this.storage.Write(ctx, filePath, strings.NewReader(this.formatRef(ref)))
+	}
+	if err != nil {
+		this.removeRef(ctx, filePath)
+		this.addRefCount(ctx, ref.Hash, -1)
+		return written, err
+	}
+	if oldRef != nil {
+		this.addRefCount(ctx, oldRef.Hash, -1)
+	}
+	return written, nil
+}
+
+func (this *DedupStorage) Remove(ctx context.Context, filePath string) error {
+	filePath = ClearStoragePath(ctx, filePath)
+	if model.IsSubPath(dedupBlobPath, filePath) {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath}).Error("删除文件，路径为保留路径")
+		return fmt.Errorf("删除文件，路径为保留路径")
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	ref, err := this.readRef(ctx, filePath)
+	if err != nil {
+		return err
+	}
+	err = this.storage.Remove(ctx, filePath)
+	if err != nil {
+		return err
+	}
+	err = this.removeRef(ctx, filePath)
+	if err != nil {
+		return err
+	}
+	if ref == nil {
+		return nil
+	}
+	return this.addRefCount(ctx, ref.Hash, -1)
+}
+
+func (this *DedupStorage) Move(ctx context.Context, formPath, toPath string) error {
+	formPath = ClearStoragePath(ctx, formPath)
+	toPath = ClearStoragePath(ctx, toPath)
+	if model.IsSubPath(dedupBlobPath, formPath) || model.IsSubPath(dedupBlobPath, toPath) {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"formPath": formPath, "toPath": toPath}).Error("移动文件，路径为保留路径")
+		return fmt.Errorf("移动文件，路径为保留路径")
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	//引用文件与登记随路径移动，blob与引用计数不变；覆盖的目标是引用时减少它的引用计数
+	oldRef, err := this.readRef(ctx, toPath)
+	if err != nil {
+		return err
+	}
+	err = this.storage.Move(ctx, formPath, toPath)
+	if err != nil {
+		return err
+	}
+	err = this.removeRef(ctx, toPath)
+	if err != nil {
+		return err
+	}
+	refInfo, err := this.storage.Stat(ctx, this.createRefPath(ctx, formPath))
+	if err != nil {
+		return err
+	}
+	if refInfo != nil {
+		err = this.storage.Move(ctx, this.createRefPath(ctx, formPath), this.createRefPath(ctx, toPath))
+		if err != nil {
+			return err
+		}
+		err = this.removeEmptyFolder(ctx, path.Dir(this.createRefPath(ctx, formPath)))
+		if err != nil {
+			return err
+		}
+	}
+	if oldRef == nil {
+		return nil
+	}
+	return this.addRefCount(ctx, oldRef.Hash, -1)
+}
+
+func (this *DedupStorage) Mkdir(ctx context.Context, folderPath string) error {
+	folderPath = ClearStoragePath(ctx, folderPath)
+	if model.IsSubPath(dedupBlobPath, folderPath) {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"folderPath": folderPath}).Error("创建文件夹，路径为保留路径")
+		return fmt.Errorf("创建文件夹，路径为保留路径")
+	}
+	return this.storage.Mkdir(ctx, folderPath)
+}
+
+// ReadGzip 读取引用指向的blob存储的gzip数据
+func (this *DedupStorage) ReadGzip(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	storage, ok := this.storage.(model.StorageGzipInter)
+	if !ok {
+		return nil, nil
+	}
+	ref, err := this.readRef(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if ref == nil {
+		return storage.ReadGzip(ctx, filePath)
+	}
+	return storage.ReadGzip(ctx, this.createBlobPath(ref.Hash))
+}
+
+// Reencrypt 引用文件与其指向的blob一起重新加密
+func (this *DedupStorage) Reencrypt(ctx context.Context, filePath string) (bool, error) {
+	storage, ok := this.storage.(model.StorageReencryptInter)
+	if !ok {
+		return false, nil
+	}
+	ref, err := this.readRef(ctx, filePath)
+	if err != nil {
+		return false, err
+	}
+	reencrypted, err := storage.Reencrypt(ctx, filePath)
+	if err != nil || ref == nil {
+		return reencrypted, err
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	blobReencrypted, err := storage.Reencrypt(ctx, this.createBlobPath(ref.Hash))
+	return reencrypted || blobReencrypted, err
+}
+
+func (this *DedupStorage) resolveInfo(ctx context.Context, info model.StorageInfo) *model.StorageInfo {
+	if info.IsDir {
+		return &info
+	}
+	ref, err := this.readRef(ctx, info.Path)
+	if ref == nil || err != nil {
+		return &info
+	}
+	info.Size = ref.Size
+	return &info
+}
+
+// readRef 没有登记或文件内容与登记不一致（去重模式开启前写入、外部放入或改写）时视为普通文件，返回nil
+func (this *DedupStorage) readRef(ctx context.Context, filePath string) (*dedupRef, error) {
+	data, err := this.readSmallFile(ctx, this.createRefPath(ctx, filePath))
+	if data == nil || err != nil {
+		return nil, err
+	}
+	ref := this.parseRef(data)
+	if ref == nil {
+		return nil, nil
+	}
+	content, err := this.readSmallFile(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(data, content) {
+		return nil, nil
+	}
+	return ref, nil
+}
+
+// readSmallFile 文件不存在、是文件夹或超过引用文件的大小上限时返回nil
+func (this *DedupStorage) readSmallFile(ctx context.Context, filePath string) ([]byte, error) {
+	info, err := this.storage.Stat(ctx, filePath)
+	if info == nil || err != nil {
+		return nil, err
+	}
+	if info.IsDir || dedupRefMaxSize < info.Size {
+		return nil, nil
+	}
+	reader, err := this.storage.Read(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath, "err": err}).Error("读取blob引用，异常")
+		return nil, fmt.Errorf("读取blob引用，异常: %+v", err)
+	}
+	return data, nil
+}
+
+// removeRef 删除引用登记，没有登记时不做处理
+func (this *DedupStorage) removeRef(ctx context.Context, filePath string) error {
+	refPath := this.createRefPath(ctx, filePath)
+	info, err := this.storage.Stat(ctx, refPath)
+	if info == nil || err != nil {
+		return err
+	}
+	err = this.storage.Remove(ctx, refPath)
+	if info.IsDir {
+		//文件夹里还有登记时留给里面的文件各自清理
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return this.removeEmptyFolder(ctx, path.Dir(refPath))
+}
+
+// removeEmptyFolder 底层存储只清理临时路径，blob与登记的文件夹变空后在这里清理
+func (this *DedupStorage) removeEmptyFolder(ctx context.Context, folderPath string) error {
+	for model.IsSubPath(dedupBlobPath, folderPath) {
+		infos, err := this.storage.List(ctx, folderPath)
+		if err != nil {
+			return err
+		}
+		if len(infos) > 0 {
+			return nil
+		}
+		err = this.storage.Remove(ctx, folderPath)
+		if err != nil {
+			return err
+		}
+		folderPath = path.Dir(folderPath)
+	}
+	return nil
+}
+
+// isRefSize 引用文件的长度只在很小的范围内，罗列时只解析这个范围内的文件
+func isRefSize(size int64) bool {
+	minSize := int64(len(dedupRefPrefix) + sha256.Size*2 + 3)
+	return minSize <= size && size <= minSize+18
+}
+
+// parseRef 格式不对时返回nil
+func (this *DedupStorage) parseRef(data []byte) *dedupRef {
+	if !bytes.HasPrefix(data, []byte(dedupRefPrefix)) {
+		return nil
+	}
+	parts := strings.Split(strings.TrimSpace(strings.TrimPrefix(string(data), dedupRefPrefix)), ":")
+	if len(parts) != 2 || len(parts[0]) != sha256.Size*2 {
+		return nil
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &dedupRef{Hash: parts[0], Size: size}
+}
+
+func (this *DedupStorage) formatRef(ref dedupRef) string {
+	return fmt.Sprintf("%s%s:%d\n", dedupRefPrefix, ref.Hash, ref.Size)
+}
+
+func (this *DedupStorage) addRefCount(ctx context.Context, hash string, delta int64) error {
+	countPath := this.createBlobPath(hash) + dedupRefExtension
+	count, err := this.readRefCount(ctx, countPath)
+	if err != nil {
+		return err
+	}
+	count += delta
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"hash": hash, "count": count}).Info("blob引用计数")
+	if count > 0 {
+		_, err = this.storage.Write(ctx, countPath, strings.NewReader(strconv.FormatInt(count, 10)))
+		return err
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"hash": hash}).Info("blob引用计数归零，删除blob")
+	err = this.storage.Remove(ctx, this.createBlobPath(hash))
+	if err != nil {
+		return err
+	}
+	err = this.storage.Remove(ctx, countPath)
+	if err != nil {
+		return err
+	}
+	return this.removeEmptyFolder(ctx, path.Dir(countPath))
+}
+
+func (this *DedupStorage) readRefCount(ctx context.Context, countPath string) (int64, error) {
+	info, err := this.storage.Stat(ctx, countPath)
+	if info == nil || err != nil {
+		return 0, err
+	}
+	reader, err := this.storage.Read(ctx, countPath)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"countPath": countPath, "err": err}).Error("读取blob引用计数，异常")
+		return 0, fmt.Errorf("读取blob引用计数，异常: %+v", err)
+	}
+	return util.String2Int64(strings.TrimSpace(string(data))), nil
+}
+
+func (this *DedupStorage) createRefPath(ctx context.Context, filePath string) string {
+	return path.Join(dedupRefPath, ClearStoragePath(ctx, filePath))
+}
+
+func (this *DedupStorage) createBlobPath(hash string) string {
+	return path.Join(dedupBlobPath, hash[:2], hash)
+}
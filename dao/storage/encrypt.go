@@ -0,0 +1,430 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+	"io"
+	"strings"
+)
+
+const (
+	encryptMagic     = "GFBE"
+	encryptVersion   = 1
+	encryptChunkSize = 64 * 1024
+	encryptNonceSize = 12
+	encryptTagSize   = 16
+)
+
+// EncryptStorage AES-GCM分块加密存储，密文格式为：头部 + 若干密文块
+// 头部：magic(4) + 版本(1) + 密钥Id长度(1) + 密钥Id + 块大小(4) + nonce(12)
+// 每块明文最多encryptChunkSize字节，最后一块必定不满（可以为空），附加数据里标记是否最后一块，防止截断
+// 没有头部的文件视为加密开启前写入的明文文件，原样读取
+type EncryptStorage struct {
+	storage      model.StorageInter
+	keys         map[string]cipher.AEAD
+	keyId        string
+	prefixKeys   []model.EncryptPrefixKey
+	fallbackKeys []string
+}
+
+// NewEncryptStorage keyId为默认写入密钥，prefixKeys按最长前缀优先选择写入密钥，都为空时写入明文
+func NewEncryptStorage(ctx context.Context, storage model.StorageInter, keys []model.EncryptKey, keyId string, prefixKeys []model.EncryptPrefixKey) (*EncryptStorage, error) {
+	if storage == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{}).Error("创建加密存储，底层存储为空")
+		return nil, fmt.Errorf("创建加密存储，底层存储为空")
+	}
+	object := &EncryptStorage{storage: storage, keys: make(map[string]cipher.AEAD), keyId: keyId}
+	for i := range keys {
+		if keys[i].Id == "" || 255 < len(keys[i].Id) {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"keyId": keys[i].Id}).Error("创建加密存储，密钥Id非法")
+			return nil, fmt.Errorf("创建加密存储，密钥Id非法: %+v", keys[i].Id)
+		}
+		key, err := base64.StdEncoding.DecodeString(keys[i].Key)
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"keyId": keys[i].Id, "err": err}).Error("创建加密存储，密钥解码异常")
+			return nil, fmt.Errorf("创建加密存储，密钥解码异常: %+v", err)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"keyId": keys[i].Id, "err": err}).Error("创建加密存储，密钥非法")
+			return nil, fmt.Errorf("创建加密存储，密钥非法: %+v", err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"keyId": keys[i].Id, "err": err}).Error("创建加密存储，创建GCM异常")
+			return nil, fmt.Errorf("创建加密存储，创建GCM异常: %+v", err)
+		}
+		object.keys[keys[i].Id] = aead
+	}
+	if keyId != "" {
+		object.fallbackKeys = append(object.fallbackKeys, keyId)
+	}
+	for i := range prefixKeys {
+		prefixKey := prefixKeys[i]
+		prefixKey.Path = ClearStoragePath(ctx, prefixKey.Path)
+		object.prefixKeys = append(object.prefixKeys, prefixKey)
+		object.fallbackKeys = append(object.fallbackKeys, prefixKey.KeyId)
+	}
+	for i := range object.fallbackKeys {
+		if _, ok := object.keys[object.fallbackKeys[i]]; !ok {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"keyId": object.fallbackKeys[i]}).Error("创建加密存储，密钥不存在")
+			return nil, fmt.Errorf("创建加密存储，密钥不存在: %+v", object.fallbackKeys[i])
+		}
+	}
+	return object, nil
+}
+
+type encryptHeader struct {
+	keyId     string
+	chunkSize int64
+	nonce     []byte
+}
+
+func (this encryptHeader) bytes() []byte {
+	var buffer bytes.Buffer
+	buffer.WriteString(encryptMagic)
+	buffer.WriteByte(encryptVersion)
+	buffer.WriteByte(byte(len(this.keyId)))
+	buffer.WriteString(this.keyId)
+	binary.Write(&buffer, binary.BigEndian, uint32(this.chunkSize))
+	buffer.Write(this.nonce)
+	return buffer.Bytes()
+}
+
+func (this encryptHeader) size() int64 {
+	return int64(len(encryptMagic) + 2 + len(this.keyId) + 4 + encryptNonceSize)
+}
+
+// plainSize 由密文大小推算明文大小：除最后一块外都是满块，每块多出一个tag
+func (this encryptHeader) plainSize(size int64) int64 {
+	body := size - this.size() - encryptTagSize
+	if body < 0 {
+		return 0
+	}
+	count := body / (this.chunkSize + encryptTagSize)
+	return count*this.chunkSize + body - count*(this.chunkSize+encryptTagSize)
+}
+
+func (this encryptHeader) createNonce(index uint64) []byte {
+	nonce := make([]byte, encryptNonceSize)
+	copy(nonce, this.nonce)
+	counter := binary.BigEndian.Uint64(nonce[encryptNonceSize-8:])
+	binary.BigEndian.PutUint64(nonce[encryptNonceSize-8:], counter^index)
+	return nonce
+}
+
+func (this encryptHeader) createAdditional(last bool) []byte {
+	additional := this.bytes()
+	if last {
+		return append(additional, 1)
+	}
+	return append(additional, 0)
+}
+
+func (this *EncryptStorage) Stat(ctx context.Context, fileOrFolderPath string) (*model.StorageInfo, error) {
+	info, err := this.storage.Stat(ctx, fileOrFolderPath)
+	if info == nil || err != nil {
+		return info, err
+	}
+	return this.resolveInfo(ctx, *info)
+}
+
+// List 不读取子文件的头部，Size为密文大小；明文大小以Stat或文件索引为准
+func (this *EncryptStorage) List(ctx context.Context, folderPath string) ([]model.StorageInfo, error) {
+	return this.storage.List(ctx, folderPath)
+}
+
+func (this *EncryptStorage) Read(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	reader, err := this.storage.Read(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	header, data, err := this.parseHeader(ctx, reader)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	if header == nil {
+		return &prefixReadCloser{reader: io.MultiReader(bytes.NewReader(data), reader), closer: reader}, nil
+	}
+	aead, ok := this.keys[header.keyId]
+	if !ok {
+		reader.Close()
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath, "keyId": header.keyId}).Error("解密文件，密钥不存在")
+		return nil, fmt.Errorf("解密文件，密钥不存在: %+v", header.keyId)
+	}
+	return &decryptReader{ctx: ctx, reader: reader, aead: aead, header: *header, buffer: make([]byte, header.chunkSize+encryptTagSize)}, nil
+}
+
+func (this *EncryptStorage) Write(ctx context.Context, filePath string, reader io.Reader) (int64, error) {
+	filePath = ClearStoragePath(ctx, filePath)
+	keyId := this.selectKeyId(ctx, filePath)
+	if keyId == "" {
+		return this.storage.Write(ctx, filePath, reader)
+	}
+	header := encryptHeader{keyId: keyId, chunkSize: encryptChunkSize, nonce: make([]byte, encryptNonceSize)}
+	_, err := io.ReadFull(rand.Reader, header.nonce)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("加密文件，生成nonce异常")
+		return 0, fmt.Errorf("加密文件，生成nonce异常: %+v", err)
+	}
+	counter := &countReader{reader: reader}
+	pipeReader, pipeWriter := io.Pipe()
+	go this.encrypt(header, this.keys[keyId], counter, pipeWriter)
+	_, err = this.storage.Write(ctx, filePath, pipeReader)
+	pipeReader.Close()
+	return counter.count, err
+}
+
+func (this *EncryptStorage) encrypt(header encryptHeader, aead cipher.AEAD, reader io.Reader, writer *io.PipeWriter) {
+	_, err := writer.Write(header.bytes())
+	if err != nil {
+		writer.CloseWithError(err)
+		return
+	}
+	buffer := make([]byte, header.chunkSize)
+	for index := uint64(0); ; index++ {
+		n, err := io.ReadFull(reader, buffer)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			writer.CloseWithError(err)
+			return
+		}
+		_, err = writer.Write(aead.Seal(nil, header.createNonce(index), buffer[:n], header.createAdditional(last)))
+		if err != nil {
+			writer.CloseWithError(err)
+			return
+		}
+		if last {
+			writer.Close()
+			return
+		}
+	}
+}
+
+func (this *EncryptStorage) Remove(ctx context.Context, filePath string) error {
+	return this.storage.Remove(ctx, filePath)
+}
+
+// Move 移动后目标路径的写入密钥不同时重新加密
+func (this *EncryptStorage) Move(ctx context.Context, formPath, toPath string) error {
+	err := this.storage.Move(ctx, formPath, toPath)
+	if err != nil {
+		return err
+	}
+	if len(this.prefixKeys) == 0 {
+		return nil
+	}
+	return this.reencryptAll(ctx, toPath)
+}
+
+func (this *EncryptStorage) reencryptAll(ctx context.Context, fileOrFolderPath string) error {
+	info, err := this.storage.Stat(ctx, fileOrFolderPath)
+	if info == nil || err != nil {
+		return err
+	}
+	if !info.IsDir {
+		_, err = this.Reencrypt(ctx, fileOrFolderPath)
+		return err
+	}
+	infos, err := this.storage.List(ctx, fileOrFolderPath)
+	if err != nil {
+		return err
+	}
+	for i := range infos {
+		err = this.reencryptAll(ctx, infos[i].Path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *EncryptStorage) Mkdir(ctx context.Context, folderPath string) error {
+	return this.storage.Mkdir(ctx, folderPath)
+}
+
+// Reencrypt 文件的密钥与路径当前的写入密钥不一致时重新加密；路径没有写入密钥时保持原样，不会解密为明文
+func (this *EncryptStorage) Reencrypt(ctx context.Context, filePath string) (bool, error) {
+	filePath = ClearStoragePath(ctx, filePath)
+	keyId := this.selectKeyId(ctx, filePath)
+	if keyId == "" {
+		return false, nil
+	}
+	reader, err := this.storage.Read(ctx, filePath)
+	if err != nil {
+		return false, err
+	}
+	header, _, err := this.parseHeader(ctx, reader)
+	reader.Close()
+	if err != nil {
+		return false, err
+	}
+	if header != nil && header.keyId == keyId {
+		return false, nil
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath, "keyId": keyId}).Info("重新加密文件")
+	reader, err = this.Read(ctx, filePath)
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+	_, err = this.Write(ctx, filePath, reader)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// selectKeyId 回收站与历史版本按原路径选择密钥；临时文件与blob无法对应原路径，使用默认密钥或第一个密钥，移动到目标路径时再按需重新加密
+func (this *EncryptStorage) selectKeyId(ctx context.Context, filePath string) string {
+	if model.IsSubPath(model.TmpPath, filePath) || model.IsSubPath(model.BlobPath, filePath) {
+		if len(this.fallbackKeys) == 0 {
+			return ""
+		}
+		return this.fallbackKeys[0]
+	}
+	if model.IsSubPath(model.TrashPath, filePath) {
+		filePath = ClearStoragePath(ctx, strings.TrimPrefix(filePath, model.TrashPath))
+	} else if model.IsSubPath(model.VersionPath, filePath) {
+		filePath = ClearStoragePath(ctx, strings.TrimPrefix(filePath, model.VersionPath))
+	}
+	keyId := this.keyId
+	matchLength := -1
+	for i := range this.prefixKeys {
+		if model.IsSubPath(this.prefixKeys[i].Path, filePath) && matchLength < len(this.prefixKeys[i].Path) {
+			keyId = this.prefixKeys[i].KeyId
+			matchLength = len(this.prefixKeys[i].Path)
+		}
+	}
+	return keyId
+}
+
+func (this *EncryptStorage) resolveInfo(ctx context.Context, info model.StorageInfo) (*model.StorageInfo, error) {
+	if info.IsDir {
+		return &info, nil
+	}
+	reader, err := this.storage.Read(ctx, info.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	header, _, err := this.parseHeader(ctx, reader)
+	if err != nil {
+		return nil, err
+	}
+	if header != nil {
+		if info.StoredSize <= 0 {
+			info.StoredSize = info.Size
+		}
+		info.Size = header.plainSize(info.Size)
+	}
+	return &info, nil
+}
+
+// parseHeader 读取密文头部，不是密文时返回nil与已读取的数据
+func (this *EncryptStorage) parseHeader(ctx context.Context, reader io.Reader) (*encryptHeader, []byte, error) {
+	data := make([]byte, len(encryptMagic)+2)
+	n, err := io.ReadFull(reader, data)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, data[:n], nil
+	}
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("读取密文头部，异常")
+		return nil, nil, fmt.Errorf("读取密文头部，异常: %+v", err)
+	}
+	if string(data[:len(encryptMagic)]) != encryptMagic || data[len(encryptMagic)] != encryptVersion {
+		return nil, data, nil
+	}
+	rest := make([]byte, int(data[len(encryptMagic)+1])+4+encryptNonceSize)
+	_, err = io.ReadFull(reader, rest)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("读取密文头部，头部不完整")
+		return nil, nil, fmt.Errorf("读取密文头部，头部不完整: %+v", err)
+	}
+	var header encryptHeader
+	keyIdLength := int(data[len(encryptMagic)+1])
+	header.keyId = string(rest[:keyIdLength])
+	header.chunkSize = int64(binary.BigEndian.Uint32(rest[keyIdLength : keyIdLength+4]))
+	header.nonce = rest[keyIdLength+4:]
+	if header.chunkSize <= 0 {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{}).Error("读取密文头部，块大小非法")
+		return nil, nil, fmt.Errorf("读取密文头部，块大小非法")
+	}
+	return &header, data, nil
+}
+
+type decryptReader struct {
+	ctx    context.Context
+	reader io.ReadCloser
+	aead   cipher.AEAD
+	header encryptHeader
+	buffer []byte
+	plain  []byte
+	index  uint64
+	done   bool
+}
+
+func (this *decryptReader) Read(p []byte) (int, error) {
+	for len(this.plain) == 0 {
+		if this.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(this.reader, this.buffer)
+		last := err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			if err == io.EOF {
+				logrus.WithContext(this.ctx).WithFields(logrus.Fields{}).Error("解密文件，密文被截断")
+				return 0, fmt.Errorf("解密文件，密文被截断")
+			}
+			return 0, err
+		}
+		plain, err := this.aead.Open(this.buffer[:0], this.header.createNonce(this.index), this.buffer[:n], this.header.createAdditional(last))
+		if err != nil {
+			logrus.WithContext(this.ctx).WithFields(logrus.Fields{"index": this.index, "err": err}).Error("解密文件，校验失败")
+			return 0, fmt.Errorf("解密文件，校验失败: %+v", err)
+		}
+		this.plain = plain
+		this.index++
+		this.done = last
+	}
+	n := copy(p, this.plain)
+	this.plain = this.plain[n:]
+	return n, nil
+}
+
+func (this *decryptReader) Close() error {
+	return this.reader.Close()
+}
+
+type prefixReadCloser struct {
+	reader io.Reader
+	closer io.Closer
+}
+
+func (this *prefixReadCloser) Read(p []byte) (int, error) {
+	return this.reader.Read(p)
+}
+
+func (this *prefixReadCloser) Close() error {
+	return this.closer.Close()
+}
+
+type countReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (this *countReader) Read(p []byte) (int, error) {
+	n, err := this.reader.Read(p)
+	this.count += int64(n)
+	return n, err
+}
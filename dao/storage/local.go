@@ -0,0 +1,311 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type LocalStorage struct {
+	root          string
+	realRoot      string
+	symlinkPolicy string
+}
+
+func NewLocalStorage(ctx context.Context, root, symlinkPolicy string) (*LocalStorage, error) {
+	root = util.ClearPath(ctx, root)
+	err := util.CreateFolderPath(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	switch symlinkPolicy {
+	case "":
+		symlinkPolicy = model.SymlinkFollowWithinRoot
+	case model.SymlinkDeny, model.SymlinkFollowWithinRoot, model.SymlinkAllow:
+	default:
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"symlinkPolicy": symlinkPolicy}).Error("创建本地存储，未知符号链接策略")
+		return nil, fmt.Errorf("创建本地存储，未知符号链接策略: %+v", symlinkPolicy)
+	}
+	//床路径本身可以是符号链接（如挂载的卷），以解析后的真实路径作为边界
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"root": root, "err": err}).Error("创建本地存储，解析床路径异常")
+		return nil, fmt.Errorf("创建本地存储，解析床路径异常: %+v", err)
+	}
+	realRoot, err = filepath.Abs(realRoot)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"root": root, "err": err}).Error("创建本地存储，解析床路径异常")
+		return nil, fmt.Errorf("创建本地存储，解析床路径异常: %+v", err)
+	}
+	return &LocalStorage{root: root, realRoot: realRoot, symlinkPolicy: symlinkPolicy}, nil
+}
+
+func (this *LocalStorage) Stat(ctx context.Context, fileOrFolderPath string) (*model.StorageInfo, error) {
+	bedPath, err := this.createBedPath(ctx, fileOrFolderPath)
+	if err != nil {
+		return nil, err
+	}
+	pathInfo := util.GetPathInfo(ctx, bedPath)
+	if pathInfo == nil {
+		return nil, nil
+	}
+	info := this.createStorageInfo(ctx, ClearStoragePath(ctx, fileOrFolderPath), pathInfo)
+	return &info, nil
+}
+
+func (this *LocalStorage) List(ctx context.Context, folderPath string) ([]model.StorageInfo, error) {
+	bedPath, err := this.createBedPath(ctx, folderPath)
+	if err != nil {
+		return nil, err
+	}
+	files, err := util.ListFile(ctx, bedPath)
+	if err != nil {
+		return nil, err
+	}
+	folderPath = ClearStoragePath(ctx, folderPath)
+	infos := make([]model.StorageInfo, 0, len(files))
+	for i := range files {
+		childPath := path.Join(folderPath, files[i].Name())
+		if childPath == model.TmpPath {
+			continue
+		}
+		if files[i].Mode()&os.ModeSymlink != 0 {
+			_, err = this.createBedPath(ctx, childPath)
+			if err != nil {
+				continue
+			}
+			//罗列结果按链接目标展示
+			pathInfo := util.GetPathInfo(ctx, path.Join(bedPath, files[i].Name()))
+			if pathInfo == nil {
+				continue
+			}
+			infos = append(infos, this.createStorageInfo(ctx, childPath, pathInfo))
+			continue
+		}
+		infos = append(infos, this.createStorageInfo(ctx, childPath, files[i]))
+	}
+	return infos, nil
+}
+
+func (this *LocalStorage) Read(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	bedPath, err := this.createBedPath(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if util.GetFileInfo(ctx, bedPath) == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"bedPath": bedPath}).Error("读取文件，文件不存在")
+		return nil, fmt.Errorf("读取文件，文件不存在")
+	}
+	return util.GetReadFile(ctx, bedPath)
+}
+
+// Write 先写入同一卷下的临时文件，fsync后再rename到目标路径，覆盖写要么成功要么不生效
+func (this *LocalStorage) Write(ctx context.Context, filePath string, reader io.Reader) (int64, error) {
+	bedPath, err := this.createBedPath(ctx, filePath)
+	if err != nil {
+		return 0, err
+	}
+	tmpPath, err := this.createBedPath(ctx, path.Join(model.TmpPath, "write", util.GenStringId()))
+	if err != nil {
+		return 0, err
+	}
+	file, err := util.GetWriteFile(ctx, tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(file, reader)
+	if err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"bedPath": bedPath, "err": err}).Error("写入文件，异常")
+		return written, fmt.Errorf("写入文件，异常: %+v", err)
+	}
+	err = file.Sync()
+	file.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"bedPath": bedPath, "err": err}).Error("写入文件，fsync异常")
+		return written, fmt.Errorf("写入文件，fsync异常: %+v", err)
+	}
+
+	folderPath := path.Dir(bedPath)
+	err = util.CreateFolderPath(ctx, folderPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return written, err
+	}
+	err = os.Rename(tmpPath, bedPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"bedPath": bedPath, "err": err}).Error("写入文件，重命名临时文件异常")
+		return written, fmt.Errorf("写入文件，重命名临时文件异常: %+v", err)
+	}
+	this.syncFolder(ctx, folderPath)
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"bedPath": bedPath, "written": written}).Info("写入文件，完成")
+	return written, nil
+}
+
+func (this *LocalStorage) syncFolder(ctx context.Context, folderPath string) {
+	folder, err := os.Open(folderPath)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"folderPath": folderPath, "err": err}).Warn("写入文件，打开父文件夹异常")
+		return
+	}
+	defer folder.Close()
+	err = folder.Sync()
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"folderPath": folderPath, "err": err}).Warn("写入文件，fsync父文件夹异常")
+	}
+}
+
+func (this *LocalStorage) Remove(ctx context.Context, filePath string) error {
+	bedPath, err := this.createBedPath(ctx, filePath)
+	if err != nil {
+		return err
+	}
+	err = util.RemoveFile(ctx, bedPath)
+	if err != nil {
+		return err
+	}
+	return this.removeEmptyFolder(ctx, path.Dir(bedPath))
+}
+
+// removeEmptyFolder 只清理临时路径下变空的文件夹，床里的文件夹即使空了也保留
+func (this *LocalStorage) removeEmptyFolder(ctx context.Context, folderPath string) error {
+	for i := 0; i < 1024; i++ {
+		if folderPath == this.root+model.TmpPath || !model.IsSubPath(this.root+model.TmpPath, folderPath) {
+			return nil
+		}
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"folderPath": folderPath}).Info("删除文件，删除父文件夹")
+		files, err := util.ListFile(ctx, folderPath)
+		if err != nil {
+			return err
+		}
+		if len(files) > 0 {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"folderPath": folderPath}).Info("删除文件，父文件夹不为空")
+			return nil
+		}
+		err = util.RemoveFile(ctx, folderPath)
+		if err != nil {
+			return err
+		}
+		//将`/aaa/bbb`变为`/aaa`
+		folderPath = path.Dir(folderPath)
+	}
+	return nil
+}
+
+func (this *LocalStorage) Move(ctx context.Context, formPath, toPath string) error {
+	formBedPath, err := this.createBedPath(ctx, formPath)
+	if err != nil {
+		return err
+	}
+	toBedPath, err := this.createBedPath(ctx, toPath)
+	if err != nil {
+		return err
+	}
+	if util.GetPathInfo(ctx, formBedPath) == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"formBedPath": formBedPath}).Error("移动文件，源文件不存在")
+		return fmt.Errorf("移动文件，源文件不存在")
+	}
+	err = util.CreateFolderPath(ctx, path.Dir(toBedPath))
+	if err != nil {
+		return err
+	}
+	err = os.Rename(formBedPath, toBedPath)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("移动文件，异常")
+		return fmt.Errorf("移动文件，异常: %+v", err)
+	}
+	return this.removeEmptyFolder(ctx, path.Dir(formBedPath))
+}
+
+func (this *LocalStorage) Mkdir(ctx context.Context, folderPath string) error {
+	bedPath, err := this.createBedPath(ctx, folderPath)
+	if err != nil {
+		return err
+	}
+	pathInfo := util.GetPathInfo(ctx, bedPath)
+	if pathInfo != nil && !pathInfo.IsDir() {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"bedPath": bedPath}).Error("创建文件夹，路径为文件")
+		return fmt.Errorf("创建文件夹，路径为文件")
+	}
+	return util.CreateFolderPath(ctx, bedPath)
+}
+
+func (this *LocalStorage) createStorageInfo(ctx context.Context, fileOrFolderPath string, pathInfo os.FileInfo) model.StorageInfo {
+	var info model.StorageInfo
+	info.Path = fileOrFolderPath
+	info.Name = pathInfo.Name()
+	info.IsDir = pathInfo.IsDir()
+	if !info.IsDir {
+		info.Size = pathInfo.Size()
+	}
+	info.ModTime = pathInfo.ModTime()
+	return info
+}
+
+func (this *LocalStorage) createBedPath(ctx context.Context, fileOrFolderPath string) (string, error) {
+	bedPath := util.ClearPath(ctx, path.Join(this.root, fileOrFolderPath))
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"bedPath": bedPath}).Info("创建床文件路径")
+
+	if bedPath != this.root && !strings.HasPrefix(bedPath, this.root+"/") {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"bedPath": bedPath}).Error("文件路径不在床路径下")
+		return "", fmt.Errorf("文件路径不在床路径下")
+	}
+	err := this.checkSymlink(ctx, bedPath)
+	if err != nil {
+		return "", err
+	}
+
+	return bedPath, nil
+}
+
+// checkSymlink 逐级检查路径上已存在的部分，按策略拒绝符号链接或拒绝指向床路径之外的符号链接
+func (this *LocalStorage) checkSymlink(ctx context.Context, bedPath string) error {
+	if this.symlinkPolicy == model.SymlinkAllow {
+		return nil
+	}
+	currentPath := this.root
+	for _, name := range strings.Split(strings.TrimPrefix(bedPath, this.root), "/") {
+		if name == "" {
+			continue
+		}
+		currentPath = path.Join(currentPath, name)
+		pathInfo, err := os.Lstat(currentPath)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"currentPath": currentPath, "err": err}).Error("检查符号链接，读取路径异常")
+			return fmt.Errorf("检查符号链接，读取路径异常: %+v", err)
+		}
+		if pathInfo.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		if this.symlinkPolicy == model.SymlinkDeny {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"currentPath": currentPath}).Error("检查符号链接，不允许符号链接")
+			return fmt.Errorf("检查符号链接，不允许符号链接")
+		}
+		realPath, err := filepath.EvalSymlinks(currentPath)
+		if err == nil {
+			realPath, err = filepath.Abs(realPath)
+		}
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"currentPath": currentPath, "err": err}).Error("检查符号链接，解析符号链接异常")
+			return fmt.Errorf("检查符号链接，解析符号链接异常: %+v", err)
+		}
+		if realPath != this.realRoot && !strings.HasPrefix(realPath, this.realRoot+string(filepath.Separator)) {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"currentPath": currentPath, "realPath": realPath}).Error("检查符号链接，符号链接指向床路径之外")
+			return fmt.Errorf("检查符号链接，符号链接指向床路径之外")
+		}
+	}
+	return nil
+}
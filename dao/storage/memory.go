@@ -0,0 +1,291 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// MemoryStorage 内存存储，文件夹由文件路径隐式表示，只有显式创建的空文件夹才单独存储，主要用于测试
+type MemoryStorage struct {
+	lock    sync.RWMutex
+	files   map[string]memoryFile
+	folders map[string]time.Time
+}
+
+func NewMemoryStorage(ctx context.Context) (*MemoryStorage, error) {
+	return &MemoryStorage{files: make(map[string]memoryFile), folders: make(map[string]time.Time)}, nil
+}
+
+func (this *MemoryStorage) Stat(ctx context.Context, fileOrFolderPath string) (*model.StorageInfo, error) {
+	fileOrFolderPath = ClearStoragePath(ctx, fileOrFolderPath)
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	file, ok := this.files[fileOrFolderPath]
+	if ok {
+		info := this.createFileInfo(fileOrFolderPath, file)
+		return &info, nil
+	}
+	var modTime time.Time
+	exist := fileOrFolderPath == "/"
+	for filePath, file := range this.files {
+		if !model.IsSubPath(fileOrFolderPath, filePath) {
+			continue
+		}
+		exist = true
+		if modTime.Before(file.modTime) {
+			modTime = file.modTime
+		}
+	}
+	for folderPath, folderModTime := range this.folders {
+		if !model.IsSubPath(fileOrFolderPath, folderPath) {
+			continue
+		}
+		exist = true
+		if modTime.Before(folderModTime) {
+			modTime = folderModTime
+		}
+	}
+	if !exist {
+		return nil, nil
+	}
+	info := this.createFolderInfo(fileOrFolderPath, modTime)
+	return &info, nil
+}
+
+func (this *MemoryStorage) List(ctx context.Context, folderPath string) ([]model.StorageInfo, error) {
+	folderPath = ClearStoragePath(ctx, folderPath)
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	if _, ok := this.files[folderPath]; ok {
+		return nil, nil
+	}
+	folders := make(map[string]time.Time)
+	var infos []model.StorageInfo
+	for filePath, file := range this.files {
+		if filePath == folderPath || !model.IsSubPath(folderPath, filePath) {
+			continue
+		}
+		rest := strings.TrimPrefix(filePath, folderPath)
+		rest = strings.TrimPrefix(rest, "/")
+		name := strings.SplitN(rest, "/", 2)[0]
+		childPath := path.Join(folderPath, name)
+		if childPath == filePath {
+			infos = append(infos, this.createFileInfo(filePath, file))
+			continue
+		}
+		if folders[childPath].Before(file.modTime) {
+			folders[childPath] = file.modTime
+		}
+	}
+	for existPath, modTime := range this.folders {
+		if existPath == folderPath || !model.IsSubPath(folderPath, existPath) {
+			continue
+		}
+		rest := strings.TrimPrefix(existPath, folderPath)
+		rest = strings.TrimPrefix(rest, "/")
+		childPath := path.Join(folderPath, strings.SplitN(rest, "/", 2)[0])
+		if folders[childPath].Before(modTime) {
+			folders[childPath] = modTime
+		}
+	}
+	for childPath, modTime := range folders {
+		infos = append(infos, this.createFolderInfo(childPath, modTime))
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos, nil
+}
+
+func (this *MemoryStorage) Read(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	filePath = ClearStoragePath(ctx, filePath)
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	file, ok := this.files[filePath]
+	if !ok {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath}).Error("读取文件，文件不存在")
+		return nil, fmt.Errorf("读取文件，文件不存在")
+	}
+	return ioutil.NopCloser(bytes.NewReader(file.data)), nil
+}
+
+func (this *MemoryStorage) Write(ctx context.Context, filePath string, reader io.Reader) (int64, error) {
+	filePath = ClearStoragePath(ctx, filePath)
+	if filePath == "/" {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath}).Error("写入文件，路径为文件夹")
+		return 0, fmt.Errorf("写入文件，路径为文件夹")
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath, "err": err}).Error("写入文件，异常")
+		return int64(len(data)), fmt.Errorf("写入文件，异常: %+v", err)
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for existPath := range this.files {
+		if existPath != filePath && model.IsSubPath(filePath, existPath) {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath}).Error("写入文件，路径为文件夹")
+			return 0, fmt.Errorf("写入文件，路径为文件夹")
+		}
+		if existPath != filePath && model.IsSubPath(existPath, filePath) {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath}).Error("写入文件，父路径为文件")
+			return 0, fmt.Errorf("写入文件，父路径为文件")
+		}
+	}
+	for existPath := range this.folders {
+		if model.IsSubPath(filePath, existPath) {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath}).Error("写入文件，路径为文件夹")
+			return 0, fmt.Errorf("写入文件，路径为文件夹")
+		}
+	}
+	this.files[filePath] = memoryFile{data: data, modTime: time.Now()}
+	this.mkdirParent(filePath)
+	return int64(len(data)), nil
+}
+
+// mkdirParent 与本地存储一致，父文件夹写入时登记为显式文件夹，里面的文件删除或移走后仍然保留，调用方需持有写锁
+func (this *MemoryStorage) mkdirParent(filePath string) {
+	for folderPath := path.Dir(filePath); folderPath != "/"; folderPath = path.Dir(folderPath) {
+		if _, ok := this.folders[folderPath]; ok {
+			return
+		}
+		this.folders[folderPath] = time.Now()
+	}
+}
+
+func (this *MemoryStorage) Remove(ctx context.Context, filePath string) error {
+	filePath = ClearStoragePath(ctx, filePath)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if _, ok := this.files[filePath]; ok {
+		delete(this.files, filePath)
+		this.removeEmptyFolder(path.Dir(filePath))
+		return nil
+	}
+	for existPath := range this.files {
+		if model.IsSubPath(filePath, existPath) {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath}).Error("删除文件，文件夹不为空")
+			return fmt.Errorf("删除文件，文件夹不为空")
+		}
+	}
+	for existPath := range this.folders {
+		if existPath != filePath && model.IsSubPath(filePath, existPath) {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath}).Error("删除文件，文件夹不为空")
+			return fmt.Errorf("删除文件，文件夹不为空")
+		}
+	}
+	delete(this.folders, filePath)
+	this.removeEmptyFolder(path.Dir(filePath))
+	return nil
+}
+
+// removeEmptyFolder 与本地存储一致，只清理临时路径下变空的文件夹，调用方需持有写锁
+func (this *MemoryStorage) removeEmptyFolder(folderPath string) {
+	for folderPath != model.TmpPath && model.IsSubPath(model.TmpPath, folderPath) {
+		for existPath := range this.files {
+			if model.IsSubPath(folderPath, existPath) {
+				return
+			}
+		}
+		for existPath := range this.folders {
+			if existPath != folderPath && model.IsSubPath(folderPath, existPath) {
+				return
+			}
+		}
+		delete(this.folders, folderPath)
+		folderPath = path.Dir(folderPath)
+	}
+}
+
+func (this *MemoryStorage) Move(ctx context.Context, formPath, toPath string) error {
+	formPath = ClearStoragePath(ctx, formPath)
+	toPath = ClearStoragePath(ctx, toPath)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	moved := make(map[string]memoryFile)
+	for existPath, file := range this.files {
+		if !model.IsSubPath(formPath, existPath) {
+			continue
+		}
+		moved[path.Join(toPath, strings.TrimPrefix(existPath, formPath))] = file
+		delete(this.files, existPath)
+	}
+	movedFolders := make(map[string]time.Time)
+	for existPath, modTime := range this.folders {
+		if !model.IsSubPath(formPath, existPath) {
+			continue
+		}
+		movedFolders[path.Join(toPath, strings.TrimPrefix(existPath, formPath))] = modTime
+		delete(this.folders, existPath)
+	}
+	if len(moved) == 0 && len(movedFolders) == 0 {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"formPath": formPath}).Error("移动文件，源文件不存在")
+		return fmt.Errorf("移动文件，源文件不存在")
+	}
+	for existPath, file := range moved {
+		this.files[existPath] = file
+	}
+	for existPath, modTime := range movedFolders {
+		this.folders[existPath] = modTime
+	}
+	this.mkdirParent(toPath)
+	this.removeEmptyFolder(path.Dir(formPath))
+	return nil
+}
+
+func (this *MemoryStorage) Mkdir(ctx context.Context, folderPath string) error {
+	folderPath = ClearStoragePath(ctx, folderPath)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for existPath := range this.files {
+		if model.IsSubPath(existPath, folderPath) {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"folderPath": folderPath}).Error("创建文件夹，路径或父路径为文件")
+			return fmt.Errorf("创建文件夹，路径或父路径为文件")
+		}
+	}
+	if folderPath != "/" {
+		this.folders[folderPath] = time.Now()
+		this.mkdirParent(folderPath)
+	}
+	return nil
+}
+
+func (this *MemoryStorage) createFileInfo(filePath string, file memoryFile) model.StorageInfo {
+	var info model.StorageInfo
+	info.Path = filePath
+	info.Name = path.Base(filePath)
+	info.Size = int64(len(file.data))
+	info.ModTime = file.modTime
+	return info
+}
+
+func (this *MemoryStorage) createFolderInfo(folderPath string, modTime time.Time) model.StorageInfo {
+	var info model.StorageInfo
+	info.Path = folderPath
+	info.Name = path.Base(folderPath)
+	info.IsDir = true
+	info.ModTime = modTime
+	return info
+}
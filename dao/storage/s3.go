@@ -0,0 +1,648 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3Algorithm       = "AWS4-HMAC-SHA256"
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3EmptySha256     = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	s3MaxCopySize     = 1024 * 1024 * 1024 * 5 //单次复制的上限，更大的对象要分片复制
+	s3CopyPartSize    = 1024 * 1024 * 512
+)
+
+// S3Storage 兼容S3协议的对象存储，文件夹由对象key的`/`前缀隐式表示，空文件夹用以`/`结尾的空对象标记
+type S3Storage struct {
+	endpoint   string
+	region     string
+	bucket     string
+	prefix     string
+	accessKey  string
+	secretKey  string
+	pathStyle  bool
+	tmpPath    string
+	httpClient *http.Client
+}
+
+// NewS3Storage prefix为对象key的公共前缀，多个床共用一个S3 bucket时用来隔离；tmpPath为写入前暂存数据的本地文件夹
+func NewS3Storage(ctx context.Context, endpoint, region, bucket, prefix, accessKey, secretKey string, pathStyle bool, tmpPath string) (*S3Storage, error) {
+	if endpoint == "" || bucket == "" {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"endpoint": endpoint, "bucket": bucket}).Error("创建S3存储，endpoint或bucket为空")
+		return nil, fmt.Errorf("创建S3存储，endpoint或bucket为空")
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		endpoint = "https://" + endpoint
+	}
+	prefix = strings.Trim(prefix, "/")
+	return &S3Storage{endpoint: endpoint, region: region, bucket: bucket, prefix: prefix, accessKey: accessKey, secretKey: secretKey, pathStyle: pathStyle, tmpPath: tmpPath, httpClient: &http.Client{}}, nil
+}
+
+type s3ListResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+	NextContinuationToken string   `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+type s3InitiateMultipartResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	UploadId string   `xml:"UploadId"`
+}
+
+type s3CopyPartResult struct {
+	XMLName xml.Name `xml:"CopyPartResult"`
+	ETag    string   `xml:"ETag"`
+}
+
+type s3CompletePart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type s3CompleteMultipartUpload struct {
+	XMLName xml.Name         `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletePart `xml:"Part"`
+}
+
+func (this *S3Storage) Stat(ctx context.Context, fileOrFolderPath string) (*model.StorageInfo, error) {
+	fileOrFolderPath = ClearStoragePath(ctx, fileOrFolderPath)
+	if fileOrFolderPath != "/" {
+		response, err := this.do(ctx, http.MethodHead, this.createKey(fileOrFolderPath), nil, nil, nil, -1)
+		if err != nil {
+			return nil, err
+		}
+		response.Body.Close()
+		if response.StatusCode == http.StatusOK {
+			var info model.StorageInfo
+			info.Path = fileOrFolderPath
+			info.Name = path.Base(fileOrFolderPath)
+			info.Size = response.ContentLength
+			info.ModTime, _ = http.ParseTime(response.Header.Get("Last-Modified"))
+			return &info, nil
+		}
+		if response.StatusCode != http.StatusNotFound {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"statusCode": response.StatusCode}).Error("S3查询文件，响应码失败")
+			return nil, fmt.Errorf("S3查询文件，响应码失败: %+v", response.StatusCode)
+		}
+	}
+
+	result, err := this.list(ctx, this.createPrefix(fileOrFolderPath), "", "1")
+	if err != nil {
+		return nil, err
+	}
+	if fileOrFolderPath != "/" && len(result.Contents) == 0 && len(result.CommonPrefixes) == 0 {
+		return nil, nil
+	}
+	var info model.StorageInfo
+	info.Path = fileOrFolderPath
+	info.Name = path.Base(fileOrFolderPath)
+	info.IsDir = true
+	return &info, nil
+}
+
+func (this *S3Storage) List(ctx context.Context, folderPath string) ([]model.StorageInfo, error) {
+	folderPath = ClearStoragePath(ctx, folderPath)
+	prefix := this.createPrefix(folderPath)
+	var infos []model.StorageInfo
+	var token string
+	for {
+		result, err := this.list(ctx, prefix, token, "")
+		if err != nil {
+			return nil, err
+		}
+		for i := range result.CommonPrefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(result.CommonPrefixes[i].Prefix, prefix), "/")
+			var info model.StorageInfo
+			info.Path = path.Join(folderPath, name)
+			info.Name = name
+			info.IsDir = true
+			infos = append(infos, info)
+		}
+		for i := range result.Contents {
+			name := strings.TrimPrefix(result.Contents[i].Key, prefix)
+			if name == "" || strings.Contains(name, "/") {
+				continue
+			}
+			var info model.StorageInfo
+			info.Path = path.Join(folderPath, name)
+			info.Name = name
+			info.Size = result.Contents[i].Size
+			info.ModTime = result.Contents[i].LastModified
+			infos = append(infos, info)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	return infos, nil
+}
+
+func (this *S3Storage) list(ctx context.Context, prefix, token, maxKeys string) (*s3ListResult, error) {
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("delimiter", "/")
+	query.Set("prefix", prefix)
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+	if maxKeys != "" {
+		query.Set("max-keys", maxKeys)
+	}
+	response, err := this.do(ctx, http.MethodGet, "", query, nil, nil, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"statusCode": response.StatusCode}).Error("S3罗列文件，响应码失败")
+		return nil, fmt.Errorf("S3罗列文件，响应码失败: %+v", response.StatusCode)
+	}
+	var result s3ListResult
+	err = xml.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("S3罗列文件，解析响应异常")
+		return nil, fmt.Errorf("S3罗列文件，解析响应异常: %+v", err)
+	}
+	return &result, nil
+}
+
+func (this *S3Storage) Read(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	filePath = ClearStoragePath(ctx, filePath)
+	response, err := this.do(ctx, http.MethodGet, this.createKey(filePath), nil, nil, nil, -1)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath, "statusCode": response.StatusCode}).Error("S3读取文件，响应码失败")
+		return nil, fmt.Errorf("S3读取文件，响应码失败: %+v", response.StatusCode)
+	}
+	return response.Body, nil
+}
+
+func (this *S3Storage) Write(ctx context.Context, filePath string, reader io.Reader) (int64, error) {
+	filePath = ClearStoragePath(ctx, filePath)
+	//S3的PUT需要Content-Length，先落到本地的临时文件
+	err := util.CreateFolderPath(ctx, this.tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	tmpFile, err := ioutil.TempFile(this.tmpPath, "s3_")
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("S3写入文件，创建临时文件异常")
+		return 0, fmt.Errorf("S3写入文件，创建临时文件异常: %+v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+	written, err := io.Copy(tmpFile, reader)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("S3写入文件，写入临时文件异常")
+		return written, fmt.Errorf("S3写入文件，写入临时文件异常: %+v", err)
+	}
+	_, err = tmpFile.Seek(0, io.SeekStart)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("S3写入文件，读取临时文件异常")
+		return written, fmt.Errorf("S3写入文件，读取临时文件异常: %+v", err)
+	}
+
+	response, err := this.do(ctx, http.MethodPut, this.createKey(filePath), nil, nil, tmpFile, written)
+	if err != nil {
+		return written, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath, "statusCode": response.StatusCode}).Error("S3写入文件，响应码失败")
+		return written, fmt.Errorf("S3写入文件，响应码失败: %+v", response.StatusCode)
+	}
+	return written, nil
+}
+
+func (this *S3Storage) Remove(ctx context.Context, filePath string) error {
+	filePath = ClearStoragePath(ctx, filePath)
+	info, err := this.Stat(ctx, filePath)
+	if info == nil || err != nil {
+		return err
+	}
+	key := this.createKey(filePath)
+	if info.IsDir {
+		infos, err := this.List(ctx, filePath)
+		if err != nil {
+			return err
+		}
+		if len(infos) > 0 {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath}).Error("S3删除文件，文件夹不为空")
+			return fmt.Errorf("S3删除文件，文件夹不为空")
+		}
+		key = this.createPrefix(filePath)
+	}
+	return this.removeObject(ctx, key)
+}
+
+func (this *S3Storage) removeObject(ctx context.Context, key string) error {
+	response, err := this.do(ctx, http.MethodDelete, key, nil, nil, nil, -1)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusNoContent && response.StatusCode != http.StatusOK {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"key": key, "statusCode": response.StatusCode}).Error("S3删除文件，响应码失败")
+		return fmt.Errorf("S3删除文件，响应码失败: %+v", response.StatusCode)
+	}
+	return nil
+}
+
+func (this *S3Storage) Move(ctx context.Context, formPath, toPath string) error {
+	formPath = ClearStoragePath(ctx, formPath)
+	toPath = ClearStoragePath(ctx, toPath)
+	info, err := this.Stat(ctx, formPath)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"formPath": formPath}).Error("移动文件，源文件不存在")
+		return fmt.Errorf("移动文件，源文件不存在")
+	}
+	if !info.IsDir {
+		return this.moveObject(ctx, formPath, toPath, info.Size)
+	}
+	infos, err := this.List(ctx, formPath)
+	if err != nil {
+		return err
+	}
+	for i := range infos {
+		err = this.Move(ctx, infos[i].Path, path.Join(toPath, infos[i].Name))
+		if err != nil {
+			return err
+		}
+	}
+	marker, err := this.existObject(ctx, this.createPrefix(formPath))
+	if !marker || err != nil {
+		return err
+	}
+	err = this.Mkdir(ctx, toPath)
+	if err != nil {
+		return err
+	}
+	return this.removeObject(ctx, this.createPrefix(formPath))
+}
+
+func (this *S3Storage) Mkdir(ctx context.Context, folderPath string) error {
+	folderPath = ClearStoragePath(ctx, folderPath)
+	if folderPath == "/" {
+		return nil
+	}
+	info, err := this.Stat(ctx, folderPath)
+	if err != nil {
+		return err
+	}
+	if info != nil && !info.IsDir {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"folderPath": folderPath}).Error("S3创建文件夹，路径为文件")
+		return fmt.Errorf("S3创建文件夹，路径为文件")
+	}
+	response, err := this.do(ctx, http.MethodPut, this.createPrefix(folderPath), nil, nil, strings.NewReader(""), 0)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"folderPath": folderPath, "statusCode": response.StatusCode}).Error("S3创建文件夹，响应码失败")
+		return fmt.Errorf("S3创建文件夹，响应码失败: %+v", response.StatusCode)
+	}
+	return nil
+}
+
+func (this *S3Storage) existObject(ctx context.Context, key string) (bool, error) {
+	response, err := this.do(ctx, http.MethodHead, key, nil, nil, nil, -1)
+	if err != nil {
+		return false, err
+	}
+	response.Body.Close()
+	if response.StatusCode == http.StatusOK {
+		return true, nil
+	}
+	if response.StatusCode != http.StatusNotFound {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"key": key, "statusCode": response.StatusCode}).Error("S3查询文件，响应码失败")
+		return false, fmt.Errorf("S3查询文件，响应码失败: %+v", response.StatusCode)
+	}
+	return false, nil
+}
+
+// moveObject 先复制再删除源对象，复制确认成功后才删除；超过单次复制上限的对象分片复制
+func (this *S3Storage) moveObject(ctx context.Context, formPath, toPath string, size int64) error {
+	var err error
+	if size <= s3MaxCopySize {
+		err = this.copyObject(ctx, formPath, toPath)
+	} else {
+		err = this.copyObjectMultipart(ctx, formPath, toPath, size)
+	}
+	if err != nil {
+		return err
+	}
+	return this.removeObject(ctx, this.createKey(formPath))
+}
+
+func (this *S3Storage) copyObject(ctx context.Context, formPath, toPath string) error {
+	header := http.Header{}
+	header.Set("x-amz-copy-source", this.createCopySource(formPath))
+	response, err := this.do(ctx, http.MethodPut, this.createKey(toPath), nil, header, nil, -1)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	err = readS3Result(response, nil)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"formPath": formPath, "toPath": toPath, "err": err}).Error("S3移动文件，复制异常")
+		return fmt.Errorf("S3移动文件，复制异常: %+v", err)
+	}
+	return nil
+}
+
+// copyObjectMultipart 按s3CopyPartSize分片复制，失败时中止分片上传
+func (this *S3Storage) copyObjectMultipart(ctx context.Context, formPath, toPath string, size int64) error {
+	key := this.createKey(toPath)
+	query := url.Values{}
+	query.Set("uploads", "")
+	response, err := this.do(ctx, http.MethodPost, key, query, nil, nil, -1)
+	if err != nil {
+		return err
+	}
+	var initiate s3InitiateMultipartResult
+	err = readS3Result(response, &initiate)
+	response.Body.Close()
+	if err == nil && initiate.UploadId == "" {
+		err = fmt.Errorf("uploadId为空")
+	}
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"formPath": formPath, "toPath": toPath, "err": err}).Error("S3移动文件，创建分片复制异常")
+		return fmt.Errorf("S3移动文件，创建分片复制异常: %+v", err)
+	}
+
+	var complete s3CompleteMultipartUpload
+	for start, number := int64(0), 1; start < size; start, number = start+s3CopyPartSize, number+1 {
+		end := start + s3CopyPartSize - 1
+		if size <= end {
+			end = size - 1
+		}
+		query := url.Values{}
+		query.Set("partNumber", strconv.Itoa(number))
+		query.Set("uploadId", initiate.UploadId)
+		header := http.Header{}
+		header.Set("x-amz-copy-source", this.createCopySource(formPath))
+		header.Set("x-amz-copy-source-range", fmt.Sprintf("bytes=%d-%d", start, end))
+		response, err := this.do(ctx, http.MethodPut, key, query, header, nil, -1)
+		if err != nil {
+			this.abortMultipart(ctx, key, initiate.UploadId)
+			return err
+		}
+		var part s3CopyPartResult
+		err = readS3Result(response, &part)
+		response.Body.Close()
+		if err != nil {
+			this.abortMultipart(ctx, key, initiate.UploadId)
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"formPath": formPath, "toPath": toPath, "number": number, "err": err}).Error("S3移动文件，复制分片异常")
+			return fmt.Errorf("S3移动文件，复制分片异常: %+v", err)
+		}
+		complete.Parts = append(complete.Parts, s3CompletePart{PartNumber: number, ETag: part.ETag})
+	}
+
+	data, err := xml.Marshal(complete)
+	if err != nil {
+		this.abortMultipart(ctx, key, initiate.UploadId)
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("S3移动文件，序列化分片列表异常")
+		return fmt.Errorf("S3移动文件，序列化分片列表异常: %+v", err)
+	}
+	query = url.Values{}
+	query.Set("uploadId", initiate.UploadId)
+	response, err = this.do(ctx, http.MethodPost, key, query, nil, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		this.abortMultipart(ctx, key, initiate.UploadId)
+		return err
+	}
+	defer response.Body.Close()
+	err = readS3Result(response, nil)
+	if err != nil {
+		this.abortMultipart(ctx, key, initiate.UploadId)
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"formPath": formPath, "toPath": toPath, "err": err}).Error("S3移动文件，完成分片复制异常")
+		return fmt.Errorf("S3移动文件，完成分片复制异常: %+v", err)
+	}
+	return nil
+}
+
+func (this *S3Storage) abortMultipart(ctx context.Context, key, uploadId string) {
+	query := url.Values{}
+	query.Set("uploadId", uploadId)
+	response, err := this.do(ctx, http.MethodDelete, key, query, nil, nil, -1)
+	if err != nil {
+		return
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNoContent && response.StatusCode != http.StatusOK {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"key": key, "statusCode": response.StatusCode}).Warn("S3中止分片复制，响应码失败")
+	}
+}
+
+func (this *S3Storage) createCopySource(filePath string) string {
+	return this.escapePath("/" + this.bucket + "/" + this.createKey(filePath))
+}
+
+// readS3Result 复制类请求响应码为200时响应体仍可能是Error，都检查过再把响应体解析到result
+func readS3Result(response *http.Response, result interface{}) error {
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("读取响应异常: %+v", err)
+	}
+	var s3Err s3Error
+	if xml.Unmarshal(data, &s3Err) == nil {
+		return fmt.Errorf("响应码: %+v, 错误: %+v, %+v", response.StatusCode, s3Err.Code, s3Err.Message)
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("响应码失败: %+v", response.StatusCode)
+	}
+	if result == nil {
+		return nil
+	}
+	err = xml.Unmarshal(data, result)
+	if err != nil {
+		return fmt.Errorf("解析响应异常: %+v", err)
+	}
+	return nil
+}
+
+func (this *S3Storage) do(ctx context.Context, method, key string, query url.Values, header http.Header, body io.Reader, contentLength int64) (*http.Response, error) {
+	host, urlPath := this.createHostAndPath(key)
+	requestUrl := strings.SplitN(this.endpoint, "://", 2)[0] + "://" + host + this.escapePath(urlPath)
+	if len(query) > 0 {
+		requestUrl += "?" + this.canonicalQuery(query)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, requestUrl, body)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("S3请求，创建http请求异常")
+		return nil, fmt.Errorf("S3请求，创建http请求异常: %+v", err)
+	}
+	for key := range header {
+		request.Header.Set(key, header.Get(key))
+	}
+	if contentLength >= 0 {
+		request.ContentLength = contentLength
+	}
+	payloadHash := s3EmptySha256
+	if body != nil {
+		payloadHash = s3UnsignedPayload
+	}
+	this.sign(request, host, urlPath, query, payloadHash, time.Now().UTC())
+
+	response, err := this.httpClient.Do(request)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"method": method, "key": key, "err": err}).Error("S3请求，http请求异常")
+		return nil, fmt.Errorf("S3请求，http请求异常: %+v", err)
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"method": method, "key": key, "statusCode": response.StatusCode}).Info("S3请求，响应")
+	return response, nil
+}
+
+// sign AWS Signature Version 4
+func (this *S3Storage) sign(request *http.Request, host, urlPath string, query url.Values, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	request.Host = host
+	request.Header.Set("x-amz-date", amzDate)
+	request.Header.Set("x-amz-content-sha256", payloadHash)
+
+	headers := map[string]string{"host": host}
+	for key := range request.Header {
+		lowerKey := strings.ToLower(key)
+		if strings.HasPrefix(lowerKey, "x-amz-") {
+			headers[lowerKey] = strings.TrimSpace(request.Header.Get(key))
+		}
+	}
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var canonicalHeaders strings.Builder
+	for _, key := range keys {
+		canonicalHeaders.WriteString(key + ":" + headers[key] + "\n")
+	}
+	signedHeaders := strings.Join(keys, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		this.escapePath(urlPath),
+		this.canonicalQuery(query),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + this.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{s3Algorithm, amzDate, scope, this.sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	signingKey := this.hmacSha256([]byte("AWS4"+this.secretKey), date)
+	signingKey = this.hmacSha256(signingKey, this.region)
+	signingKey = this.hmacSha256(signingKey, "s3")
+	signingKey = this.hmacSha256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(this.hmacSha256(signingKey, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s", s3Algorithm, this.accessKey, scope, signedHeaders, signature))
+}
+
+func (this *S3Storage) createHostAndPath(key string) (string, string) {
+	host := strings.SplitN(this.endpoint, "://", 2)[1]
+	if this.pathStyle {
+		return host, "/" + this.bucket + "/" + key
+	}
+	return this.bucket + "." + host, "/" + key
+}
+
+func (this *S3Storage) createKey(filePath string) string {
+	return strings.TrimPrefix(path.Join(this.prefix, filePath), "/")
+}
+
+func (this *S3Storage) createPrefix(folderPath string) string {
+	key := this.createKey(folderPath)
+	if key == "" {
+		return ""
+	}
+	return key + "/"
+}
+
+func (this *S3Storage) canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	list := make([]string, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, this.escape(key)+"="+this.escape(query.Get(key)))
+	}
+	return strings.Join(list, "&")
+}
+
+func (this *S3Storage) escapePath(urlPath string) string {
+	segments := strings.Split(urlPath, "/")
+	for i := range segments {
+		segments[i] = this.escape(segments[i])
+	}
+	return strings.Join(segments, "/")
+}
+
+// escape 按SigV4的要求，除了unreserved字符外全部百分号编码
+func (this *S3Storage) escape(text string) string {
+	var builder strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			builder.WriteByte(c)
+			continue
+		}
+		builder.WriteString("%" + strings.ToUpper(strconv.FormatInt(int64(c)|0x100, 16)[1:]))
+	}
+	return builder.String()
+}
+
+func (this *S3Storage) sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func (this *S3Storage) hmacSha256(key []byte, data string) []byte {
+	hash := hmac.New(sha256.New, key)
+	hash.Write([]byte(data))
+	return hash.Sum(nil)
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+	"path"
+)
+
+// SizeLookup 按底层存储的文件信息查询已知的原始大小，信息过期或未知时返回false
+type SizeLookup func(ctx context.Context, info model.StorageInfo) (int64, bool)
+
+// NewStorage 创建床的存储驱动，本地存储以床的根目录为根，S3以床名为key前缀，暂存文件放在床根目录的临时路径下；
+// sizeLookup供压缩存储获取原始大小，可以为nil
+func NewStorage(ctx context.Context, config model.Config, bucket model.Bucket, sizeLookup SizeLookup) (model.StorageInter, error) {
+	storage, err := newBaseStorage(ctx, config, bucket)
+	if err != nil {
+		return nil, err
+	}
+	//加密在去重之下，去重按明文计算摘要
+	if len(config.EncryptKeys) > 0 {
+		storage, err = NewEncryptStorage(ctx, storage, config.EncryptKeys, bucket.EncryptKeyId, config.EncryptPrefixKeys)
+		if err != nil {
+			return nil, err
+		}
+	}
+	//压缩在加密之上，密文无法压缩
+	if config.CompressEnable {
+		storage, err = NewCompressStorage(ctx, storage, config.CompressMinSize, config.CompressMimes, sizeLookup)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.DedupEnable {
+		return NewDedupStorage(ctx, storage)
+	}
+	return storage, nil
+}
+
+func newBaseStorage(ctx context.Context, config model.Config, bucket model.Bucket) (model.StorageInter, error) {
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"storageType": config.StorageType, "bucket": bucket.Name, "root": bucket.Root}).Info("创建存储驱动")
+	switch config.StorageType {
+	case "", model.StorageLocal:
+		return NewLocalStorage(ctx, bucket.Root, config.SymlinkPolicy)
+	case model.StorageMemory:
+		return NewMemoryStorage(ctx)
+	case model.StorageS3:
+		return NewS3Storage(ctx, config.S3Endpoint, config.S3Region, config.S3Bucket, bucket.Name, config.S3AccessKey, config.S3SecretKey, config.S3PathStyle, path.Join(bucket.Root, model.TmpPath))
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"storageType": config.StorageType}).Error("创建存储驱动，未知存储类型")
+	return nil, fmt.Errorf("创建存储驱动，未知存储类型: %+v", config.StorageType)
+}
+
+// ClearStoragePath 将路径规范为以`/`开头的床内路径，`../`不能越过`/`
+func ClearStoragePath(ctx context.Context, fileOrFolderPath string) string {
+	return util.ClearPath(ctx, path.Join("/", fileOrFolderPath))
+}
@@ -0,0 +1,101 @@
+package test
+
+import (
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/dao/storage"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 只应答移动文件用到的请求，记录收到的请求
+type fakeS3 struct {
+	lock     sync.Mutex
+	size     int64
+	copyBody string
+	requests []string
+}
+
+func (this *fakeS3) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	this.lock.Lock()
+	this.requests = append(this.requests, request.Method+" "+request.URL.Path+" "+request.Header.Get("x-amz-copy-source-range"))
+	this.lock.Unlock()
+	query := request.URL.Query()
+	_, uploads := query["uploads"]
+	switch {
+	case request.Method == http.MethodHead:
+		writer.Header().Set("Content-Length", strconv.FormatInt(this.size, 10))
+		writer.WriteHeader(http.StatusOK)
+	case request.Method == http.MethodPost && uploads:
+		writer.Write([]byte("<InitiateMultipartUploadResult><UploadId>upload</UploadId></InitiateMultipartUploadResult>"))
+	case request.Method == http.MethodPut && query.Get("partNumber") != "":
+		writer.Write([]byte(`<CopyPartResult><ETag>"etag` + query.Get("partNumber") + `"</ETag></CopyPartResult>`))
+	case request.Method == http.MethodPost:
+		writer.Write([]byte("<CompleteMultipartUploadResult><ETag>etag</ETag></CompleteMultipartUploadResult>"))
+	case request.Method == http.MethodPut:
+		writer.Write([]byte(this.copyBody))
+	case request.Method == http.MethodDelete:
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (this *fakeS3) count(prefix string) int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	count := 0
+	for i := range this.requests {
+		if strings.HasPrefix(this.requests[i], prefix) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestS3MoveCopyError(test *testing.T) {
+	ctx := util.GenCtx()
+	handler := &fakeS3{size: 3, copyBody: "<Error><Code>InternalError</Code><Message>copy failed</Message></Error>"}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	object, err := storage.NewS3Storage(ctx, server.URL, "us-east-1", "bucket", "", "ak", "sk", true, test.TempDir())
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	err = object.Move(ctx, "/a.txt", "/b.txt")
+	if err == nil {
+		test.Error("复制响应为Error时应该失败")
+		test.FailNow()
+	}
+	if handler.count(http.MethodDelete) != 0 {
+		test.Error("复制失败时不应该删除源文件")
+		test.FailNow()
+	}
+}
+
+func TestS3MoveMultipart(test *testing.T) {
+	ctx := util.GenCtx()
+	handler := &fakeS3{size: 1024*1024*1024*6 + 1}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	object, err := storage.NewS3Storage(ctx, server.URL, "us-east-1", "bucket", "", "ak", "sk", true, test.TempDir())
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	err = object.Move(ctx, "/a.txt", "/b.txt")
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if handler.count(http.MethodPut+" /bucket/b.txt bytes=") != 13 || handler.count(http.MethodPut+" /bucket/b.txt bytes=6442450944-6442450944") != 1 {
+		test.Errorf("分片复制的分片不正确: %+v", handler.requests)
+		test.FailNow()
+	}
+	if handler.count(http.MethodDelete+" /bucket/a.txt") != 1 {
+		test.Errorf("分片复制完成后应该删除源文件: %+v", handler.requests)
+		test.FailNow()
+	}
+}
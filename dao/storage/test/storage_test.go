@@ -0,0 +1,649 @@
+package test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/dao/storage"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMemoryStorage(test *testing.T) {
+	ctx := util.GenCtx()
+	object, err := storage.NewMemoryStorage(ctx)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	testStorage(ctx, test, object)
+}
+
+func TestLocalStorage(test *testing.T) {
+	ctx := util.GenCtx()
+	object, err := storage.NewLocalStorage(ctx, test.TempDir(), model.SymlinkFollowWithinRoot)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	testStorage(ctx, test, object)
+}
+
+type failReader struct {
+	count int
+}
+
+func (this *failReader) Read(p []byte) (int, error) {
+	if this.count > 0 {
+		return 0, fmt.Errorf("模拟读取中断")
+	}
+	this.count++
+	return copy(p, "half"), nil
+}
+
+func TestLocalStorageAtomicWrite(test *testing.T) {
+	ctx := util.GenCtx()
+	object, err := storage.NewLocalStorage(ctx, test.TempDir(), model.SymlinkFollowWithinRoot)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	data := []byte("old content")
+	_, err = object.Write(ctx, "/text.txt", bytes.NewReader(data))
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	_, err = object.Write(ctx, "/text.txt", &failReader{})
+	if err == nil {
+		test.Error("读取中断时写入应失败")
+		test.FailNow()
+	}
+	reader, err := object.Read(ctx, "/text.txt")
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	readData, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil || !bytes.Equal(data, readData) {
+		test.Error("写入失败时原文件应保持不变", string(readData), err)
+		test.FailNow()
+	}
+}
+
+func testStorage(ctx context.Context, test *testing.T, object model.StorageInter) {
+	data := []byte("hello go_file_bed")
+	written, err := object.Write(ctx, "/aaa/bbb/text.txt", bytes.NewReader(data))
+	if err != nil || written != int64(len(data)) {
+		test.Error(written, err)
+		test.FailNow()
+	}
+
+	info, err := object.Stat(ctx, "/aaa/bbb/text.txt")
+	if err != nil || info == nil || info.IsDir || info.Size != int64(len(data)) || info.Name != "text.txt" {
+		test.Error(info, err)
+		test.FailNow()
+	}
+	info, err = object.Stat(ctx, "/aaa")
+	if err != nil || info == nil || !info.IsDir {
+		test.Error(info, err)
+		test.FailNow()
+	}
+	info, err = object.Stat(ctx, "/not_exist")
+	if err != nil || info != nil {
+		test.Error(info, err)
+		test.FailNow()
+	}
+
+	infos, err := object.List(ctx, "/aaa")
+	if err != nil || len(infos) != 1 || infos[0].Path != "/aaa/bbb" || !infos[0].IsDir {
+		test.Error(infos, err)
+		test.FailNow()
+	}
+
+	reader, err := object.Read(ctx, "/aaa/bbb/text.txt")
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	readData, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil || !bytes.Equal(data, readData) {
+		test.Error(string(readData), err)
+		test.FailNow()
+	}
+
+	err = object.Move(ctx, "/aaa/bbb/text.txt", "/ccc/text.txt")
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	info, err = object.Stat(ctx, "/aaa/bbb")
+	if err != nil || info == nil || !info.IsDir {
+		test.Error("移走文件后源文件夹应保留", info, err)
+		test.FailNow()
+	}
+	info, err = object.Stat(ctx, "/ccc/text.txt")
+	if err != nil || info == nil {
+		test.Error(info, err)
+		test.FailNow()
+	}
+
+	err = object.Remove(ctx, "/ccc/text.txt")
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+
+	err = object.Mkdir(ctx, "/ddd/eee")
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	infos, err = object.List(ctx, "/ddd")
+	if err != nil || len(infos) != 1 || infos[0].Path != "/ddd/eee" || !infos[0].IsDir {
+		test.Error("应能罗列空文件夹", infos, err)
+		test.FailNow()
+	}
+	err = object.Move(ctx, "/ddd/eee", "/fff")
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	info, err = object.Stat(ctx, "/fff")
+	if err != nil || info == nil || !info.IsDir {
+		test.Error("应能移动空文件夹", info, err)
+		test.FailNow()
+	}
+	info, err = object.Stat(ctx, "/ddd")
+	if err != nil || info == nil || !info.IsDir {
+		test.Error("移走子文件夹后父文件夹应保留", info, err)
+		test.FailNow()
+	}
+	info, err = object.Stat(ctx, "/ccc")
+	if err != nil || info == nil || !info.IsDir {
+		test.Error("删除文件后父文件夹应保留", info, err)
+		test.FailNow()
+	}
+	for _, folderPath := range []string{"/fff", "/ddd", "/ccc", "/aaa/bbb", "/aaa"} {
+		err = object.Remove(ctx, folderPath)
+		if err != nil {
+			test.Error(err)
+			test.FailNow()
+		}
+	}
+	//暂存文件清理后临时路径本身保留
+	infos, err = object.List(ctx, "/")
+	if err == nil {
+		infos = excludeStorageInfo(infos, model.TmpPath)
+	}
+	if err != nil || len(infos) != 0 {
+		test.Error(infos, err)
+		test.FailNow()
+	}
+}
+
+func TestDedupStorage(test *testing.T) {
+	ctx := util.GenCtx()
+	local, err := storage.NewLocalStorage(ctx, test.TempDir(), model.SymlinkFollowWithinRoot)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	object, err := storage.NewDedupStorage(ctx, local)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	testStorage(ctx, test, object)
+
+	data := []byte("same content")
+	_, err = object.Write(ctx, "/aaa.txt", bytes.NewReader(data))
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	_, err = object.Write(ctx, "/bbb/aaa.txt", bytes.NewReader(data))
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	blobs, err := local.List(ctx, "/.blob")
+	if err == nil {
+		blobs = excludeStorageInfo(blobs, "/.blob/ref")
+	}
+	if err != nil || len(blobs) != 1 {
+		test.Error("相同内容应只存一份blob", blobs, err)
+		test.FailNow()
+	}
+	info, err := object.Stat(ctx, "/bbb/aaa.txt")
+	if err != nil || info == nil || info.Size != int64(len(data)) {
+		test.Error(info, err)
+		test.FailNow()
+	}
+
+	err = object.Remove(ctx, "/aaa.txt")
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	reader, err := object.Read(ctx, "/bbb/aaa.txt")
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	readData, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil || !bytes.Equal(data, readData) {
+		test.Error("仍有引用时blob不应被删除", string(readData), err)
+		test.FailNow()
+	}
+
+	err = object.Remove(ctx, "/bbb/aaa.txt")
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	info, err = local.Stat(ctx, "/.blob")
+	if err != nil || info != nil {
+		test.Error("最后一个引用删除后blob应被删除", info, err)
+		test.FailNow()
+	}
+
+	//外部放入的与引用同格式的文件不是引用
+	fake := []byte("go_file_bed_blob:" + strings.Repeat("0", 64) + ":12\n")
+	_, err = local.Write(ctx, "/fake.txt", bytes.NewReader(fake))
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	readData = readStorageFile(ctx, test, object, "/fake.txt")
+	if !bytes.Equal(fake, readData) {
+		test.Error("没有登记的文件不应被当作引用", string(readData))
+		test.FailNow()
+	}
+
+	//移动覆盖引用时，被覆盖的blob引用计数减少
+	_, err = object.Write(ctx, "/ccc.txt", bytes.NewReader([]byte("ccc")))
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	_, err = object.Write(ctx, "/ddd.txt", bytes.NewReader([]byte("ddd")))
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	err = object.Move(ctx, "/ccc.txt", "/ddd.txt")
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	readData = readStorageFile(ctx, test, object, "/ddd.txt")
+	if string(readData) != "ccc" {
+		test.Error("移动后应读到源文件内容", string(readData))
+		test.FailNow()
+	}
+	blobs, err = local.List(ctx, "/.blob")
+	if err == nil {
+		blobs = excludeStorageInfo(blobs, "/.blob/ref")
+	}
+	if err != nil || len(blobs) != 1 {
+		test.Error("被覆盖的blob应被删除", blobs, err)
+		test.FailNow()
+	}
+}
+
+func excludeStorageInfo(infos []model.StorageInfo, filePath string) []model.StorageInfo {
+	list := make([]model.StorageInfo, 0, len(infos))
+	for i := range infos {
+		if infos[i].Path != filePath {
+			list = append(list, infos[i])
+		}
+	}
+	return list
+}
+
+func TestLocalStorageSymlink(test *testing.T) {
+	ctx := util.GenCtx()
+	root := test.TempDir()
+	outside := test.TempDir()
+	err := ioutil.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	err = os.MkdirAll(filepath.Join(root, "aaa"), 0755)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	err = ioutil.WriteFile(filepath.Join(root, "aaa", "text.txt"), []byte("text"), 0644)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	//evil指向床外，inner指向床内，chain是指向evil的床内链接
+	links := map[string]string{
+		"evil":  outside,
+		"inner": filepath.Join(root, "aaa"),
+		"chain": filepath.Join(root, "evil"),
+		"up":    "..",
+	}
+	for name, target := range links {
+		err = os.Symlink(target, filepath.Join(root, name))
+		if err != nil {
+			test.Error(err)
+			test.FailNow()
+		}
+	}
+
+	cases := []struct {
+		policy string
+		path   string
+		ok     bool
+	}{
+		{model.SymlinkDeny, "/aaa/text.txt", true},
+		{model.SymlinkDeny, "/inner/text.txt", false},
+		{model.SymlinkDeny, "/evil/secret.txt", false},
+		{model.SymlinkFollowWithinRoot, "/inner/text.txt", true},
+		{model.SymlinkFollowWithinRoot, "/evil/secret.txt", false},
+		{model.SymlinkFollowWithinRoot, "/chain/secret.txt", false},
+		{model.SymlinkFollowWithinRoot, "/up/" + filepath.Base(outside) + "/secret.txt", false},
+		{model.SymlinkFollowWithinRoot, "/../" + filepath.Base(outside) + "/secret.txt", false},
+		{model.SymlinkFollowWithinRoot, "/aaa/../../evil/./secret.txt", false},
+		{model.SymlinkAllow, "/evil/secret.txt", true},
+	}
+	for _, c := range cases {
+		object, err := storage.NewLocalStorage(ctx, root, c.policy)
+		if err != nil {
+			test.Error(err)
+			test.FailNow()
+		}
+		reader, err := object.Read(ctx, c.path)
+		if err == nil {
+			reader.Close()
+		}
+		if (err == nil) != c.ok {
+			test.Error("符号链接策略不符合预期", c.policy, c.path, err)
+		}
+	}
+
+	object, err := storage.NewLocalStorage(ctx, root, model.SymlinkFollowWithinRoot)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	_, err = object.Write(ctx, "/evil/write.txt", bytes.NewReader([]byte("write")))
+	if err == nil {
+		test.Error("不应能通过符号链接写到床外")
+	}
+	if util.GetPathInfo(ctx, filepath.Join(outside, "write.txt")) != nil {
+		test.Error("床外不应出现写入的文件")
+	}
+	infos, err := object.List(ctx, "/")
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	for i := range infos {
+		if infos[i].Name == "evil" || infos[i].Name == "chain" || infos[i].Name == "up" {
+			test.Error("罗列结果不应包含指向床外的符号链接", infos[i])
+		}
+	}
+}
+
+func TestEncryptStorage(test *testing.T) {
+	ctx := util.GenCtx()
+	memory, err := storage.NewMemoryStorage(ctx)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	keys := []model.EncryptKey{
+		{Id: "old", Key: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))},
+		{Id: "new", Key: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))},
+		{Id: "scan", Key: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{3}, 16))},
+	}
+	object, err := storage.NewEncryptStorage(ctx, memory, keys, "old", []model.EncryptPrefixKey{{Path: "/scan", KeyId: "scan"}})
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	testStorage(ctx, test, object)
+
+	_, err = memory.Write(ctx, "/plain.txt", strings.NewReader("plain"))
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	readData := readStorageFile(ctx, test, object, "/plain.txt")
+	if string(readData) != "plain" {
+		test.Error("加密开启前的明文文件应原样读取", string(readData))
+		test.FailNow()
+	}
+
+	for _, size := range []int{0, 1, 64 * 1024, 64*1024 + 1, 200 * 1024} {
+		data := bytes.Repeat([]byte("x"), size)
+		filePath := fmt.Sprintf("/size/%d.txt", size)
+		written, err := object.Write(ctx, filePath, bytes.NewReader(data))
+		if err != nil || written != int64(size) {
+			test.Error(written, err)
+			test.FailNow()
+		}
+		raw := readStorageFile(ctx, test, memory, filePath)
+		if size >= 16 && bytes.Contains(raw, data) {
+			test.Error("存储里不应是明文", size)
+			test.FailNow()
+		}
+		info, err := object.Stat(ctx, filePath)
+		if err != nil || info == nil || info.Size != int64(size) {
+			test.Error("大小应为明文大小", size, info, err)
+			test.FailNow()
+		}
+		readData = readStorageFile(ctx, test, object, filePath)
+		if !bytes.Equal(data, readData) {
+			test.Error("解密后内容不一致", size)
+			test.FailNow()
+		}
+	}
+	infos, err := object.List(ctx, "/size")
+	if err != nil || len(infos) != 5 {
+		test.Error(infos, err)
+		test.FailNow()
+	}
+	for i := range infos {
+		raw := readStorageFile(ctx, test, memory, infos[i].Path)
+		if infos[i].Size != int64(len(raw)) {
+			test.Error("罗列不读取头部，大小应为密文大小", infos[i])
+			test.FailNow()
+		}
+	}
+
+	raw := readStorageFile(ctx, test, memory, "/size/1.txt")
+	raw[len(raw)-1] ^= 0xff
+	_, err = memory.Write(ctx, "/size/1.txt", bytes.NewReader(raw))
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	reader, err := object.Read(ctx, "/size/1.txt")
+	if err == nil {
+		_, err = ioutil.ReadAll(reader)
+		reader.Close()
+	}
+	if err == nil {
+		test.Error("篡改的密文应解密失败")
+		test.FailNow()
+	}
+
+	_, err = object.Write(ctx, "/scan/a.txt", strings.NewReader("secret"))
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	err = object.Move(ctx, "/scan/a.txt", "/a.txt")
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	rotated, err := storage.NewEncryptStorage(ctx, memory, keys, "new", nil)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	reencrypted, err := rotated.Reencrypt(ctx, "/a.txt")
+	if err != nil || !reencrypted {
+		test.Error("换了默认密钥应重新加密", reencrypted, err)
+		test.FailNow()
+	}
+	reencrypted, err = rotated.Reencrypt(ctx, "/a.txt")
+	if err != nil || reencrypted {
+		test.Error("密钥已是最新时不应重新加密", reencrypted, err)
+		test.FailNow()
+	}
+	withoutOld, err := storage.NewEncryptStorage(ctx, memory, keys[1:], "new", nil)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	readData = readStorageFile(ctx, test, withoutOld, "/a.txt")
+	if string(readData) != "secret" {
+		test.Error("重新加密后应能用新密钥解密", string(readData))
+		test.FailNow()
+	}
+}
+
+func TestCompressStorage(test *testing.T) {
+	ctx := util.GenCtx()
+	memory, err := storage.NewMemoryStorage(ctx)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	object, err := storage.NewCompressStorage(ctx, memory, 16, []string{"text/"}, nil)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	testStorage(ctx, test, object)
+
+	data := bytes.Repeat([]byte("compress "), 1024)
+	written, err := object.Write(ctx, "/text.txt", bytes.NewReader(data))
+	if err != nil || written != int64(len(data)) {
+		test.Error(written, err)
+		test.FailNow()
+	}
+	info, err := object.Stat(ctx, "/text.txt")
+	if err != nil || info == nil || info.Size != int64(len(data)) || info.StoredSize <= 0 || info.StoredSize >= info.Size {
+		test.Error("大小应为原始大小，存储大小应更小", info, err)
+		test.FailNow()
+	}
+	tmpInfo, err := memory.Stat(ctx, model.TmpPath+"/gzip")
+	if err != nil || tmpInfo != nil {
+		test.Error("压缩完成后应清理暂存文件", tmpInfo, err)
+		test.FailNow()
+	}
+	//能查到原始大小时Stat不读取头部
+	lookup, err := storage.NewCompressStorage(ctx, memory, 16, []string{"text/"}, func(ctx context.Context, storedInfo model.StorageInfo) (int64, bool) {
+		return 1, storedInfo.Path == "/text.txt" && storedInfo.Size == info.StoredSize
+	})
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	lookupInfo, err := lookup.Stat(ctx, "/text.txt")
+	if err != nil || lookupInfo == nil || lookupInfo.Size != 1 || lookupInfo.StoredSize != info.StoredSize {
+		test.Error("应使用查到的原始大小", lookupInfo, err)
+		test.FailNow()
+	}
+	infos, err := object.List(ctx, "/")
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	for i := range infos {
+		if infos[i].Path == "/text.txt" && infos[i].Size != info.StoredSize {
+			test.Error("罗列不读取头部，大小应为压缩后的大小", infos[i])
+			test.FailNow()
+		}
+	}
+	readData := readStorageFile(ctx, test, object, "/text.txt")
+	if !bytes.Equal(data, readData) {
+		test.Error("解压后内容不一致")
+		test.FailNow()
+	}
+	reader, err := object.ReadGzip(ctx, "/text.txt")
+	if err != nil || reader == nil {
+		test.Error("应能读取gzip数据", err)
+		test.FailNow()
+	}
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	readData, err = ioutil.ReadAll(gzipReader)
+	reader.Close()
+	if err != nil || !bytes.Equal(data, readData) {
+		test.Error("gzip数据应能被标准gzip解压", err)
+		test.FailNow()
+	}
+
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	gzipWriter.Write(data)
+	gzipWriter.Close()
+	gzipData := buffer.Bytes()
+	_, err = object.Write(ctx, "/text.txt.gz", bytes.NewReader(gzipData))
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	readData = readStorageFile(ctx, test, object, "/text.txt.gz")
+	if !bytes.Equal(gzipData, readData) {
+		test.Error("用户上传的gzip文件应原样读取")
+		test.FailNow()
+	}
+	reader, err = object.ReadGzip(ctx, "/text.txt.gz")
+	if err != nil || reader != nil {
+		test.Error("用户上传的gzip文件不应透传", err)
+		test.FailNow()
+	}
+
+	binaryData := bytes.Repeat([]byte{0, 1, 2, 3}, 1024)
+	for filePath, fileData := range map[string][]byte{"/small.txt": []byte("small"), "/binary.bin": binaryData} {
+		_, err = object.Write(ctx, filePath, bytes.NewReader(fileData))
+		if err != nil {
+			test.Error(err)
+			test.FailNow()
+		}
+		if !bytes.Equal(fileData, readStorageFile(ctx, test, memory, filePath)) {
+			test.Error("过小或不可压缩的文件应原样存储", filePath)
+			test.FailNow()
+		}
+	}
+}
+
+func readStorageFile(ctx context.Context, test *testing.T, object model.StorageInter, filePath string) []byte {
+	reader, err := object.Read(ctx, filePath)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	return data
+}
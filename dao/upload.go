@@ -0,0 +1,199 @@
+package dao
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	uploadInfoName   = "info.json"
+	uploadPartPrefix = "part_"
+)
+
+// InsertUpload 保存上传任务信息
+func InsertUpload(ctx context.Context, upload model.Upload) error {
+	uploadPath, err := createUploadPath(ctx, upload.Id)
+	if err != nil {
+		return err
+	}
+	_, err = getFileStorage(ctx).Write(ctx, path.Join(uploadPath, uploadInfoName), bytes.NewReader(util.ToJson(upload)))
+	return err
+}
+
+// SelectUpload 上传任务不存在时返回nil
+func SelectUpload(ctx context.Context, id string) (*model.Upload, error) {
+	uploadPath, err := createUploadPath(ctx, id)
+	if err != nil {
+		return nil, nil
+	}
+	infoPath := path.Join(uploadPath, uploadInfoName)
+	info, err := getFileStorage(ctx).Stat(ctx, infoPath)
+	if info == nil || err != nil {
+		return nil, err
+	}
+	reader, err := getFileStorage(ctx).Read(ctx, infoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"id": id, "err": err}).Error("查询上传任务，读取异常")
+		return nil, fmt.Errorf("查询上传任务，读取异常: %+v", err)
+	}
+	var upload model.Upload
+	err = util.UnmarshalJson(data, &upload)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"id": id, "err": err}).Error("查询上传任务，反序列化异常")
+		return nil, fmt.Errorf("查询上传任务，反序列化异常: %+v", err)
+	}
+	return &upload, nil
+}
+
+func ListUpload(ctx context.Context) ([]model.Upload, error) {
+	info, err := getFileStorage(ctx).Stat(ctx, model.UploadPath)
+	if info == nil || err != nil {
+		return nil, err
+	}
+	infos, err := getFileStorage(ctx).List(ctx, model.UploadPath)
+	if err != nil {
+		return nil, err
+	}
+	uploads := make([]model.Upload, 0, len(infos))
+	for i := range infos {
+		upload, err := SelectUpload(ctx, infos[i].Name)
+		if err != nil {
+			return nil, err
+		}
+		if upload == nil {
+			//只有分片没有任务信息，按已过期处理
+			upload = &model.Upload{Id: infos[i].Name, ExpireTime: infos[i].ModTime}
+		}
+		uploads = append(uploads, *upload)
+	}
+	return uploads, nil
+}
+
+func DeleteUpload(ctx context.Context, id string) error {
+	uploadPath, err := createUploadPath(ctx, id)
+	if err != nil {
+		return err
+	}
+	return deleteAll(ctx, uploadPath)
+}
+
+// InsertUploadPart 写入一个分片，同名分片直接覆盖，返回写入的字节数
+func InsertUploadPart(ctx context.Context, id string, name string, reader io.Reader) (int64, error) {
+	uploadPath, err := createUploadPath(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	reader = util.NewTimeoutReader(reader, config.Config.Timeout)
+	return getFileStorage(ctx).Write(ctx, path.Join(uploadPath, uploadPartPrefix+name), reader)
+}
+
+// ListUploadPart 罗列上传任务的全部分片，按分片名称排序，Name为写入时的分片名称
+func ListUploadPart(ctx context.Context, id string) ([]model.StorageInfo, error) {
+	uploadPath, err := createUploadPath(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := getFileStorage(ctx).List(ctx, uploadPath)
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]model.StorageInfo, 0, len(infos))
+	for i := range infos {
+		if infos[i].IsDir || !strings.HasPrefix(infos[i].Name, uploadPartPrefix) {
+			continue
+		}
+		part := infos[i]
+		part.Name = strings.TrimPrefix(part.Name, uploadPartPrefix)
+		parts = append(parts, part)
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].Name < parts[j].Name
+	})
+	return parts, nil
+}
+
+func DeleteUploadPart(ctx context.Context, id string, name string) error {
+	uploadPath, err := createUploadPath(ctx, id)
+	if err != nil {
+		return err
+	}
+	return getFileStorage(ctx).Remove(ctx, path.Join(uploadPath, uploadPartPrefix+name))
+}
+
+// GetUploadReader 按names的顺序拼接分片
+func GetUploadReader(ctx context.Context, id string, names []string) (io.ReadCloser, error) {
+	uploadPath, err := createUploadPath(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	partPaths := make([]string, 0, len(names))
+	for i := range names {
+		partPaths = append(partPaths, path.Join(uploadPath, uploadPartPrefix+names[i]))
+	}
+	return &uploadReader{ctx: ctx, partPaths: partPaths}, nil
+}
+
+func createUploadPath(ctx context.Context, id string) (string, error) {
+	if id == "" || strings.Trim(id, "0123456789") != "" {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"id": id}).Warn("上传任务，非法ID")
+		return "", fmt.Errorf("上传任务，非法ID")
+	}
+	return path.Join(model.UploadPath, id), nil
+}
+
+// uploadReader 读到哪个分片才打开哪个分片
+type uploadReader struct {
+	ctx       context.Context
+	partPaths []string
+	reader    io.ReadCloser
+}
+
+func (this *uploadReader) Read(p []byte) (int, error) {
+	for {
+		if this.reader == nil {
+			if len(this.partPaths) == 0 {
+				return 0, io.EOF
+			}
+			reader, err := getFileStorage(this.ctx).Read(this.ctx, this.partPaths[0])
+			if err != nil {
+				return 0, err
+			}
+			this.partPaths = this.partPaths[1:]
+			this.reader = reader
+		}
+		n, err := this.reader.Read(p)
+		if err == io.EOF {
+			this.reader.Close()
+			this.reader = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (this *uploadReader) Close() error {
+	if this.reader == nil {
+		return nil
+	}
+	err := this.reader.Close()
+	this.reader = nil
+	return err
+}
@@ -0,0 +1,189 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// WatchFile 监听本地床文件夹的外部变更（如rsync、scp直接写入），更新索引后回调handle
+func WatchFile(ctx context.Context, handle func(ctx context.Context, event model.FileEvent)) error {
+	if config.Config.StorageType != model.StorageLocal {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"storageType": config.Config.StorageType}).Error("监听文件夹，只支持本地存储")
+		return fmt.Errorf("监听文件夹，只支持本地存储")
+	}
+	bucket := config.GetBucket(ctx)
+	root, err := filepath.Abs(bucket.Root)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("监听文件夹，获取绝对路径异常")
+		return fmt.Errorf("监听文件夹，获取绝对路径异常: %+v", err)
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("监听文件夹，创建监听异常")
+		return fmt.Errorf("监听文件夹，创建监听异常: %+v", err)
+	}
+	err = addWatchFolder(ctx, watcher, root, "/")
+	if err != nil {
+		watcher.Close()
+		return err
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"bucket": bucket.Name, "root": root}).Info("监听文件夹，开始")
+	go runWatch(watcher, bucket.Name, root, handle)
+	return nil
+}
+
+func addWatchFolder(ctx context.Context, watcher *fsnotify.Watcher, root, folderPath string) error {
+	if model.IsReservedPath(folderPath) {
+		return nil
+	}
+	err := watcher.Add(filepath.Join(root, filepath.FromSlash(folderPath)))
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"folderPath": folderPath, "err": err}).Error("监听文件夹，添加监听异常")
+		return fmt.Errorf("监听文件夹，添加监听异常: %+v", err)
+	}
+	infos, err := getFileStorage(ctx).List(ctx, folderPath)
+	if err != nil {
+		return err
+	}
+	for i := range infos {
+		if !infos[i].IsDir {
+			continue
+		}
+		err = addWatchFolder(ctx, watcher, root, infos[i].Path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runWatch 事件先攒起来，安静WatchDelay后再统一处理，rsync的临时文件与接口写入的索引此时都已落定
+func runWatch(watcher *fsnotify.Watcher, bucket, root string, handle func(ctx context.Context, event model.FileEvent)) {
+	defer watcher.Close()
+	pending := make(map[string]bool)
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			ctx := config.SetBucket(util.GenCtx(), bucket)
+			relPath, err := filepath.Rel(root, event.Name)
+			if err != nil {
+				continue
+			}
+			bedPath := createBedPath(ctx, filepath.ToSlash(relPath))
+			if bedPath == "/" || model.IsReservedPath(bedPath) {
+				continue
+			}
+			if event.Op&fsnotify.Create == fsnotify.Create {
+				info, err := os.Stat(event.Name)
+				if err == nil && info.IsDir() {
+					addWatchFolder(ctx, watcher, root, bedPath)
+				}
+			}
+			pending[bedPath] = true
+			timer.Reset(config.Config.WatchDelay)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			logrus.WithContext(util.GenCtx()).WithFields(logrus.Fields{"err": err}).Warn("监听文件夹，异常")
+		case <-timer.C:
+			ctx := config.SetBucket(util.GenCtx(), bucket)
+			for bedPath := range pending {
+				handleWatchPath(ctx, bedPath, handle)
+			}
+			pending = make(map[string]bool)
+		}
+	}
+}
+
+func handleWatchPath(ctx context.Context, bedPath string, handle func(ctx context.Context, event model.FileEvent)) {
+	info, err := getFileStorage(ctx).Stat(ctx, bedPath)
+	if err != nil {
+		return
+	}
+	if info == nil {
+		handleWatchRemove(ctx, bedPath, handle)
+		return
+	}
+	if info.IsDir {
+		walkStorage(ctx, bedPath, func(info model.StorageInfo) error {
+			handleWatchFile(ctx, info, handle)
+			return nil
+		})
+		return
+	}
+	handleWatchFile(ctx, *info, handle)
+}
+
+func handleWatchRemove(ctx context.Context, bedPath string, handle func(ctx context.Context, event model.FileEvent)) {
+	indexes, err := listFileIndex(ctx, bedPath)
+	if err != nil {
+		return
+	}
+	index, err := selectFileIndex(ctx, bedPath)
+	if err != nil {
+		return
+	}
+	if index != nil {
+		indexes = append(indexes, *index)
+	}
+	if len(indexes) == 0 {
+		//接口删除的文件，索引已经删除
+		return
+	}
+	err = deleteFileIndex(ctx, bedPath)
+	if err != nil {
+		return
+	}
+	for i := range indexes {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"bedPath": indexes[i].Path}).Info("监听文件夹，外部删除文件")
+		handle(ctx, model.FileEvent{Type: model.FileEventRemove, Path: indexes[i].Path, Source: model.FileEventSourceWatch})
+	}
+}
+
+func handleWatchFile(ctx context.Context, info model.StorageInfo, handle func(ctx context.Context, event model.FileEvent)) {
+	index, err := selectFileIndex(ctx, info.Path)
+	if err != nil {
+		return
+	}
+	if isFileIndexFresh(index, info) {
+		//接口写入或移动的文件，索引已经是最新的
+		return
+	}
+	oldIndex := index
+	if info.Size <= config.Config.MaxHashLimit {
+		index, err = createFileIndex(ctx, info)
+	} else {
+		//超过摘要上限时只更新大小与修改时间，List得到的可能是存储后的大小，重新Stat取文件大小
+		var pathInfo *model.StorageInfo
+		pathInfo, err = getFileStorage(ctx).Stat(ctx, info.Path)
+		if pathInfo == nil || err != nil {
+			return
+		}
+		index, err = createSizeFileIndex(ctx, *pathInfo)
+	}
+	if err != nil {
+		return
+	}
+	if oldIndex != nil {
+		//外部改写内容时保留元数据
+		index.Uploader = oldIndex.Uploader
+		index.FileMeta = oldIndex.FileMeta
+	}
+	insertFileIndex(ctx, *index)
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"bedPath": info.Path}).Info("监听文件夹，外部写入文件")
+	handle(ctx, model.FileEvent{Type: model.FileEventAdd, Path: info.Path, Source: model.FileEventSourceWatch})
+}
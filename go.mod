@@ -6,10 +6,13 @@ require (
 	github.com/cellargalaxy/go_common v0.0.0-20220805165827-2422a7f81793
 	github.com/cellargalaxy/server_center v0.0.0-20220806035558-60e42d5d3fe7
 	github.com/disintegration/imaging v1.6.2
+	github.com/fsnotify/fsnotify v1.5.4
 	github.com/gin-contrib/pprof v1.4.0
 	github.com/gin-gonic/gin v1.8.1
 	github.com/go-resty/resty/v2 v2.7.0
+	github.com/natefinch/lumberjack v2.0.0+incompatible
 	github.com/robfig/cron/v3 v3.0.1
 	github.com/sirupsen/logrus v1.9.0
+	go.etcd.io/bbolt v1.3.6
 	golang.org/x/image v0.0.0-20220722155232-062f8c9fd539 // indirect
 )
@@ -5,12 +5,14 @@ import (
 	"github.com/cellargalaxy/go_file_bed/controller"
 	"github.com/cellargalaxy/go_file_bed/corn"
 	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/cellargalaxy/go_file_bed/service"
 )
 
 func init() {
 	ctx := util.GenCtx()
 	util.Init(model.DefaultServerName)
 	corn.Init(ctx)
+	service.InitWatch(ctx)
 }
 
 /**
@@ -0,0 +1,74 @@
+package model
+
+import (
+	"github.com/cellargalaxy/go_common/util"
+	"time"
+)
+
+const (
+	// ClientIpKey ctx里的客户端IP
+	ClientIpKey = "client_ip"
+)
+
+const (
+	AuditAddFile            = "add_file"
+	AuditAddUrl             = "add_url"
+	AuditRemoveFile         = "remove_file"
+	AuditAddFolder          = "add_folder"
+	AuditRemoveFolder       = "remove_folder"
+	AuditMoveFile           = "move_file"
+	AuditCopyFile           = "copy_file"
+	AuditSetFileMeta        = "set_file_meta"
+	AuditRestoreFileVersion = "restore_file_version"
+	AuditPushSync           = "push_sync"
+	AuditPullSync           = "pull_sync"
+	AuditClearTrash         = "clear_trash"
+)
+
+// AuditRecord 一次修改操作的审计记录，Id按写入顺序递增；
+// Size与Hash为操作后的文件，删除时为被删除的文件，Hash为sha256，没有索引时为空
+type AuditRecord struct {
+	Id        int64     `json:"id"`
+	Time      time.Time `json:"time"`
+	LogId     int64     `json:"log_id"`
+	Ip        string    `json:"ip,omitempty"`
+	Identity  string    `json:"identity,omitempty"`
+	Bucket    string    `json:"bucket,omitempty"`
+	Operation string    `json:"operation"`
+	Path      string    `json:"path"`
+	FromPath  string    `json:"from_path,omitempty"`
+	Overwrite bool      `json:"overwrite,omitempty"`
+	Size      int64     `json:"size,omitempty"`
+	Hash      string    `json:"hash,omitempty"`
+}
+
+func (this AuditRecord) String() string {
+	return util.ToJsonString(this)
+}
+
+// AuditSearchRequest 按操作时间倒序分页，时间为unix秒，为0时不限制；Limit小于等于0时取默认值
+type AuditSearchRequest struct {
+	StartTime int64  `json:"start_time" form:"start_time" query:"start_time"`
+	EndTime   int64  `json:"end_time" form:"end_time" query:"end_time"`
+	Operation string `json:"operation" form:"operation" query:"operation"`
+	Prefix    string `json:"prefix" form:"prefix" query:"prefix"`
+	Identity  string `json:"identity" form:"identity" query:"identity"`
+	Ip        string `json:"ip" form:"ip" query:"ip"`
+	LogId     int64  `json:"log_id" form:"log_id" query:"log_id"`
+	Cursor    string `json:"cursor" form:"cursor" query:"cursor"`
+	Limit     int    `json:"limit" form:"limit" query:"limit"`
+}
+
+func (this AuditSearchRequest) String() string {
+	return util.ToJsonString(this)
+}
+
+// AuditSearchResponse NextCursor为空时没有下一页
+type AuditSearchResponse struct {
+	Records    []AuditRecord `json:"records"`
+	NextCursor string        `json:"next_cursor"`
+}
+
+func (this AuditSearchResponse) String() string {
+	return util.ToJsonString(this)
+}
@@ -0,0 +1,46 @@
+package model
+
+import (
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/disintegration/imaging"
+	"path"
+	"time"
+)
+
+const (
+	// BucketKey 请求参数与ctx里的床名，为空时是默认床
+	BucketKey  = "bucket"
+	BucketPath = "bucket"
+)
+
+// Bucket 命名床，各自有独立的根目录、密钥、回收站与图片压缩配置
+type Bucket struct {
+	Name   string `yaml:"name" json:"name"`
+	Root   string `yaml:"root" json:"root"`
+	Secret string `yaml:"secret" json:"-"`
+
+	TrashEnable   bool          `yaml:"trash_enable" json:"trash_enable"`
+	TrashSaveTime time.Duration `yaml:"trash_save_time" json:"trash_save_time"`
+
+	ImageTargetSize float64        `yaml:"image_target_size" json:"image_target_size"`
+	JpegMinQuality  float64        `yaml:"jpeg_min_quality" json:"jpeg_min_quality"`
+	JpegMaxQuality  float64        `yaml:"jpeg_max_quality" json:"jpeg_max_quality"`
+	ImageSaveFormat imaging.Format `yaml:"image_save_format" json:"image_save_format"`
+
+	EncryptKeyId string `yaml:"encrypt_key_id" json:"encrypt_key_id"`
+
+	//Quotas 只作用于本床，命名床不沿用默认床的配额
+	Quotas []Quota `yaml:"quotas" json:"quotas"`
+}
+
+func (this Bucket) String() string {
+	return util.ToJsonString(this)
+}
+
+// CreateBucketFileUrl 默认床的文件链接为`/file/...`，命名床为`/bucket/<床名>/...`，两者互不遮挡
+func CreateBucketFileUrl(bucket, filePath string) string {
+	if bucket == "" {
+		return path.Join(FileUrl, "/", filePath)
+	}
+	return path.Join(BucketFileUrl, bucket, "/", filePath)
+}
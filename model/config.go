@@ -3,6 +3,7 @@ package model
 import (
 	"github.com/cellargalaxy/go_common/util"
 	"github.com/disintegration/imaging"
+	"strings"
 	"time"
 )
 
@@ -11,8 +12,16 @@ const (
 	ListenAddress     = ":8880"
 	FileBedPath       = "file_bed"
 	TrashPath         = "/.trash"
+	TmpPath           = "/.tmp"
+	FileIndexPath     = "/.index.db"
+	VersionPath       = "/.version"
+	BlobPath          = "/.blob"
+	UploadPath        = "/.upload"
+	HistoryPath       = "/.history.log"
 
-	FileUrl = "/file"
+	FileUrl       = "/file"
+	BucketFileUrl = "/bucket"
+	FileV2Url     = "/api/v2/files"
 
 	AddUrlUrl              = "/api/addUrl"
 	AddFileUrl             = "/api/addFile"
@@ -22,8 +31,50 @@ const (
 	ListLastFileInfoUrl    = "/api/listLastFileInfo"
 	PushSyncFileUrl        = "/api/pushSyncFile"
 	PullSyncFileUrl        = "/api/pullSyncFile"
+	RebuildFileIndexUrl    = "/api/rebuildFileIndex"
+	MoveFileUrl            = "/api/moveFile"
+	CopyFileUrl            = "/api/copyFile"
+	AddFolderUrl           = "/api/addFolder"
+	RemoveFolderUrl        = "/api/removeFolder"
+	ListFileVersionUrl     = "/api/listFileVersion"
+	GetFileVersionUrl      = "/api/getFileVersion"
+	RestoreFileVersionUrl  = "/api/restoreFileVersion"
+	ListQuotaUsageUrl      = "/api/listQuotaUsage"
+	GetScrubReportUrl      = "/api/getScrubReport"
+	ReencryptFileUrl       = "/api/reencryptFile"
+	TusUploadUrl           = "/api/tus"
+	CreateMultipartUrl     = "/api/createMultipartUpload"
+	UploadMultipartPartUrl = "/api/uploadMultipartPart"
+	CompleteMultipartUrl   = "/api/completeMultipartUpload"
+	AbortMultipartUrl      = "/api/abortMultipartUpload"
+	ManifestUrl            = "/api/manifest"
+	SearchFileUrl          = "/api/searchFile"
+	SetFileMetaUrl         = "/api/setFileMeta"
+	ListFileHistoryUrl     = "/api/listFileHistory"
+	SearchAuditUrl         = "/api/searchAudit"
 )
 
+// ReservedPaths 内部使用的路径，不对外罗列，也不允许通过接口写入
+var ReservedPaths = []string{TmpPath, FileIndexPath, VersionPath, BlobPath, UploadPath, HistoryPath}
+
+// IsReservedPath 路径为保留路径或在保留路径下
+func IsReservedPath(filePath string) bool {
+	for i := range ReservedPaths {
+		if IsSubPath(ReservedPaths[i], filePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSubPath filePath为parentPath本身或在parentPath下，parentPath为根目录时总是成立
+func IsSubPath(parentPath, filePath string) bool {
+	if parentPath == "/" {
+		return true
+	}
+	return filePath == parentPath || strings.HasPrefix(filePath, parentPath+"/")
+}
+
 type Config struct {
 	Retry   int           `yaml:"retry" json:"retry"`
 	Timeout time.Duration `yaml:"timeout" json:"timeout"`
@@ -37,6 +88,25 @@ type Config struct {
 	TrashSaveTime  time.Duration `yaml:"trash_save_time" json:"trash_save_time"`
 	TrashClearCron string        `yaml:"trash_clear_cron" json:"trash_clear_cron"`
 
+	VersionMaxCount  int           `yaml:"version_max_count" json:"version_max_count"`
+	VersionSaveTime  time.Duration `yaml:"version_save_time" json:"version_save_time"`
+	VersionClearCron string        `yaml:"version_clear_cron" json:"version_clear_cron"`
+
+	Quotas []Quota `yaml:"quotas" json:"quotas"` //默认床的配额，命名床在床配置里单独配置
+
+	UploadMaxSize    int64         `yaml:"upload_max_size" json:"upload_max_size"`
+	UploadExpireTime time.Duration `yaml:"upload_expire_time" json:"upload_expire_time"`
+	UploadClearCron  string        `yaml:"upload_clear_cron" json:"upload_clear_cron"`
+
+	ScrubCron      string `yaml:"scrub_cron" json:"scrub_cron"`
+	ScrubRateLimit int64  `yaml:"scrub_rate_limit" json:"scrub_rate_limit"`
+	ScrubRepair    bool   `yaml:"scrub_repair" json:"scrub_repair"`
+
+	Buckets []Bucket `yaml:"buckets" json:"buckets"`
+
+	WatchEnable bool          `yaml:"watch_enable" json:"watch_enable"`
+	WatchDelay  time.Duration `yaml:"watch_delay" json:"watch_delay"`
+
 	ImageTargetSize float64        `yaml:"image_target_size" json:"image_target_size"`
 	JpegMinQuality  float64        `yaml:"jpeg_min_quality" json:"jpeg_min_quality"`
 	JpegMaxQuality  float64        `yaml:"jpeg_max_quality" json:"jpeg_max_quality"`
@@ -48,8 +118,38 @@ type Config struct {
 	PushSyncCron   string `yaml:"push_sync_cron" json:"push_sync_cron"`
 	PushSyncHost   string `yaml:"push_sync_host" json:"push_sync_host"`
 	PushSyncSecret string `yaml:"push_sync_secret" json:"-"`
+	SyncHashType   string `yaml:"sync_hash_type" json:"sync_hash_type"`
+	SyncLargeSize  int64  `yaml:"sync_large_size" json:"sync_large_size"`
+
+	StorageType   string `yaml:"storage_type" json:"storage_type"`
+	SymlinkPolicy string `yaml:"symlink_policy" json:"symlink_policy"`
+	S3Endpoint    string `yaml:"s3_endpoint" json:"s3_endpoint"`
+	S3Region      string `yaml:"s3_region" json:"s3_region"`
+	S3Bucket      string `yaml:"s3_bucket" json:"s3_bucket"`
+	S3AccessKey   string `yaml:"s3_access_key" json:"s3_access_key"`
+	S3SecretKey   string `yaml:"s3_secret_key" json:"-"`
+	S3PathStyle   bool   `yaml:"s3_path_style" json:"s3_path_style"`
+	DedupEnable   bool   `yaml:"dedup_enable" json:"dedup_enable"`
+
+	EncryptKeys       []EncryptKey       `yaml:"encrypt_keys" json:"encrypt_keys"`
+	EncryptKeyId      string             `yaml:"encrypt_key_id" json:"encrypt_key_id"`
+	EncryptPrefixKeys []EncryptPrefixKey `yaml:"encrypt_prefix_keys" json:"encrypt_prefix_keys"`
+
+	CompressEnable  bool     `yaml:"compress_enable" json:"compress_enable"`
+	CompressMinSize int64    `yaml:"compress_min_size" json:"compress_min_size"`
+	CompressMimes   []string `yaml:"compress_mimes" json:"compress_mimes"`
+
+	AuditPath       string `yaml:"audit_path" json:"audit_path"`         //审计日志，滚动出的日志与它在同一目录
+	AuditMaxSize    int    `yaml:"audit_max_size" json:"audit_max_size"` //单个审计日志的大小，单位M
+	AuditMaxBackups int    `yaml:"audit_max_backups" json:"audit_max_backups"`
+	AuditMaxAge     int    `yaml:"audit_max_age" json:"audit_max_age"` //滚动出的审计日志保留天数，为0时不按时间清理
 }
 
 func (this Config) String() string {
 	return util.ToJsonString(this)
 }
+
+// VersionEnable 配置了保留个数或保留时长时，覆盖写入会保留历史版本
+func (this Config) VersionEnable() bool {
+	return this.VersionMaxCount > 0 || this.VersionSaveTime > 0
+}
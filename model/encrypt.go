@@ -0,0 +1,45 @@
+package model
+
+import (
+	"context"
+	"github.com/cellargalaxy/go_common/util"
+)
+
+// EncryptKey 加密密钥，Key为base64编码的16、24或32字节AES密钥，Id写入密文头部用于解密时选择密钥
+type EncryptKey struct {
+	Id  string `yaml:"id" json:"id"`
+	Key string `yaml:"key" json:"-"`
+}
+
+func (this EncryptKey) String() string {
+	return util.ToJsonString(this)
+}
+
+// EncryptPrefixKey 路径前缀下的文件使用指定密钥加密
+type EncryptPrefixKey struct {
+	Path  string `yaml:"path" json:"path"`
+	KeyId string `yaml:"key_id" json:"key_id"`
+}
+
+func (this EncryptPrefixKey) String() string {
+	return util.ToJsonString(this)
+}
+
+// StorageReencryptInter 支持重新加密的存储驱动，返回文件是否被重新加密
+type StorageReencryptInter interface {
+	Reencrypt(ctx context.Context, filePath string) (bool, error)
+}
+
+type FileReencryptRequest struct {
+}
+
+func (this FileReencryptRequest) String() string {
+	return util.ToJsonString(this)
+}
+
+type FileReencryptResponse struct {
+}
+
+func (this FileReencryptResponse) String() string {
+	return util.ToJsonString(this)
+}
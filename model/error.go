@@ -0,0 +1,16 @@
+package model
+
+import "errors"
+
+// 服务层错误的类别，用errors.Is判断，接口据此返回对应的响应码
+var (
+	ErrInvalidPath   = errors.New("路径非法")
+	ErrReservedPath  = errors.New("路径为保留路径")
+	ErrPathConflict  = errors.New("路径与文件或文件夹冲突")
+	ErrQuotaExceeded = errors.New("超出配额")
+	ErrTooLarge      = errors.New("超出大小上限")
+
+	ErrUploadNotFound = errors.New("上传任务不存在")
+	ErrUploadLocked   = errors.New("上传任务正在写入")
+	ErrUploadConflict = errors.New("上传偏移量或分片不一致")
+)
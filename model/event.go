@@ -0,0 +1,24 @@
+package model
+
+import "github.com/cellargalaxy/go_common/util"
+
+const (
+	FileEventAdd    = "add"
+	FileEventRemove = "remove"
+	FileEventMove   = "move"
+
+	FileEventSourceApi   = "api"
+	FileEventSourceWatch = "watch"
+)
+
+// FileEvent 文件变更事件，无论是接口上传删除还是外部直接改动文件夹，都产生同样的事件；移动时FromPath为原路径
+type FileEvent struct {
+	Type     string `json:"type"`
+	Path     string `json:"path"`
+	FromPath string `json:"from_path,omitempty"`
+	Source   string `json:"source"`
+}
+
+func (this FileEvent) String() string {
+	return util.ToJsonString(this)
+}
@@ -1,6 +1,11 @@
 package model
 
-import "github.com/cellargalaxy/go_common/util"
+import (
+	"github.com/cellargalaxy/go_common/util"
+	"sort"
+	"strings"
+	"time"
+)
 
 type FileSimpleInfo struct {
 	Path   string `json:"path"`
@@ -9,17 +14,137 @@ type FileSimpleInfo struct {
 	Url    string `json:"url"`
 }
 
+// FileMeta 文件的标签与自定义键值，跟随文件移动、进出回收站
+type FileMeta struct {
+	Tags     []string          `json:"tags,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+func (this FileMeta) IsEmpty() bool {
+	return len(this.Tags) == 0 && len(this.Metadata) == 0
+}
+
+// Equal 标签需按NormalizeFileMeta规整后比较
+func (this FileMeta) Equal(meta FileMeta) bool {
+	if len(this.Tags) != len(meta.Tags) || len(this.Metadata) != len(meta.Metadata) {
+		return false
+	}
+	for i := range this.Tags {
+		if this.Tags[i] != meta.Tags[i] {
+			return false
+		}
+	}
+	for key, value := range this.Metadata {
+		other, ok := meta.Metadata[key]
+		if !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// HasTags 是否包含全部标签
+func (this FileMeta) HasTags(tags []string) bool {
+	for i := range tags {
+		found := false
+		for j := range this.Tags {
+			if this.Tags[j] == tags[i] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// NormalizeFileMeta 标签去掉首尾空白、去重并排序，丢弃空标签与空键
+func NormalizeFileMeta(meta FileMeta) FileMeta {
+	var object FileMeta
+	exist := make(map[string]bool, len(meta.Tags))
+	for i := range meta.Tags {
+		tag := strings.TrimSpace(meta.Tags[i])
+		if tag == "" || exist[tag] {
+			continue
+		}
+		exist[tag] = true
+		object.Tags = append(object.Tags, tag)
+	}
+	sort.Strings(object.Tags)
+	for key, value := range meta.Metadata {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		if object.Metadata == nil {
+			object.Metadata = make(map[string]string, len(meta.Metadata))
+		}
+		object.Metadata[key] = value
+	}
+	return object
+}
+
+// FileStatInfo 只取存储与索引里的元信息，不读取文件计算摘要；文件夹的Size为0，Width与Height只对能解析的图片有值
+type FileStatInfo struct {
+	FileSimpleInfo
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	MimeType string    `json:"mime_type"`
+	Width    int       `json:"width,omitempty"`
+	Height   int       `json:"height,omitempty"`
+	FileMeta
+}
+
+const (
+	HashMd5         = "md5"
+	HashSha256      = "sha256"
+	HashCrc32c      = "crc32c"
+	OutMaxHashLimit = "out_max_hash_limit"
+)
+
 type FileCompleteInfo struct {
 	FileSimpleInfo
-	Size  int64  `json:"size"`
-	Count int32  `json:"count"`
-	Md5   string `json:"md5"`
+	Size       int64  `json:"size"`
+	StoredSize int64  `json:"stored_size"`
+	Count      int32  `json:"count"`
+	Md5        string `json:"md5"`
+	Sha256     string `json:"sha256"`
+	Crc32c     string `json:"crc32c"`
+	FileMeta
+}
+
+// GetHash 按摘要算法取摘要，未知算法按md5处理，摘要不可用时返回空
+func (this FileCompleteInfo) GetHash(hashType string) string {
+	var hash string
+	switch hashType {
+	case HashSha256:
+		hash = this.Sha256
+	case HashCrc32c:
+		hash = this.Crc32c
+	default:
+		hash = this.Md5
+	}
+	if hash == OutMaxHashLimit {
+		return ""
+	}
+	return hash
 }
 
+const (
+	ConflictOverwrite = "overwrite"
+	ConflictSkip      = "skip"
+	ConflictFail      = "fail"
+)
+
+// UrlAddRequest Tags与Metadata都为nil时沿用被覆盖文件的元数据，表单里的metadata为json对象
 type UrlAddRequest struct {
-	Path string `json:"path" form:"path" query:"path"`
-	Url  string `json:"url" form:"url" query:"url"`
-	Raw  bool   `json:"raw" form:"raw" query:"raw"`
+	Path     string            `json:"path" form:"path" query:"path"`
+	Url      string            `json:"url" form:"url" query:"url"`
+	Raw      bool              `json:"raw" form:"raw" query:"raw"`
+	Tags     []string          `json:"tags" form:"tags" query:"tags"`
+	Metadata map[string]string `json:"metadata" form:"metadata" query:"metadata"`
 }
 
 func (this UrlAddRequest) String() string {
@@ -74,16 +199,38 @@ func (this FileCompleteInfoGetResponse) String() string {
 	return util.ToJsonString(this)
 }
 
+const (
+	ListSortName  = "name"
+	ListSortSize  = "size"
+	ListSortMtime = "mtime"
+
+	ListKindFile = "file"
+	ListKindDir  = "dir"
+)
+
+// FileSimpleInfoListRequest Limit小于等于0时不分页；Exts与MimeType只过滤文件，文件夹用Kind过滤；Stat为true时结果在Stats里
 type FileSimpleInfoListRequest struct {
-	Path string `json:"path" form:"path" query:"path"`
+	Path     string   `json:"path" form:"path" query:"path"`
+	Cursor   string   `json:"cursor" form:"cursor" query:"cursor"`
+	Limit    int      `json:"limit" form:"limit" query:"limit"`
+	Sort     string   `json:"sort" form:"sort" query:"sort"`
+	Desc     bool     `json:"desc" form:"desc" query:"desc"`
+	Glob     string   `json:"glob" form:"glob" query:"glob"`
+	Exts     []string `json:"exts" form:"exts" query:"exts"`
+	MimeType string   `json:"mime_type" form:"mime_type" query:"mime_type"`
+	Kind     string   `json:"kind" form:"kind" query:"kind"`
+	Stat     bool     `json:"stat" form:"stat" query:"stat"`
 }
 
 func (this FileSimpleInfoListRequest) String() string {
 	return util.ToJsonString(this)
 }
 
+// FileSimpleInfoListResponse NextCursor为空时没有下一页
 type FileSimpleInfoListResponse struct {
-	Infos []FileSimpleInfo `json:"infos"`
+	Infos      []FileSimpleInfo `json:"infos"`
+	Stats      []FileStatInfo   `json:"stats,omitempty"`
+	NextCursor string           `json:"next_cursor"`
 }
 
 func (this FileSimpleInfoListResponse) String() string {
@@ -104,3 +251,92 @@ type LastFileInfoListResponse struct {
 func (this LastFileInfoListResponse) String() string {
 	return util.ToJsonString(this)
 }
+
+type FileMoveRequest struct {
+	FromPath string `json:"from_path" form:"from_path" query:"from_path"`
+	ToPath   string `json:"to_path" form:"to_path" query:"to_path"`
+	Conflict string `json:"conflict" form:"conflict" query:"conflict"`
+}
+
+func (this FileMoveRequest) String() string {
+	return util.ToJsonString(this)
+}
+
+type FileMoveResponse struct {
+	Infos []FileSimpleInfo `json:"infos"`
+}
+
+func (this FileMoveResponse) String() string {
+	return util.ToJsonString(this)
+}
+
+type FileCopyRequest struct {
+	FromPath string `json:"from_path" form:"from_path" query:"from_path"`
+	ToPath   string `json:"to_path" form:"to_path" query:"to_path"`
+	Conflict string `json:"conflict" form:"conflict" query:"conflict"`
+}
+
+func (this FileCopyRequest) String() string {
+	return util.ToJsonString(this)
+}
+
+type FileCopyResponse struct {
+	Infos []FileSimpleInfo `json:"infos"`
+}
+
+func (this FileCopyResponse) String() string {
+	return util.ToJsonString(this)
+}
+
+type FolderAddRequest struct {
+	Path string `json:"path" form:"path" query:"path"`
+}
+
+func (this FolderAddRequest) String() string {
+	return util.ToJsonString(this)
+}
+
+type FolderAddResponse struct {
+	Info *FileSimpleInfo `json:"info"`
+}
+
+func (this FolderAddResponse) String() string {
+	return util.ToJsonString(this)
+}
+
+type FolderRemoveRequest struct {
+	Path   string `json:"path" form:"path" query:"path"`
+	DryRun bool   `json:"dry_run" form:"dry_run" query:"dry_run"`
+}
+
+func (this FolderRemoveRequest) String() string {
+	return util.ToJsonString(this)
+}
+
+type FolderRemoveResponse struct {
+	Infos     []FileSimpleInfo `json:"infos"`
+	TrashPath string           `json:"trash_path"`
+}
+
+func (this FolderRemoveResponse) String() string {
+	return util.ToJsonString(this)
+}
+
+// FileMetaSetRequest 整体替换文件的标签与自定义键值
+type FileMetaSetRequest struct {
+	Path     string            `json:"path" form:"path" query:"path"`
+	Tags     []string          `json:"tags" form:"tags" query:"tags"`
+	Metadata map[string]string `json:"metadata" form:"metadata" query:"metadata"`
+}
+
+func (this FileMetaSetRequest) String() string {
+	return util.ToJsonString(this)
+}
+
+type FileMetaSetResponse struct {
+	Info *FileCompleteInfo `json:"info"`
+}
+
+func (this FileMetaSetResponse) String() string {
+	return util.ToJsonString(this)
+}
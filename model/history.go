@@ -0,0 +1,47 @@
+package model
+
+import (
+	"github.com/cellargalaxy/go_common/util"
+	"time"
+)
+
+// FileHistory 上传历史日志里的一条记录，Type为文件事件的类型，Id按写入顺序递增；
+// 查询结果只有仍然存在的上传记录，Path为移动后的路径
+type FileHistory struct {
+	Id       int64     `json:"id"`
+	Type     string    `json:"type"`
+	Path     string    `json:"path"`
+	FromPath string    `json:"from_path,omitempty"`
+	Uploader string    `json:"uploader,omitempty"`
+	Size     int64     `json:"size,omitempty"`
+	Time     time.Time `json:"time"`
+	Url      string    `json:"url,omitempty"`
+}
+
+func (this FileHistory) String() string {
+	return util.ToJsonString(this)
+}
+
+// FileHistoryListRequest 按上传时间倒序分页，时间为unix秒，为0时不限制；Limit小于等于0时取默认值
+type FileHistoryListRequest struct {
+	StartTime int64  `json:"start_time" form:"start_time" query:"start_time"`
+	EndTime   int64  `json:"end_time" form:"end_time" query:"end_time"`
+	Prefix    string `json:"prefix" form:"prefix" query:"prefix"`
+	Uploader  string `json:"uploader" form:"uploader" query:"uploader"`
+	Cursor    string `json:"cursor" form:"cursor" query:"cursor"`
+	Limit     int    `json:"limit" form:"limit" query:"limit"`
+}
+
+func (this FileHistoryListRequest) String() string {
+	return util.ToJsonString(this)
+}
+
+// FileHistoryListResponse NextCursor为空时没有下一页
+type FileHistoryListResponse struct {
+	Histories  []FileHistory `json:"histories"`
+	NextCursor string        `json:"next_cursor"`
+}
+
+func (this FileHistoryListResponse) String() string {
+	return util.ToJsonString(this)
+}
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"github.com/cellargalaxy/go_common/util"
+	"time"
+)
+
+type FileIndex struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Md5      string    `json:"md5"`
+	Sha256   string    `json:"sha256"`
+	Crc32c   string    `json:"crc32c"`
+	ModTime  time.Time `json:"mod_time"`
+	Mime     string    `json:"mime"`
+	Uploader string    `json:"uploader"`
+	//StoredSize 实际存储的字节数，为0时与Size相同
+	StoredSize int64 `json:"stored_size"`
+	//Width Height 图片的宽高，不是图片或无法解析时为0
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	//FileMeta 用户设置的元数据，重新计算索引时需要保留
+	FileMeta
+}
+
+func (this FileIndex) GetStoredSize() int64 {
+	if this.StoredSize > 0 {
+		return this.StoredSize
+	}
+	return this.Size
+}
+
+func (this FileIndex) String() string {
+	return util.ToJsonString(this)
+}
+
+type FileIndexRebuildRequest struct {
+}
+
+func (this FileIndexRebuildRequest) String() string {
+	return util.ToJsonString(this)
+}
+
+type FileIndexRebuildResponse struct {
+	Count int `json:"count"`
+}
+
+func (this FileIndexRebuildResponse) String() string {
+	return util.ToJsonString(this)
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"github.com/cellargalaxy/go_common/util"
+	"time"
+)
+
+// ManifestEntry 目录树清单里的一个文件，Path为床里的路径；摘要不可用时为空
+type ManifestEntry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Md5     string    `json:"md5,omitempty"`
+	Sha256  string    `json:"sha256,omitempty"`
+	Crc32c  string    `json:"crc32c,omitempty"`
+	FileMeta
+}
+
+// GetHash 按摘要算法取摘要，未知算法按md5处理
+func (this ManifestEntry) GetHash(hashType string) string {
+	switch hashType {
+	case HashSha256:
+		return this.Sha256
+	case HashCrc32c:
+		return this.Crc32c
+	default:
+		return this.Md5
+	}
+}
+
+func (this ManifestEntry) String() string {
+	return util.ToJsonString(this)
+}
+
+type ManifestGetRequest struct {
+	Path string `json:"path" form:"path" query:"path"`
+}
+
+func (this ManifestGetRequest) String() string {
+	return util.ToJsonString(this)
+}
@@ -0,0 +1,39 @@
+package model
+
+import "github.com/cellargalaxy/go_common/util"
+
+// Quota 路径前缀下的配额，MaxSize与MaxCount小于等于0时不限制
+type Quota struct {
+	Path     string `yaml:"path" json:"path"`
+	MaxSize  int64  `yaml:"max_size" json:"max_size"`
+	MaxCount int32  `yaml:"max_count" json:"max_count"`
+}
+
+func (this Quota) String() string {
+	return util.ToJsonString(this)
+}
+
+type QuotaUsage struct {
+	Quota
+	Size  int64 `json:"size"`
+	Count int32 `json:"count"`
+}
+
+func (this QuotaUsage) String() string {
+	return util.ToJsonString(this)
+}
+
+type QuotaUsageListRequest struct {
+}
+
+func (this QuotaUsageListRequest) String() string {
+	return util.ToJsonString(this)
+}
+
+type QuotaUsageListResponse struct {
+	Usages []QuotaUsage `json:"usages"`
+}
+
+func (this QuotaUsageListResponse) String() string {
+	return util.ToJsonString(this)
+}
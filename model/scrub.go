@@ -0,0 +1,55 @@
+package model
+
+import (
+	"github.com/cellargalaxy/go_common/util"
+	"time"
+)
+
+const (
+	ScrubCorrupted = "corrupted"
+	ScrubMissing   = "missing"
+)
+
+// ScrubResult 校验不通过的文件，Expect为索引记录的值，Actual为重新计算的值
+type ScrubResult struct {
+	Path         string `json:"path"`
+	Status       string `json:"status"`
+	ExpectSize   int64  `json:"expect_size"`
+	ActualSize   int64  `json:"actual_size"`
+	ExpectSha256 string `json:"expect_sha256"`
+	ActualSha256 string `json:"actual_sha256"`
+	Error        string `json:"error,omitempty"` //查询或读取文件异常时的错误
+	Repaired     bool   `json:"repaired"`
+}
+
+func (this ScrubResult) String() string {
+	return util.ToJsonString(this)
+}
+
+// ScrubReport 一次校验的报告，只记录校验不通过的文件；Skipped含索引已过期与不在索引里的文件
+type ScrubReport struct {
+	StartTime time.Time     `json:"start_time"`
+	EndTime   time.Time     `json:"end_time"`
+	Checked   int           `json:"checked"`
+	Skipped   int           `json:"skipped"`
+	Results   []ScrubResult `json:"results"`
+}
+
+func (this ScrubReport) String() string {
+	return util.ToJsonString(this)
+}
+
+type ScrubReportGetRequest struct {
+}
+
+func (this ScrubReportGetRequest) String() string {
+	return util.ToJsonString(this)
+}
+
+type ScrubReportGetResponse struct {
+	Report *ScrubReport `json:"report"`
+}
+
+func (this ScrubReportGetResponse) String() string {
+	return util.ToJsonString(this)
+}
@@ -0,0 +1,44 @@
+package model
+
+import "github.com/cellargalaxy/go_common/util"
+
+const (
+	SearchModeSubstring = "substring"
+	SearchModeGlob      = "glob"
+	SearchModeRegex     = "regex"
+
+	SearchDefaultLimit = 100
+	SearchMaxLimit     = 1000
+)
+
+// FileSearchRequest 在Path下（含子文件夹）搜索文件，Name按Mode匹配文件名，为空时不按名称过滤；
+// 大小与修改时间（unix秒）的上下限为0时不限制，Tags不为空时文件须包含全部标签，Limit小于等于0时取默认值
+type FileSearchRequest struct {
+	Path       string   `json:"path" form:"path" query:"path"`
+	Name       string   `json:"name" form:"name" query:"name"`
+	Mode       string   `json:"mode" form:"mode" query:"mode"`
+	IgnoreCase bool     `json:"ignore_case" form:"ignore_case" query:"ignore_case"`
+	MinSize    int64    `json:"min_size" form:"min_size" query:"min_size"`
+	MaxSize    int64    `json:"max_size" form:"max_size" query:"max_size"`
+	MinMtime   int64    `json:"min_mtime" form:"min_mtime" query:"min_mtime"`
+	MaxMtime   int64    `json:"max_mtime" form:"max_mtime" query:"max_mtime"`
+	Exts       []string `json:"exts" form:"exts" query:"exts"`
+	MimeType   string   `json:"mime_type" form:"mime_type" query:"mime_type"`
+	Tags       []string `json:"tags" form:"tags" query:"tags"`
+	Cursor     string   `json:"cursor" form:"cursor" query:"cursor"`
+	Limit      int      `json:"limit" form:"limit" query:"limit"`
+}
+
+func (this FileSearchRequest) String() string {
+	return util.ToJsonString(this)
+}
+
+// FileSearchResponse 按路径排序，NextCursor为空时没有下一页
+type FileSearchResponse struct {
+	Infos      []FileStatInfo `json:"infos"`
+	NextCursor string         `json:"next_cursor"`
+}
+
+func (this FileSearchResponse) String() string {
+	return util.ToJsonString(this)
+}
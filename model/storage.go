@@ -0,0 +1,51 @@
+package model
+
+import (
+	"context"
+	"github.com/cellargalaxy/go_common/util"
+	"io"
+	"time"
+)
+
+const (
+	StorageLocal  = "local"
+	StorageMemory = "memory"
+	StorageS3     = "s3"
+)
+
+const (
+	SymlinkDeny             = "deny"
+	SymlinkFollowWithinRoot = "follow_within_root"
+	SymlinkAllow            = "allow"
+)
+
+type StorageInfo struct {
+	Path    string    `json:"path"`
+	Name    string    `json:"name"`
+	IsDir   bool      `json:"is_dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	//StoredSize 实际存储的字节数，压缩或加密时与Size不同，为0时与Size相同
+	StoredSize int64 `json:"stored_size"`
+}
+
+func (this StorageInfo) String() string {
+	return util.ToJsonString(this)
+}
+
+// StorageInter 文件存储驱动，路径均为以`/`开头的床内路径
+// Stat与List在路径不存在时返回nil, nil；Remove只能删除文件或空文件夹
+type StorageInter interface {
+	Stat(ctx context.Context, fileOrFolderPath string) (*StorageInfo, error)
+	List(ctx context.Context, folderPath string) ([]StorageInfo, error)
+	Read(ctx context.Context, filePath string) (io.ReadCloser, error)
+	Write(ctx context.Context, filePath string, reader io.Reader) (int64, error)
+	Remove(ctx context.Context, filePath string) error
+	Move(ctx context.Context, formPath, toPath string) error
+	Mkdir(ctx context.Context, folderPath string) error
+}
+
+// StorageGzipInter 支持直接读取压缩数据的存储驱动，文件不是以gzip存储时返回nil, nil
+type StorageGzipInter interface {
+	ReadGzip(ctx context.Context, filePath string) (io.ReadCloser, error)
+}
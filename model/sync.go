@@ -11,9 +11,11 @@ type FileSyncInter interface {
 }
 
 type PushSyncFileRequest struct {
-	Address string `json:"address" form:"address" query:"address"`
-	Secret  string `json:"secret" form:"secret" query:"secret"`
-	Path    string `json:"path" form:"path" query:"path"`
+	Address      string `json:"address" form:"address" query:"address"`
+	Secret       string `json:"secret" form:"secret" query:"secret"`
+	RemoteBucket string `json:"remote_bucket" form:"remote_bucket" query:"remote_bucket"`
+	Path         string `json:"path" form:"path" query:"path"`
+	HashType     string `json:"hash_type" form:"hash_type" query:"hash_type"`
 }
 
 func (this PushSyncFileRequest) String() string {
@@ -28,9 +30,11 @@ func (this PushSyncFileResponse) String() string {
 }
 
 type PullSyncFileRequest struct {
-	Address string `json:"address" form:"address" query:"address"`
-	Secret  string `json:"secret" form:"secret" query:"secret"`
-	Path    string `json:"path" form:"path" query:"path"`
+	Address      string `json:"address" form:"address" query:"address"`
+	Secret       string `json:"secret" form:"secret" query:"secret"`
+	RemoteBucket string `json:"remote_bucket" form:"remote_bucket" query:"remote_bucket"`
+	Path         string `json:"path" form:"path" query:"path"`
+	HashType     string `json:"hash_type" form:"hash_type" query:"hash_type"`
 }
 
 func (this PullSyncFileRequest) String() string {
@@ -0,0 +1,110 @@
+package model
+
+import (
+	"github.com/cellargalaxy/go_common/util"
+	"time"
+)
+
+const (
+	TusResumable  = "1.0.0"
+	TusExtensions = "creation,expiration,termination"
+
+	MultipartMaxPartNumber = 10000
+	MultipartMaxPartSize   = 1024 * 1024 * 1024 * 5 //5G，配置了UploadMaxSize且更小时取UploadMaxSize
+)
+
+// Upload 分片上传任务，分片暂存在UploadPath/<id>下，全部上传后合并写入床
+// tus上传按偏移量顺序续传；Multipart上传可以并发上传编号分片，完成时才知道大小
+type Upload struct {
+	Id         string    `json:"id"`
+	Path       string    `json:"path"`
+	Raw        bool      `json:"raw"`
+	Multipart  bool      `json:"multipart"`
+	Size       int64     `json:"size"`
+	Offset     int64     `json:"offset"`
+	CreateTime time.Time `json:"create_time"`
+	ExpireTime time.Time `json:"expire_time"`
+}
+
+func (this Upload) String() string {
+	return util.ToJsonString(this)
+}
+
+type UploadPart struct {
+	PartNumber int    `json:"part_number"`
+	Size       int64  `json:"size"`
+	Md5        string `json:"md5"`
+}
+
+func (this UploadPart) String() string {
+	return util.ToJsonString(this)
+}
+
+type MultipartUploadCreateRequest struct {
+	Path string `json:"path" form:"path" query:"path"`
+	Raw  bool   `json:"raw" form:"raw" query:"raw"`
+}
+
+func (this MultipartUploadCreateRequest) String() string {
+	return util.ToJsonString(this)
+}
+
+type MultipartUploadCreateResponse struct {
+	UploadId string `json:"upload_id"`
+}
+
+func (this MultipartUploadCreateResponse) String() string {
+	return util.ToJsonString(this)
+}
+
+// MultipartUploadPartRequest 参数放在query里，请求体为分片数据；md5不为空时校验分片数据
+type MultipartUploadPartRequest struct {
+	UploadId   string `json:"upload_id" form:"upload_id" query:"upload_id"`
+	PartNumber int    `json:"part_number" form:"part_number" query:"part_number"`
+	Md5        string `json:"md5" form:"md5" query:"md5"`
+}
+
+func (this MultipartUploadPartRequest) String() string {
+	return util.ToJsonString(this)
+}
+
+type MultipartUploadPartResponse struct {
+	Part *UploadPart `json:"part"`
+}
+
+func (this MultipartUploadPartResponse) String() string {
+	return util.ToJsonString(this)
+}
+
+// MultipartUploadCompleteRequest Parts按编号升序，只合并列出的分片
+type MultipartUploadCompleteRequest struct {
+	UploadId string       `json:"upload_id" form:"upload_id" query:"upload_id"`
+	Parts    []UploadPart `json:"parts" form:"parts" query:"parts"`
+}
+
+func (this MultipartUploadCompleteRequest) String() string {
+	return util.ToJsonString(this)
+}
+
+type MultipartUploadCompleteResponse struct {
+	Info *FileSimpleInfo `json:"info"`
+}
+
+func (this MultipartUploadCompleteResponse) String() string {
+	return util.ToJsonString(this)
+}
+
+type MultipartUploadAbortRequest struct {
+	UploadId string `json:"upload_id" form:"upload_id" query:"upload_id"`
+}
+
+func (this MultipartUploadAbortRequest) String() string {
+	return util.ToJsonString(this)
+}
+
+type MultipartUploadAbortResponse struct {
+}
+
+func (this MultipartUploadAbortResponse) String() string {
+	return util.ToJsonString(this)
+}
@@ -0,0 +1,59 @@
+package model
+
+import (
+	"github.com/cellargalaxy/go_common/util"
+	"time"
+)
+
+type FileVersion struct {
+	Path      string    `json:"path"`
+	VersionId string    `json:"version_id"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mod_time"`
+}
+
+func (this FileVersion) String() string {
+	return util.ToJsonString(this)
+}
+
+type FileVersionListRequest struct {
+	Path string `json:"path" form:"path" query:"path"`
+}
+
+func (this FileVersionListRequest) String() string {
+	return util.ToJsonString(this)
+}
+
+type FileVersionListResponse struct {
+	Versions []FileVersion `json:"versions"`
+}
+
+func (this FileVersionListResponse) String() string {
+	return util.ToJsonString(this)
+}
+
+type FileVersionGetRequest struct {
+	Path      string `json:"path" form:"path" query:"path"`
+	VersionId string `json:"version_id" form:"version_id" query:"version_id"`
+}
+
+func (this FileVersionGetRequest) String() string {
+	return util.ToJsonString(this)
+}
+
+type FileVersionRestoreRequest struct {
+	Path      string `json:"path" form:"path" query:"path"`
+	VersionId string `json:"version_id" form:"version_id" query:"version_id"`
+}
+
+func (this FileVersionRestoreRequest) String() string {
+	return util.ToJsonString(this)
+}
+
+type FileVersionRestoreResponse struct {
+	Info *FileSimpleInfo `json:"info"`
+}
+
+func (this FileVersionRestoreResponse) String() string {
+	return util.ToJsonString(this)
+}
@@ -1,7 +1,10 @@
 package sdk
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"fmt"
 	"github.com/cellargalaxy/go_common/util"
 	"github.com/cellargalaxy/go_file_bed/model"
@@ -9,20 +12,30 @@ import (
 	"github.com/sirupsen/logrus"
 	"io"
 	"net/http"
-	"path"
+	neturl "net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+const (
+	UploadLargePartSize    = 1024 * 1024 * 16 //16M
+	UploadLargeConcurrency = 4
+	ListPageSize           = 1000
+)
+
 type FileBedHandlerInter interface {
 	ListAddress(ctx context.Context) []string
 	GetSecret(ctx context.Context) string
+	GetBucket(ctx context.Context) string
 }
 
 type FileBedHandler struct {
 	Address string `json:"address"`
 	Secret  string `json:"-"`
+	Bucket  string `json:"bucket"`
 }
 
 func (this FileBedHandler) String() string {
@@ -40,6 +53,9 @@ func (this FileBedHandler) ListAddress(ctx context.Context) []string {
 func (this FileBedHandler) GetSecret(ctx context.Context) string {
 	return this.Secret
 }
+func (this FileBedHandler) GetBucket(ctx context.Context) string {
+	return this.Bucket
+}
 
 type FileBedClient struct {
 	timeout        time.Duration
@@ -50,8 +66,13 @@ type FileBedClient struct {
 }
 
 func NewDefaultFileBedClient(ctx context.Context, address, secret string) (*FileBedClient, error) {
+	return NewDefaultBucketFileBedClient(ctx, address, secret, "")
+}
+
+// NewDefaultBucketFileBedClient 访问命名床的客户端，bucket为空时访问默认床
+func NewDefaultBucketFileBedClient(ctx context.Context, address, secret, bucket string) (*FileBedClient, error) {
 	httpClientLong := util.CreateNotRetryHttpClient(time.Hour)
-	return NewFileBedClient(ctx, util.TimeoutDefault, util.RetryDefault, util.GetHttpClient(), httpClientLong, &FileBedHandler{Address: address, Secret: secret})
+	return NewFileBedClient(ctx, util.TimeoutDefault, util.RetryDefault, util.GetHttpClient(), httpClientLong, &FileBedHandler{Address: address, Secret: secret, Bucket: bucket})
 }
 
 func NewFileBedClient(ctx context.Context, timeout time.Duration, retry int, httpClient, httpClientLong *resty.Client, handler FileBedHandlerInter) (*FileBedClient, error) {
@@ -104,7 +125,7 @@ func (this *FileBedClient) GetFileDownloadUrl(ctx context.Context, filePath stri
 	if strings.HasSuffix(url, "/") {
 		url = url[:len(url)-1]
 	}
-	url += path.Join(model.FileUrl, filePath)
+	url += model.CreateBucketFileUrl(this.handler.GetBucket(ctx), filePath)
 	logrus.WithContext(ctx).WithFields(logrus.Fields{"url": url}).Info("获取下载文件链接")
 	return url, nil
 }
@@ -227,6 +248,348 @@ func (this *FileBedClient) requestGetFileCompleteInfo(ctx context.Context, reque
 	return body, nil
 }
 
+func (this *FileBedClient) ListFileHistory(ctx context.Context, request model.FileHistoryListRequest) (*model.FileHistoryListResponse, error) {
+	var jsonString string
+	var object *model.FileHistoryListResponse
+	var err error
+	for i := 0; i < this.retry; i++ {
+		jsonString, err = this.requestListFileHistory(ctx, request)
+		if err == nil {
+			object, err = this.parseListFileHistory(ctx, jsonString)
+			if object != nil && err == nil {
+				return object, err
+			}
+		}
+	}
+	return nil, err
+}
+func (this *FileBedClient) parseListFileHistory(ctx context.Context, jsonString string) (*model.FileHistoryListResponse, error) {
+	type Response struct {
+		Code int                           `json:"code"`
+		Msg  string                        `json:"msg"`
+		Data model.FileHistoryListResponse `json:"data"`
+	}
+	var response Response
+	err := util.UnmarshalJsonString(jsonString, &response)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询上传历史，解析响应异常")
+		return nil, fmt.Errorf("查询上传历史，解析响应异常")
+	}
+	if response.Code != util.HttpSuccessCode {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"code": response.Code, "msg": response.Msg}).Error("查询上传历史，失败")
+		return nil, fmt.Errorf("查询上传历史，失败")
+	}
+	return &response.Data, nil
+}
+func (this *FileBedClient) requestListFileHistory(ctx context.Context, request model.FileHistoryListRequest) (string, error) {
+	response, err := this.httpClient.R().SetContext(ctx).
+		SetHeader(this.genJWT(ctx)).
+		SetQueryParamsFromValues(createFileHistoryQuery(request)).
+		Get(this.GetUrl(ctx, model.ListFileHistoryUrl))
+
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询上传历史，请求异常")
+		return "", fmt.Errorf("查询上传历史，请求异常")
+	}
+	if response == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询上传历史，响应为空")
+		return "", fmt.Errorf("查询上传历史，响应为空")
+	}
+	statusCode := response.StatusCode()
+	body := response.String()
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"statusCode": statusCode, "body": body}).Info("查询上传历史，响应")
+	if statusCode != http.StatusOK {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"StatusCode": statusCode}).Error("查询上传历史，响应码失败")
+		return "", fmt.Errorf("查询上传历史，响应码失败: %+v", statusCode)
+	}
+	return body, nil
+}
+
+func createFileHistoryQuery(request model.FileHistoryListRequest) neturl.Values {
+	query := neturl.Values{}
+	if request.StartTime > 0 {
+		query.Set("start_time", strconv.FormatInt(request.StartTime, 10))
+	}
+	if request.EndTime > 0 {
+		query.Set("end_time", strconv.FormatInt(request.EndTime, 10))
+	}
+	if request.Prefix != "" {
+		query.Set("prefix", request.Prefix)
+	}
+	if request.Uploader != "" {
+		query.Set("uploader", request.Uploader)
+	}
+	if request.Cursor != "" {
+		query.Set("cursor", request.Cursor)
+	}
+	if request.Limit > 0 {
+		query.Set("limit", strconv.Itoa(request.Limit))
+	}
+	return query
+}
+
+func (this *FileBedClient) SearchAudit(ctx context.Context, request model.AuditSearchRequest) (*model.AuditSearchResponse, error) {
+	var jsonString string
+	var object *model.AuditSearchResponse
+	var err error
+	for i := 0; i < this.retry; i++ {
+		jsonString, err = this.requestSearchAudit(ctx, request)
+		if err == nil {
+			object, err = this.parseSearchAudit(ctx, jsonString)
+			if object != nil && err == nil {
+				return object, err
+			}
+		}
+	}
+	return nil, err
+}
+func (this *FileBedClient) parseSearchAudit(ctx context.Context, jsonString string) (*model.AuditSearchResponse, error) {
+	type Response struct {
+		Code int                       `json:"code"`
+		Msg  string                    `json:"msg"`
+		Data model.AuditSearchResponse `json:"data"`
+	}
+	var response Response
+	err := util.UnmarshalJsonString(jsonString, &response)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询审计记录，解析响应异常")
+		return nil, fmt.Errorf("查询审计记录，解析响应异常")
+	}
+	if response.Code != util.HttpSuccessCode {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"code": response.Code, "msg": response.Msg}).Error("查询审计记录，失败")
+		return nil, fmt.Errorf("查询审计记录，失败")
+	}
+	return &response.Data, nil
+}
+func (this *FileBedClient) requestSearchAudit(ctx context.Context, request model.AuditSearchRequest) (string, error) {
+	response, err := this.httpClient.R().SetContext(ctx).
+		SetHeader(this.genJWT(ctx)).
+		SetQueryParamsFromValues(createAuditSearchQuery(request)).
+		Get(this.GetUrl(ctx, model.SearchAuditUrl))
+
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询审计记录，请求异常")
+		return "", fmt.Errorf("查询审计记录，请求异常")
+	}
+	if response == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询审计记录，响应为空")
+		return "", fmt.Errorf("查询审计记录，响应为空")
+	}
+	statusCode := response.StatusCode()
+	body := response.String()
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"statusCode": statusCode, "body": body}).Info("查询审计记录，响应")
+	if statusCode != http.StatusOK {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"StatusCode": statusCode}).Error("查询审计记录，响应码失败")
+		return "", fmt.Errorf("查询审计记录，响应码失败: %+v", statusCode)
+	}
+	return body, nil
+}
+
+func createAuditSearchQuery(request model.AuditSearchRequest) neturl.Values {
+	query := neturl.Values{}
+	if request.StartTime > 0 {
+		query.Set("start_time", strconv.FormatInt(request.StartTime, 10))
+	}
+	if request.EndTime > 0 {
+		query.Set("end_time", strconv.FormatInt(request.EndTime, 10))
+	}
+	if request.Operation != "" {
+		query.Set("operation", request.Operation)
+	}
+	if request.Prefix != "" {
+		query.Set("prefix", request.Prefix)
+	}
+	if request.Identity != "" {
+		query.Set("identity", request.Identity)
+	}
+	if request.Ip != "" {
+		query.Set("ip", request.Ip)
+	}
+	if request.LogId > 0 {
+		query.Set("log_id", strconv.FormatInt(request.LogId, 10))
+	}
+	if request.Cursor != "" {
+		query.Set("cursor", request.Cursor)
+	}
+	if request.Limit > 0 {
+		query.Set("limit", strconv.Itoa(request.Limit))
+	}
+	return query
+}
+
+func (this *FileBedClient) SearchFile(ctx context.Context, request model.FileSearchRequest) (*model.FileSearchResponse, error) {
+	var jsonString string
+	var object *model.FileSearchResponse
+	var err error
+	for i := 0; i < this.retry; i++ {
+		jsonString, err = this.requestSearchFile(ctx, request)
+		if err == nil {
+			object, err = this.parseSearchFile(ctx, jsonString)
+			if object != nil && err == nil {
+				return object, err
+			}
+		}
+	}
+	return nil, err
+}
+func (this *FileBedClient) parseSearchFile(ctx context.Context, jsonString string) (*model.FileSearchResponse, error) {
+	type Response struct {
+		Code int                      `json:"code"`
+		Msg  string                   `json:"msg"`
+		Data model.FileSearchResponse `json:"data"`
+	}
+	var response Response
+	err := util.UnmarshalJsonString(jsonString, &response)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("搜索文件，解析响应异常")
+		return nil, fmt.Errorf("搜索文件，解析响应异常")
+	}
+	if response.Code != util.HttpSuccessCode {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"code": response.Code, "msg": response.Msg}).Error("搜索文件，失败")
+		return nil, fmt.Errorf("搜索文件，失败")
+	}
+	return &response.Data, nil
+}
+func (this *FileBedClient) requestSearchFile(ctx context.Context, request model.FileSearchRequest) (string, error) {
+	response, err := this.httpClient.R().SetContext(ctx).
+		SetHeader(this.genJWT(ctx)).
+		SetQueryParamsFromValues(createFileSearchQuery(request)).
+		Get(this.GetUrl(ctx, model.SearchFileUrl))
+
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("搜索文件，请求异常")
+		return "", fmt.Errorf("搜索文件，请求异常")
+	}
+	if response == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("搜索文件，响应为空")
+		return "", fmt.Errorf("搜索文件，响应为空")
+	}
+	statusCode := response.StatusCode()
+	body := response.String()
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"statusCode": statusCode, "body": body}).Info("搜索文件，响应")
+	if statusCode != http.StatusOK {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"StatusCode": statusCode}).Error("搜索文件，响应码失败")
+		return "", fmt.Errorf("搜索文件，响应码失败: %+v", statusCode)
+	}
+	return body, nil
+}
+
+func createFileSearchQuery(request model.FileSearchRequest) neturl.Values {
+	query := neturl.Values{}
+	query.Set("path", request.Path)
+	if request.Name != "" {
+		query.Set("name", request.Name)
+	}
+	if request.Mode != "" {
+		query.Set("mode", request.Mode)
+	}
+	if request.IgnoreCase {
+		query.Set("ignore_case", strconv.FormatBool(request.IgnoreCase))
+	}
+	if request.MinSize > 0 {
+		query.Set("min_size", strconv.FormatInt(request.MinSize, 10))
+	}
+	if request.MaxSize > 0 {
+		query.Set("max_size", strconv.FormatInt(request.MaxSize, 10))
+	}
+	if request.MinMtime > 0 {
+		query.Set("min_mtime", strconv.FormatInt(request.MinMtime, 10))
+	}
+	if request.MaxMtime > 0 {
+		query.Set("max_mtime", strconv.FormatInt(request.MaxMtime, 10))
+	}
+	for i := range request.Exts {
+		query.Add("exts", request.Exts[i])
+	}
+	if request.MimeType != "" {
+		query.Set("mime_type", request.MimeType)
+	}
+	for i := range request.Tags {
+		query.Add("tags", request.Tags[i])
+	}
+	if request.Cursor != "" {
+		query.Set("cursor", request.Cursor)
+	}
+	if request.Limit > 0 {
+		query.Set("limit", strconv.Itoa(request.Limit))
+	}
+	return query
+}
+
+// GetManifest 一次请求取回路径下（含子文件夹）全部文件的清单，用于整棵目录树的比较
+func (this *FileBedClient) GetManifest(ctx context.Context, request model.ManifestGetRequest) ([]model.ManifestEntry, error) {
+	var body string
+	var object []model.ManifestEntry
+	var err error
+	for i := 0; i < this.retry; i++ {
+		body, err = this.requestGetManifest(ctx, request)
+		if err == nil {
+			object, err = this.parseGetManifest(ctx, body)
+			if err == nil {
+				return object, err
+			}
+		}
+	}
+	return nil, err
+}
+func (this *FileBedClient) parseGetManifest(ctx context.Context, body string) ([]model.ManifestEntry, error) {
+	var entries []model.ManifestEntry
+	lines := strings.Split(body, "\n")
+	for i := range lines {
+		if strings.TrimSpace(lines[i]) == "" {
+			continue
+		}
+		var entry model.ManifestEntry
+		err := util.UnmarshalJsonString(lines[i], &entry)
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"line": lines[i], "err": err}).Error("查询文件清单，解析响应异常")
+			return nil, fmt.Errorf("查询文件清单，解析响应异常")
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+func (this *FileBedClient) requestGetManifest(ctx context.Context, request model.ManifestGetRequest) (string, error) {
+	response, err := this.httpClientLong.R().SetContext(ctx).
+		SetHeader(this.genJWT(ctx)).
+		SetQueryParam("path", request.Path).
+		Get(this.GetUrl(ctx, model.ManifestUrl))
+
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询文件清单，请求异常")
+		return "", fmt.Errorf("查询文件清单，请求异常")
+	}
+	if response == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询文件清单，响应为空")
+		return "", fmt.Errorf("查询文件清单，响应为空")
+	}
+	statusCode := response.StatusCode()
+	body := response.String()
+	//清单可能很大，不打印响应体
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"statusCode": statusCode, "length": len(body)}).Info("查询文件清单，响应")
+	if statusCode != http.StatusOK {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"StatusCode": statusCode, "body": body}).Error("查询文件清单，响应码失败")
+		return "", fmt.Errorf("查询文件清单，响应码失败: %+v", statusCode)
+	}
+	return body, nil
+}
+
+// MatchFileHash 按指定摘要算法比较远端文件，远端文件不存在或摘要不可用时返回false
+func (this *FileBedClient) MatchFileHash(ctx context.Context, filePath, hashType, hash string) (bool, error) {
+	var request model.FileCompleteInfoGetRequest
+	request.Path = filePath
+	info, err := this.GetFileCompleteInfo(ctx, request)
+	if err != nil {
+		return false, err
+	}
+	if info == nil {
+		return false, nil
+	}
+	remoteHash := info.GetHash(hashType)
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath, "hashType": hashType, "hash": hash, "remoteHash": remoteHash}).Info("比较文件摘要")
+	return remoteHash != "" && strings.EqualFold(remoteHash, hash), nil
+}
+
 func (this *FileBedClient) ListFileSimpleInfo(ctx context.Context, request model.FileSimpleInfoListRequest) ([]model.FileSimpleInfo, error) {
 	var jsonString string
 	var object *model.FileSimpleInfoListResponse
@@ -242,6 +605,23 @@ func (this *FileBedClient) ListFileSimpleInfo(ctx context.Context, request model
 	}
 	return nil, err
 }
+
+// ListFileSimpleInfoPage 查询一页，返回结果带下一页的游标
+func (this *FileBedClient) ListFileSimpleInfoPage(ctx context.Context, request model.FileSimpleInfoListRequest) (*model.FileSimpleInfoListResponse, error) {
+	var jsonString string
+	var object *model.FileSimpleInfoListResponse
+	var err error
+	for i := 0; i < this.retry; i++ {
+		jsonString, err = this.requestListFileSimpleInfo(ctx, request)
+		if err == nil {
+			object, err = this.parseListFileSimpleInfo(ctx, jsonString)
+			if object != nil && err == nil {
+				return object, err
+			}
+		}
+	}
+	return nil, err
+}
 func (this *FileBedClient) parseListFileSimpleInfo(ctx context.Context, jsonString string) (*model.FileSimpleInfoListResponse, error) {
 	type Response struct {
 		Code int                              `json:"code"`
@@ -263,7 +643,7 @@ func (this *FileBedClient) parseListFileSimpleInfo(ctx context.Context, jsonStri
 func (this *FileBedClient) requestListFileSimpleInfo(ctx context.Context, request model.FileSimpleInfoListRequest) (string, error) {
 	response, err := this.httpClient.R().SetContext(ctx).
 		SetHeader(this.genJWT(ctx)).
-		SetQueryParam("path", request.Path).
+		SetQueryParamsFromValues(createFileSimpleInfoListQuery(request)).
 		Get(this.GetUrl(ctx, model.ListFileSimpleInfoUrl))
 
 	if err != nil {
@@ -284,8 +664,1011 @@ func (this *FileBedClient) requestListFileSimpleInfo(ctx context.Context, reques
 	return body, nil
 }
 
-func (this *FileBedClient) GetUrl(ctx context.Context, path string) string {
-	return this.getUrl(ctx, this.getAddress(ctx), path)
+func createFileSimpleInfoListQuery(request model.FileSimpleInfoListRequest) neturl.Values {
+	query := neturl.Values{}
+	query.Set("path", request.Path)
+	if request.Cursor != "" {
+		query.Set("cursor", request.Cursor)
+	}
+	if request.Limit > 0 {
+		query.Set("limit", strconv.Itoa(request.Limit))
+	}
+	if request.Sort != "" {
+		query.Set("sort", request.Sort)
+	}
+	if request.Desc {
+		query.Set("desc", strconv.FormatBool(request.Desc))
+	}
+	if request.Glob != "" {
+		query.Set("glob", request.Glob)
+	}
+	for i := range request.Exts {
+		query.Add("exts", request.Exts[i])
+	}
+	if request.MimeType != "" {
+		query.Set("mime_type", request.MimeType)
+	}
+	if request.Kind != "" {
+		query.Set("kind", request.Kind)
+	}
+	if request.Stat {
+		query.Set("stat", strconv.FormatBool(request.Stat))
+	}
+	return query
+}
+
+// FileSimpleInfoIterator 逐项遍历文件夹，自动按游标翻页
+type FileSimpleInfoIterator struct {
+	client  *FileBedClient
+	request model.FileSimpleInfoListRequest
+	infos   []model.FileSimpleInfo
+	done    bool
+}
+
+// IterateFileSimpleInfo request.Limit为每页大小，小于等于0时取默认值；只遍历简单信息，忽略request.Stat
+func (this *FileBedClient) IterateFileSimpleInfo(ctx context.Context, request model.FileSimpleInfoListRequest) *FileSimpleInfoIterator {
+	request.Stat = false
+	if request.Limit <= 0 {
+		request.Limit = ListPageSize
+	}
+	return &FileSimpleInfoIterator{client: this, request: request}
+}
+
+// Next 遍历完成时返回nil
+func (this *FileSimpleInfoIterator) Next(ctx context.Context) (*model.FileSimpleInfo, error) {
+	for len(this.infos) == 0 {
+		if this.done {
+			return nil, nil
+		}
+		response, err := this.client.ListFileSimpleInfoPage(ctx, this.request)
+		if err != nil {
+			return nil, err
+		}
+		this.infos = response.Infos
+		this.request.Cursor = response.NextCursor
+		this.done = response.NextCursor == ""
+	}
+	info := this.infos[0]
+	this.infos = this.infos[1:]
+	return &info, nil
+}
+
+func (this *FileBedClient) SetFileMeta(ctx context.Context, request model.FileMetaSetRequest) (*model.FileCompleteInfo, error) {
+	var jsonString string
+	var object *model.FileMetaSetResponse
+	var err error
+	for i := 0; i < this.retry; i++ {
+		jsonString, err = this.requestSetFileMeta(ctx, request)
+		if err == nil {
+			object, err = this.parseSetFileMeta(ctx, jsonString)
+			if object != nil && err == nil {
+				return object.Info, err
+			}
+		}
+	}
+	return nil, err
+}
+func (this *FileBedClient) parseSetFileMeta(ctx context.Context, jsonString string) (*model.FileMetaSetResponse, error) {
+	type Response struct {
+		Code int                       `json:"code"`
+		Msg  string                    `json:"msg"`
+		Data model.FileMetaSetResponse `json:"data"`
+	}
+	var response Response
+	err := util.UnmarshalJsonString(jsonString, &response)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("设置文件元数据，解析响应异常")
+		return nil, fmt.Errorf("设置文件元数据，解析响应异常")
+	}
+	if response.Code != util.HttpSuccessCode {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"code": response.Code, "msg": response.Msg}).Error("设置文件元数据，失败")
+		return nil, fmt.Errorf("设置文件元数据，失败")
+	}
+	return &response.Data, nil
+}
+func (this *FileBedClient) requestSetFileMeta(ctx context.Context, request model.FileMetaSetRequest) (string, error) {
+	response, err := this.httpClient.R().SetContext(ctx).
+		SetHeader(this.genJWT(ctx)).
+		SetBody(request).
+		Post(this.GetUrl(ctx, model.SetFileMetaUrl))
+
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("设置文件元数据，请求异常")
+		return "", fmt.Errorf("设置文件元数据，请求异常")
+	}
+	if response == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("设置文件元数据，响应为空")
+		return "", fmt.Errorf("设置文件元数据，响应为空")
+	}
+	statusCode := response.StatusCode()
+	body := response.String()
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"statusCode": statusCode, "body": body}).Info("设置文件元数据，响应")
+	if statusCode != http.StatusOK {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"StatusCode": statusCode}).Error("设置文件元数据，响应码失败")
+		return "", fmt.Errorf("设置文件元数据，响应码失败: %+v", statusCode)
+	}
+	return body, nil
+}
+
+func (this *FileBedClient) MoveFile(ctx context.Context, request model.FileMoveRequest) ([]model.FileSimpleInfo, error) {
+	var jsonString string
+	var object *model.FileMoveResponse
+	var err error
+	for i := 0; i < this.retry; i++ {
+		jsonString, err = this.requestMoveFile(ctx, request)
+		if err == nil {
+			object, err = this.parseMoveFile(ctx, jsonString)
+			if object != nil && err == nil {
+				return object.Infos, err
+			}
+		}
+	}
+	return nil, err
+}
+func (this *FileBedClient) parseMoveFile(ctx context.Context, jsonString string) (*model.FileMoveResponse, error) {
+	type Response struct {
+		Code int                    `json:"code"`
+		Msg  string                 `json:"msg"`
+		Data model.FileMoveResponse `json:"data"`
+	}
+	var response Response
+	err := util.UnmarshalJsonString(jsonString, &response)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("移动文件，解析响应异常")
+		return nil, fmt.Errorf("移动文件，解析响应异常")
+	}
+	if response.Code != util.HttpSuccessCode {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"code": response.Code, "msg": response.Msg}).Error("移动文件，失败")
+		return nil, fmt.Errorf("移动文件，失败")
+	}
+	return &response.Data, nil
+}
+func (this *FileBedClient) requestMoveFile(ctx context.Context, request model.FileMoveRequest) (string, error) {
+	response, err := this.httpClient.R().SetContext(ctx).
+		SetHeader(this.genJWT(ctx)).
+		SetBody(request).
+		Post(this.GetUrl(ctx, model.MoveFileUrl))
+
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("移动文件，请求异常")
+		return "", fmt.Errorf("移动文件，请求异常")
+	}
+	if response == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("移动文件，响应为空")
+		return "", fmt.Errorf("移动文件，响应为空")
+	}
+	statusCode := response.StatusCode()
+	body := response.String()
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"statusCode": statusCode, "body": body}).Info("移动文件，响应")
+	if statusCode != http.StatusOK {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"StatusCode": statusCode}).Error("移动文件，响应码失败")
+		return "", fmt.Errorf("移动文件，响应码失败: %+v", statusCode)
+	}
+	return body, nil
+}
+
+func (this *FileBedClient) CopyFile(ctx context.Context, request model.FileCopyRequest) ([]model.FileSimpleInfo, error) {
+	var jsonString string
+	var object *model.FileCopyResponse
+	var err error
+	for i := 0; i < this.retry; i++ {
+		jsonString, err = this.requestCopyFile(ctx, request)
+		if err == nil {
+			object, err = this.parseCopyFile(ctx, jsonString)
+			if object != nil && err == nil {
+				return object.Infos, err
+			}
+		}
+	}
+	return nil, err
+}
+func (this *FileBedClient) parseCopyFile(ctx context.Context, jsonString string) (*model.FileCopyResponse, error) {
+	type Response struct {
+		Code int                    `json:"code"`
+		Msg  string                 `json:"msg"`
+		Data model.FileCopyResponse `json:"data"`
+	}
+	var response Response
+	err := util.UnmarshalJsonString(jsonString, &response)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("复制文件，解析响应异常")
+		return nil, fmt.Errorf("复制文件，解析响应异常")
+	}
+	if response.Code != util.HttpSuccessCode {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"code": response.Code, "msg": response.Msg}).Error("复制文件，失败")
+		return nil, fmt.Errorf("复制文件，失败")
+	}
+	return &response.Data, nil
+}
+func (this *FileBedClient) requestCopyFile(ctx context.Context, request model.FileCopyRequest) (string, error) {
+	response, err := this.httpClient.R().SetContext(ctx).
+		SetHeader(this.genJWT(ctx)).
+		SetBody(request).
+		Post(this.GetUrl(ctx, model.CopyFileUrl))
+
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("复制文件，请求异常")
+		return "", fmt.Errorf("复制文件，请求异常")
+	}
+	if response == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("复制文件，响应为空")
+		return "", fmt.Errorf("复制文件，响应为空")
+	}
+	statusCode := response.StatusCode()
+	body := response.String()
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"statusCode": statusCode, "body": body}).Info("复制文件，响应")
+	if statusCode != http.StatusOK {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"StatusCode": statusCode}).Error("复制文件，响应码失败")
+		return "", fmt.Errorf("复制文件，响应码失败: %+v", statusCode)
+	}
+	return body, nil
+}
+
+func (this *FileBedClient) AddFolder(ctx context.Context, request model.FolderAddRequest) (*model.FileSimpleInfo, error) {
+	var jsonString string
+	var object *model.FolderAddResponse
+	var err error
+	for i := 0; i < this.retry; i++ {
+		jsonString, err = this.requestAddFolder(ctx, request)
+		if err == nil {
+			object, err = this.parseAddFolder(ctx, jsonString)
+			if object != nil && err == nil {
+				return object.Info, err
+			}
+		}
+	}
+	return nil, err
+}
+func (this *FileBedClient) parseAddFolder(ctx context.Context, jsonString string) (*model.FolderAddResponse, error) {
+	type Response struct {
+		Code int                     `json:"code"`
+		Msg  string                  `json:"msg"`
+		Data model.FolderAddResponse `json:"data"`
+	}
+	var response Response
+	err := util.UnmarshalJsonString(jsonString, &response)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("添加文件夹，解析响应异常")
+		return nil, fmt.Errorf("添加文件夹，解析响应异常")
+	}
+	if response.Code != util.HttpSuccessCode {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"code": response.Code, "msg": response.Msg}).Error("添加文件夹，失败")
+		return nil, fmt.Errorf("添加文件夹，失败")
+	}
+	return &response.Data, nil
+}
+func (this *FileBedClient) requestAddFolder(ctx context.Context, request model.FolderAddRequest) (string, error) {
+	response, err := this.httpClient.R().SetContext(ctx).
+		SetHeader(this.genJWT(ctx)).
+		SetBody(request).
+		Post(this.GetUrl(ctx, model.AddFolderUrl))
+
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("添加文件夹，请求异常")
+		return "", fmt.Errorf("添加文件夹，请求异常")
+	}
+	if response == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("添加文件夹，响应为空")
+		return "", fmt.Errorf("添加文件夹，响应为空")
+	}
+	statusCode := response.StatusCode()
+	body := response.String()
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"statusCode": statusCode, "body": body}).Info("添加文件夹，响应")
+	if statusCode != http.StatusOK {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"StatusCode": statusCode}).Error("添加文件夹，响应码失败")
+		return "", fmt.Errorf("添加文件夹，响应码失败: %+v", statusCode)
+	}
+	return body, nil
+}
+
+func (this *FileBedClient) RemoveFolder(ctx context.Context, request model.FolderRemoveRequest) (*model.FolderRemoveResponse, error) {
+	var jsonString string
+	var object *model.FolderRemoveResponse
+	var err error
+	for i := 0; i < this.retry; i++ {
+		jsonString, err = this.requestRemoveFolder(ctx, request)
+		if err == nil {
+			object, err = this.parseRemoveFolder(ctx, jsonString)
+			if object != nil && err == nil {
+				return object, err
+			}
+		}
+	}
+	return nil, err
+}
+func (this *FileBedClient) parseRemoveFolder(ctx context.Context, jsonString string) (*model.FolderRemoveResponse, error) {
+	type Response struct {
+		Code int                        `json:"code"`
+		Msg  string                     `json:"msg"`
+		Data model.FolderRemoveResponse `json:"data"`
+	}
+	var response Response
+	err := util.UnmarshalJsonString(jsonString, &response)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("删除文件夹，解析响应异常")
+		return nil, fmt.Errorf("删除文件夹，解析响应异常")
+	}
+	if response.Code != util.HttpSuccessCode {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"code": response.Code, "msg": response.Msg}).Error("删除文件夹，失败")
+		return nil, fmt.Errorf("删除文件夹，失败")
+	}
+	return &response.Data, nil
+}
+func (this *FileBedClient) requestRemoveFolder(ctx context.Context, request model.FolderRemoveRequest) (string, error) {
+	response, err := this.httpClient.R().SetContext(ctx).
+		SetHeader(this.genJWT(ctx)).
+		SetBody(request).
+		Post(this.GetUrl(ctx, model.RemoveFolderUrl))
+
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("删除文件夹，请求异常")
+		return "", fmt.Errorf("删除文件夹，请求异常")
+	}
+	if response == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("删除文件夹，响应为空")
+		return "", fmt.Errorf("删除文件夹，响应为空")
+	}
+	statusCode := response.StatusCode()
+	body := response.String()
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"statusCode": statusCode, "body": body}).Info("删除文件夹，响应")
+	if statusCode != http.StatusOK {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"StatusCode": statusCode}).Error("删除文件夹，响应码失败")
+		return "", fmt.Errorf("删除文件夹，响应码失败: %+v", statusCode)
+	}
+	return body, nil
+}
+
+func (this *FileBedClient) ListFileVersion(ctx context.Context, request model.FileVersionListRequest) ([]model.FileVersion, error) {
+	var jsonString string
+	var object *model.FileVersionListResponse
+	var err error
+	for i := 0; i < this.retry; i++ {
+		jsonString, err = this.requestListFileVersion(ctx, request)
+		if err == nil {
+			object, err = this.parseListFileVersion(ctx, jsonString)
+			if object != nil && err == nil {
+				return object.Versions, err
+			}
+		}
+	}
+	return nil, err
+}
+func (this *FileBedClient) parseListFileVersion(ctx context.Context, jsonString string) (*model.FileVersionListResponse, error) {
+	type Response struct {
+		Code int                           `json:"code"`
+		Msg  string                        `json:"msg"`
+		Data model.FileVersionListResponse `json:"data"`
+	}
+	var response Response
+	err := util.UnmarshalJsonString(jsonString, &response)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询历史版本，解析响应异常")
+		return nil, fmt.Errorf("查询历史版本，解析响应异常")
+	}
+	if response.Code != util.HttpSuccessCode {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"code": response.Code, "msg": response.Msg}).Error("查询历史版本，失败")
+		return nil, fmt.Errorf("查询历史版本，失败")
+	}
+	return &response.Data, nil
+}
+func (this *FileBedClient) requestListFileVersion(ctx context.Context, request model.FileVersionListRequest) (string, error) {
+	response, err := this.httpClient.R().SetContext(ctx).
+		SetHeader(this.genJWT(ctx)).
+		SetQueryParam("path", request.Path).
+		Get(this.GetUrl(ctx, model.ListFileVersionUrl))
+
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询历史版本，请求异常")
+		return "", fmt.Errorf("查询历史版本，请求异常")
+	}
+	if response == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询历史版本，响应为空")
+		return "", fmt.Errorf("查询历史版本，响应为空")
+	}
+	statusCode := response.StatusCode()
+	body := response.String()
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"statusCode": statusCode, "body": body}).Info("查询历史版本，响应")
+	if statusCode != http.StatusOK {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"StatusCode": statusCode}).Error("查询历史版本，响应码失败")
+		return "", fmt.Errorf("查询历史版本，响应码失败: %+v", statusCode)
+	}
+	return body, nil
+}
+
+func (this *FileBedClient) RestoreFileVersion(ctx context.Context, request model.FileVersionRestoreRequest) (*model.FileSimpleInfo, error) {
+	var jsonString string
+	var object *model.FileVersionRestoreResponse
+	var err error
+	for i := 0; i < this.retry; i++ {
+		jsonString, err = this.requestRestoreFileVersion(ctx, request)
+		if err == nil {
+			object, err = this.parseRestoreFileVersion(ctx, jsonString)
+			if object != nil && err == nil {
+				return object.Info, err
+			}
+		}
+	}
+	return nil, err
+}
+func (this *FileBedClient) parseRestoreFileVersion(ctx context.Context, jsonString string) (*model.FileVersionRestoreResponse, error) {
+	type Response struct {
+		Code int                              `json:"code"`
+		Msg  string                           `json:"msg"`
+		Data model.FileVersionRestoreResponse `json:"data"`
+	}
+	var response Response
+	err := util.UnmarshalJsonString(jsonString, &response)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("恢复历史版本，解析响应异常")
+		return nil, fmt.Errorf("恢复历史版本，解析响应异常")
+	}
+	if response.Code != util.HttpSuccessCode {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"code": response.Code, "msg": response.Msg}).Error("恢复历史版本，失败")
+		return nil, fmt.Errorf("恢复历史版本，失败")
+	}
+	return &response.Data, nil
+}
+func (this *FileBedClient) requestRestoreFileVersion(ctx context.Context, request model.FileVersionRestoreRequest) (string, error) {
+	response, err := this.httpClient.R().SetContext(ctx).
+		SetHeader(this.genJWT(ctx)).
+		SetBody(request).
+		Post(this.GetUrl(ctx, model.RestoreFileVersionUrl))
+
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("恢复历史版本，请求异常")
+		return "", fmt.Errorf("恢复历史版本，请求异常")
+	}
+	if response == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("恢复历史版本，响应为空")
+		return "", fmt.Errorf("恢复历史版本，响应为空")
+	}
+	statusCode := response.StatusCode()
+	body := response.String()
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"statusCode": statusCode, "body": body}).Info("恢复历史版本，响应")
+	if statusCode != http.StatusOK {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"StatusCode": statusCode}).Error("恢复历史版本，响应码失败")
+		return "", fmt.Errorf("恢复历史版本，响应码失败: %+v", statusCode)
+	}
+	return body, nil
+}
+
+func (this *FileBedClient) DownloadFileVersion(ctx context.Context, request model.FileVersionGetRequest, writer io.Writer) error {
+	response, err := this.httpClientLong.R().SetContext(ctx).
+		SetHeader(this.genJWT(ctx)).
+		SetQueryParam("path", request.Path).
+		SetQueryParam("version_id", request.VersionId).
+		SetDoNotParseResponse(true).
+		Get(this.GetUrl(ctx, model.GetFileVersionUrl))
+
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("下载历史版本，请求异常")
+		return fmt.Errorf("下载历史版本，请求异常")
+	}
+	if response == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("下载历史版本，响应为空")
+		return fmt.Errorf("下载历史版本，响应为空")
+	}
+	reader := response.RawBody()
+	defer reader.Close()
+	statusCode := response.StatusCode()
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"statusCode": statusCode}).Info("下载历史版本，响应")
+	if statusCode != http.StatusOK {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"StatusCode": statusCode}).Error("下载历史版本，响应码失败")
+		return fmt.Errorf("下载历史版本，响应码失败: %+v", statusCode)
+	}
+
+	written, err := io.Copy(writer, reader)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"request": request, "err": err}).Error("下载历史版本，拷贝数据异常")
+		return fmt.Errorf("下载历史版本，拷贝数据异常: %+v", err)
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"request": request, "written": written}).Info("下载历史版本，拷贝数据完成")
+	return nil
+}
+
+func (this *FileBedClient) ListQuotaUsage(ctx context.Context, request model.QuotaUsageListRequest) ([]model.QuotaUsage, error) {
+	var jsonString string
+	var object *model.QuotaUsageListResponse
+	var err error
+	for i := 0; i < this.retry; i++ {
+		jsonString, err = this.requestListQuotaUsage(ctx, request)
+		if err == nil {
+			object, err = this.parseListQuotaUsage(ctx, jsonString)
+			if object != nil && err == nil {
+				return object.Usages, err
+			}
+		}
+	}
+	return nil, err
+}
+func (this *FileBedClient) parseListQuotaUsage(ctx context.Context, jsonString string) (*model.QuotaUsageListResponse, error) {
+	type Response struct {
+		Code int                          `json:"code"`
+		Msg  string                       `json:"msg"`
+		Data model.QuotaUsageListResponse `json:"data"`
+	}
+	var response Response
+	err := util.UnmarshalJsonString(jsonString, &response)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询配额用量，解析响应异常")
+		return nil, fmt.Errorf("查询配额用量，解析响应异常")
+	}
+	if response.Code != util.HttpSuccessCode {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"code": response.Code, "msg": response.Msg}).Error("查询配额用量，失败")
+		return nil, fmt.Errorf("查询配额用量，失败")
+	}
+	return &response.Data, nil
+}
+func (this *FileBedClient) requestListQuotaUsage(ctx context.Context, request model.QuotaUsageListRequest) (string, error) {
+	response, err := this.httpClient.R().SetContext(ctx).
+		SetHeader(this.genJWT(ctx)).
+		Get(this.GetUrl(ctx, model.ListQuotaUsageUrl))
+
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询配额用量，请求异常")
+		return "", fmt.Errorf("查询配额用量，请求异常")
+	}
+	if response == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询配额用量，响应为空")
+		return "", fmt.Errorf("查询配额用量，响应为空")
+	}
+	statusCode := response.StatusCode()
+	body := response.String()
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"statusCode": statusCode, "body": body}).Info("查询配额用量，响应")
+	if statusCode != http.StatusOK {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"StatusCode": statusCode}).Error("查询配额用量，响应码失败")
+		return "", fmt.Errorf("查询配额用量，响应码失败: %+v", statusCode)
+	}
+	return body, nil
+}
+
+func (this *FileBedClient) GetScrubReport(ctx context.Context, request model.ScrubReportGetRequest) (*model.ScrubReport, error) {
+	var jsonString string
+	var object *model.ScrubReportGetResponse
+	var err error
+	for i := 0; i < this.retry; i++ {
+		jsonString, err = this.requestGetScrubReport(ctx, request)
+		if err == nil {
+			object, err = this.parseGetScrubReport(ctx, jsonString)
+			if object != nil && err == nil {
+				return object.Report, err
+			}
+		}
+	}
+	return nil, err
+}
+func (this *FileBedClient) parseGetScrubReport(ctx context.Context, jsonString string) (*model.ScrubReportGetResponse, error) {
+	type Response struct {
+		Code int                          `json:"code"`
+		Msg  string                       `json:"msg"`
+		Data model.ScrubReportGetResponse `json:"data"`
+	}
+	var response Response
+	err := util.UnmarshalJsonString(jsonString, &response)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询校验报告，解析响应异常")
+		return nil, fmt.Errorf("查询校验报告，解析响应异常")
+	}
+	if response.Code != util.HttpSuccessCode {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"code": response.Code, "msg": response.Msg}).Error("查询校验报告，失败")
+		return nil, fmt.Errorf("查询校验报告，失败")
+	}
+	return &response.Data, nil
+}
+func (this *FileBedClient) requestGetScrubReport(ctx context.Context, request model.ScrubReportGetRequest) (string, error) {
+	response, err := this.httpClient.R().SetContext(ctx).
+		SetHeader(this.genJWT(ctx)).
+		Get(this.GetUrl(ctx, model.GetScrubReportUrl))
+
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询校验报告，请求异常")
+		return "", fmt.Errorf("查询校验报告，请求异常")
+	}
+	if response == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("查询校验报告，响应为空")
+		return "", fmt.Errorf("查询校验报告，响应为空")
+	}
+	statusCode := response.StatusCode()
+	body := response.String()
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"statusCode": statusCode, "body": body}).Info("查询校验报告，响应")
+	if statusCode != http.StatusOK {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"StatusCode": statusCode}).Error("查询校验报告，响应码失败")
+		return "", fmt.Errorf("查询校验报告，响应码失败: %+v", statusCode)
+	}
+	return body, nil
+}
+
+func (this *FileBedClient) ReencryptFile(ctx context.Context, request model.FileReencryptRequest) (*model.FileReencryptResponse, error) {
+	var jsonString string
+	var object *model.FileReencryptResponse
+	var err error
+	for i := 0; i < this.retry; i++ {
+		jsonString, err = this.requestReencryptFile(ctx, request)
+		if err == nil {
+			object, err = this.parseReencryptFile(ctx, jsonString)
+			if object != nil && err == nil {
+				return object, err
+			}
+		}
+	}
+	return nil, err
+}
+func (this *FileBedClient) parseReencryptFile(ctx context.Context, jsonString string) (*model.FileReencryptResponse, error) {
+	type Response struct {
+		Code int                         `json:"code"`
+		Msg  string                      `json:"msg"`
+		Data model.FileReencryptResponse `json:"data"`
+	}
+	var response Response
+	err := util.UnmarshalJsonString(jsonString, &response)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("重新加密文件，解析响应异常")
+		return nil, fmt.Errorf("重新加密文件，解析响应异常")
+	}
+	if response.Code != util.HttpSuccessCode {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"code": response.Code, "msg": response.Msg}).Error("重新加密文件，失败")
+		return nil, fmt.Errorf("重新加密文件，失败")
+	}
+	return &response.Data, nil
+}
+func (this *FileBedClient) requestReencryptFile(ctx context.Context, request model.FileReencryptRequest) (string, error) {
+	response, err := this.httpClient.R().SetContext(ctx).
+		SetHeader(this.genJWT(ctx)).
+		SetBody(request).
+		Post(this.GetUrl(ctx, model.ReencryptFileUrl))
+
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("重新加密文件，请求异常")
+		return "", fmt.Errorf("重新加密文件，请求异常")
+	}
+	if response == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("重新加密文件，响应为空")
+		return "", fmt.Errorf("重新加密文件，响应为空")
+	}
+	statusCode := response.StatusCode()
+	body := response.String()
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"statusCode": statusCode, "body": body}).Info("重新加密文件，响应")
+	if statusCode != http.StatusOK {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"StatusCode": statusCode}).Error("重新加密文件，响应码失败")
+		return "", fmt.Errorf("重新加密文件，响应码失败: %+v", statusCode)
+	}
+	return body, nil
+}
+
+func (this *FileBedClient) CreateMultipartUpload(ctx context.Context, request model.MultipartUploadCreateRequest) (string, error) {
+	var jsonString string
+	var object *model.MultipartUploadCreateResponse
+	var err error
+	for i := 0; i < this.retry; i++ {
+		jsonString, err = this.requestCreateMultipartUpload(ctx, request)
+		if err == nil {
+			object, err = this.parseCreateMultipartUpload(ctx, jsonString)
+			if object != nil && err == nil {
+				return object.UploadId, err
+			}
+		}
+	}
+	return "", err
+}
+func (this *FileBedClient) parseCreateMultipartUpload(ctx context.Context, jsonString string) (*model.MultipartUploadCreateResponse, error) {
+	type Response struct {
+		Code int                                 `json:"code"`
+		Msg  string                              `json:"msg"`
+		Data model.MultipartUploadCreateResponse `json:"data"`
+	}
+	var response Response
+	err := util.UnmarshalJsonString(jsonString, &response)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("创建Multipart上传，解析响应异常")
+		return nil, fmt.Errorf("创建Multipart上传，解析响应异常")
+	}
+	if response.Code != util.HttpSuccessCode {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"code": response.Code, "msg": response.Msg}).Error("创建Multipart上传，失败")
+		return nil, fmt.Errorf("创建Multipart上传，失败")
+	}
+	return &response.Data, nil
+}
+func (this *FileBedClient) requestCreateMultipartUpload(ctx context.Context, request model.MultipartUploadCreateRequest) (string, error) {
+	response, err := this.httpClient.R().SetContext(ctx).
+		SetHeader(this.genJWT(ctx)).
+		SetBody(request).
+		Post(this.GetUrl(ctx, model.CreateMultipartUrl))
+
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("创建Multipart上传，请求异常")
+		return "", fmt.Errorf("创建Multipart上传，请求异常")
+	}
+	if response == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("创建Multipart上传，响应为空")
+		return "", fmt.Errorf("创建Multipart上传，响应为空")
+	}
+	statusCode := response.StatusCode()
+	body := response.String()
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"statusCode": statusCode, "body": body}).Info("创建Multipart上传，响应")
+	if statusCode != http.StatusOK {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"StatusCode": statusCode}).Error("创建Multipart上传，响应码失败")
+		return "", fmt.Errorf("创建Multipart上传，响应码失败: %+v", statusCode)
+	}
+	return body, nil
+}
+
+func (this *FileBedClient) UploadMultipartPart(ctx context.Context, request model.MultipartUploadPartRequest, data []byte) (*model.UploadPart, error) {
+	var jsonString string
+	var object *model.MultipartUploadPartResponse
+	var err error
+	for i := 0; i < this.retry; i++ {
+		jsonString, err = this.requestUploadMultipartPart(ctx, request, data)
+		if err == nil {
+			object, err = this.parseUploadMultipartPart(ctx, jsonString)
+			if object != nil && err == nil {
+				return object.Part, err
+			}
+		}
+	}
+	return nil, err
+}
+func (this *FileBedClient) parseUploadMultipartPart(ctx context.Context, jsonString string) (*model.MultipartUploadPartResponse, error) {
+	type Response struct {
+		Code int                               `json:"code"`
+		Msg  string                            `json:"msg"`
+		Data model.MultipartUploadPartResponse `json:"data"`
+	}
+	var response Response
+	err := util.UnmarshalJsonString(jsonString, &response)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("上传Multipart分片，解析响应异常")
+		return nil, fmt.Errorf("上传Multipart分片，解析响应异常")
+	}
+	if response.Code != util.HttpSuccessCode {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"code": response.Code, "msg": response.Msg}).Error("上传Multipart分片，失败")
+		return nil, fmt.Errorf("上传Multipart分片，失败")
+	}
+	return &response.Data, nil
+}
+func (this *FileBedClient) requestUploadMultipartPart(ctx context.Context, request model.MultipartUploadPartRequest, data []byte) (string, error) {
+	response, err := this.httpClientLong.R().SetContext(ctx).
+		SetHeader(this.genJWT(ctx)).
+		SetQueryParam("upload_id", request.UploadId).
+		SetQueryParam("part_number", strconv.Itoa(request.PartNumber)).
+		SetQueryParam("md5", request.Md5).
+		SetHeader("Content-Type", "application/octet-stream").
+		SetBody(bytes.NewReader(data)).
+		Post(this.GetUrl(ctx, model.UploadMultipartPartUrl))
+
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("上传Multipart分片，请求异常")
+		return "", fmt.Errorf("上传Multipart分片，请求异常")
+	}
+	if response == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("上传Multipart分片，响应为空")
+		return "", fmt.Errorf("上传Multipart分片，响应为空")
+	}
+	statusCode := response.StatusCode()
+	body := response.String()
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"statusCode": statusCode, "body": body}).Info("上传Multipart分片，响应")
+	if statusCode != http.StatusOK {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"StatusCode": statusCode}).Error("上传Multipart分片，响应码失败")
+		return "", fmt.Errorf("上传Multipart分片，响应码失败: %+v", statusCode)
+	}
+	return body, nil
+}
+
+func (this *FileBedClient) CompleteMultipartUpload(ctx context.Context, request model.MultipartUploadCompleteRequest) (*model.FileSimpleInfo, error) {
+	var jsonString string
+	var object *model.MultipartUploadCompleteResponse
+	var err error
+	for i := 0; i < this.retry; i++ {
+		jsonString, err = this.requestCompleteMultipartUpload(ctx, request)
+		if err == nil {
+			object, err = this.parseCompleteMultipartUpload(ctx, jsonString)
+			if object != nil && err == nil {
+				return object.Info, err
+			}
+		}
+	}
+	return nil, err
+}
+func (this *FileBedClient) parseCompleteMultipartUpload(ctx context.Context, jsonString string) (*model.MultipartUploadCompleteResponse, error) {
+	type Response struct {
+		Code int                                   `json:"code"`
+		Msg  string                                `json:"msg"`
+		Data model.MultipartUploadCompleteResponse `json:"data"`
+	}
+	var response Response
+	err := util.UnmarshalJsonString(jsonString, &response)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("完成Multipart上传，解析响应异常")
+		return nil, fmt.Errorf("完成Multipart上传，解析响应异常")
+	}
+	if response.Code != util.HttpSuccessCode {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"code": response.Code, "msg": response.Msg}).Error("完成Multipart上传，失败")
+		return nil, fmt.Errorf("完成Multipart上传，失败")
+	}
+	return &response.Data, nil
+}
+func (this *FileBedClient) requestCompleteMultipartUpload(ctx context.Context, request model.MultipartUploadCompleteRequest) (string, error) {
+	response, err := this.httpClientLong.R().SetContext(ctx).
+		SetHeader(this.genJWT(ctx)).
+		SetBody(request).
+		Post(this.GetUrl(ctx, model.CompleteMultipartUrl))
+
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("完成Multipart上传，请求异常")
+		return "", fmt.Errorf("完成Multipart上传，请求异常")
+	}
+	if response == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("完成Multipart上传，响应为空")
+		return "", fmt.Errorf("完成Multipart上传，响应为空")
+	}
+	statusCode := response.StatusCode()
+	body := response.String()
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"statusCode": statusCode, "body": body}).Info("完成Multipart上传，响应")
+	if statusCode != http.StatusOK {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"StatusCode": statusCode}).Error("完成Multipart上传，响应码失败")
+		return "", fmt.Errorf("完成Multipart上传，响应码失败: %+v", statusCode)
+	}
+	return body, nil
+}
+
+func (this *FileBedClient) AbortMultipartUpload(ctx context.Context, request model.MultipartUploadAbortRequest) (*model.MultipartUploadAbortResponse, error) {
+	var jsonString string
+	var object *model.MultipartUploadAbortResponse
+	var err error
+	for i := 0; i < this.retry; i++ {
+		jsonString, err = this.requestAbortMultipartUpload(ctx, request)
+		if err == nil {
+			object, err = this.parseAbortMultipartUpload(ctx, jsonString)
+			if object != nil && err == nil {
+				return object, err
+			}
+		}
+	}
+	return nil, err
+}
+func (this *FileBedClient) parseAbortMultipartUpload(ctx context.Context, jsonString string) (*model.MultipartUploadAbortResponse, error) {
+	type Response struct {
+		Code int                                `json:"code"`
+		Msg  string                             `json:"msg"`
+		Data model.MultipartUploadAbortResponse `json:"data"`
+	}
+	var response Response
+	err := util.UnmarshalJsonString(jsonString, &response)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("取消Multipart上传，解析响应异常")
+		return nil, fmt.Errorf("取消Multipart上传，解析响应异常")
+	}
+	if response.Code != util.HttpSuccessCode {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"code": response.Code, "msg": response.Msg}).Error("取消Multipart上传，失败")
+		return nil, fmt.Errorf("取消Multipart上传，失败")
+	}
+	return &response.Data, nil
+}
+func (this *FileBedClient) requestAbortMultipartUpload(ctx context.Context, request model.MultipartUploadAbortRequest) (string, error) {
+	response, err := this.httpClient.R().SetContext(ctx).
+		SetHeader(this.genJWT(ctx)).
+		SetBody(request).
+		Post(this.GetUrl(ctx, model.AbortMultipartUrl))
+
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("取消Multipart上传，请求异常")
+		return "", fmt.Errorf("取消Multipart上传，请求异常")
+	}
+	if response == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("取消Multipart上传，响应为空")
+		return "", fmt.Errorf("取消Multipart上传，响应为空")
+	}
+	statusCode := response.StatusCode()
+	body := response.String()
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"statusCode": statusCode, "body": body}).Info("取消Multipart上传，响应")
+	if statusCode != http.StatusOK {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"StatusCode": statusCode}).Error("取消Multipart上传，响应码失败")
+		return "", fmt.Errorf("取消Multipart上传，响应码失败: %+v", statusCode)
+	}
+	return body, nil
+}
+
+// UploadLarge 把reader切成UploadLargePartSize大小的分片，以UploadLargeConcurrency的并发上传，单个分片失败时单独重试
+func (this *FileBedClient) UploadLarge(ctx context.Context, filePath string, reader io.Reader, raw bool) (*model.FileSimpleInfo, error) {
+	var createRequest model.MultipartUploadCreateRequest
+	createRequest.Path = filePath
+	createRequest.Raw = raw
+	uploadId, err := this.CreateMultipartUpload(ctx, createRequest)
+	if err != nil {
+		return nil, err
+	}
+	var abortRequest model.MultipartUploadAbortRequest
+	abortRequest.UploadId = uploadId
+	parts, err := this.uploadLargeParts(ctx, uploadId, reader)
+	if err != nil {
+		this.AbortMultipartUpload(ctx, abortRequest)
+		return nil, err
+	}
+	var completeRequest model.MultipartUploadCompleteRequest
+	completeRequest.UploadId = uploadId
+	completeRequest.Parts = parts
+	info, err := this.CompleteMultipartUpload(ctx, completeRequest)
+	if err != nil {
+		this.AbortMultipartUpload(ctx, abortRequest)
+		return nil, err
+	}
+	return info, nil
+}
+
+// uploadLargeParts 同时在内存里的分片不超过并发数加一个
+func (this *FileBedClient) uploadLargeParts(ctx context.Context, uploadId string, reader io.Reader) ([]model.UploadPart, error) {
+	var parts []model.UploadPart
+	var uploadErr error
+	var lock sync.Mutex
+	var wait sync.WaitGroup
+	semaphore := make(chan bool, UploadLargeConcurrency)
+	for partNumber := 1; ; partNumber++ {
+		lock.Lock()
+		failed := uploadErr != nil
+		lock.Unlock()
+		if failed {
+			break
+		}
+		data := make([]byte, UploadLargePartSize)
+		n, err := io.ReadFull(reader, data)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("上传大文件，读取数据异常")
+			lock.Lock()
+			uploadErr = fmt.Errorf("上传大文件，读取数据异常: %+v", err)
+			lock.Unlock()
+			break
+		}
+		if model.MultipartMaxPartNumber < partNumber {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"partNumber": partNumber}).Error("上传大文件，分片数过多")
+			lock.Lock()
+			uploadErr = fmt.Errorf("上传大文件，分片数过多")
+			lock.Unlock()
+			break
+		}
+		data = data[:n]
+		sum := md5.Sum(data)
+		var request model.MultipartUploadPartRequest
+		request.UploadId = uploadId
+		request.PartNumber = partNumber
+		request.Md5 = hex.EncodeToString(sum[:])
+		semaphore <- true
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			defer func() { <-semaphore }()
+			part, err := this.UploadMultipartPart(ctx, request, data)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				uploadErr = err
+				return
+			}
+			parts = append(parts, *part)
+		}()
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+	wait.Wait()
+	if uploadErr != nil {
+		return nil, uploadErr
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+	return parts, nil
+}
+
+// GetUrl 接口链接，命名床通过bucket参数指定
+func (this *FileBedClient) GetUrl(ctx context.Context, path string) string {
+	url := this.getUrl(ctx, this.getAddress(ctx), path)
+	bucket := this.handler.GetBucket(ctx)
+	if bucket == "" {
+		return url
+	}
+	return url + "?" + model.BucketKey + "=" + neturl.QueryEscape(bucket)
 }
 func (this *FileBedClient) getUrl(ctx context.Context, address, path string) string {
 	if strings.HasSuffix(address, "/") && strings.HasPrefix(path, "/") && len(path) > 0 {
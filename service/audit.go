@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/dao"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	auditDefaultLimit = 100
+	auditMaxLimit     = 1000
+)
+
+// createFileAudit 从索引取文件的大小与sha256，没有索引时只取大小；删除操作需要在删除前创建
+func createFileAudit(ctx context.Context, operation, filePath string) model.AuditRecord {
+	var record model.AuditRecord
+	record.Operation = operation
+	record.Path = filePath
+	indexes, err := dao.SelectFileIndexes(ctx, []string{filePath})
+	if err != nil {
+		return record
+	}
+	if index, ok := indexes[filePath]; ok {
+		record.Size = index.Size
+		record.Hash = index.Sha256
+		return record
+	}
+	info, err := dao.SelectStorageInfo(ctx, filePath)
+	if info != nil && !info.IsDir && err == nil {
+		record.Size = info.Size
+	}
+	return record
+}
+
+// addAudit 补上请求方信息后写入审计日志，写入失败只打日志，不影响已经完成的操作
+func addAudit(ctx context.Context, record model.AuditRecord) {
+	record.LogId = util.GetLogId(ctx)
+	record.Ip, _ = util.GetCtxValue(ctx, model.ClientIpKey).(string)
+	if claims := util.GetClaims(ctx); claims != nil {
+		record.Identity = claims.ServerName
+	}
+	record.Bucket = config.GetBucketName(ctx)
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"record": record}).Info("审计记录")
+	dao.InsertAudit(ctx, record)
+}
+
+// SearchAudit 按操作时间倒序分页查询审计记录，返回下一页的游标
+func SearchAudit(ctx context.Context, request model.AuditSearchRequest) ([]model.AuditRecord, string, error) {
+	limit := request.Limit
+	if limit <= 0 {
+		limit = auditDefaultLimit
+	}
+	if auditMaxLimit < limit {
+		limit = auditMaxLimit
+	}
+	var beforeId int64
+	if request.Cursor != "" {
+		var err error
+		beforeId, err = strconv.ParseInt(request.Cursor, 10, 64)
+		if err != nil || beforeId <= 0 {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"cursor": request.Cursor, "err": err}).Error("查询审计记录，非法游标")
+			return nil, "", fmt.Errorf("查询审计记录，非法游标")
+		}
+	}
+	prefix := request.Prefix
+	if prefix != "" {
+		prefix = path.Join("/", prefix)
+	}
+
+	records, more, err := dao.SelectAudit(ctx, beforeId, limit, func(record model.AuditRecord) bool {
+		if request.StartTime > 0 && record.Time.Unix() < request.StartTime {
+			return false
+		}
+		if request.EndTime > 0 && record.Time.Unix() > request.EndTime {
+			return false
+		}
+		if request.Operation != "" && record.Operation != request.Operation {
+			return false
+		}
+		if prefix != "" && !strings.HasPrefix(record.Path, prefix) && !strings.HasPrefix(record.FromPath, prefix) {
+			return false
+		}
+		if request.Identity != "" && record.Identity != request.Identity {
+			return false
+		}
+		if request.Ip != "" && record.Ip != request.Ip {
+			return false
+		}
+		if request.LogId > 0 && record.LogId != request.LogId {
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	var nextCursor string
+	if more && len(records) > 0 {
+		nextCursor = strconv.FormatInt(records[len(records)-1].Id, 10)
+	}
+	return records, nextCursor, nil
+}
@@ -8,7 +8,7 @@ import (
 )
 
 func AddUrl(ctx context.Context, request model.UrlAddRequest) (*model.UrlAddResponse, error) {
-	object, err := service.AddUrl(ctx, request.Path, request.Url, request.Raw)
+	object, err := service.AddUrl(ctx, request.Path, request.Url, request.Raw, createFileMeta(request.Tags, request.Metadata))
 	if err != nil {
 		return nil, err
 	}
@@ -17,8 +17,8 @@ func AddUrl(ctx context.Context, request model.UrlAddRequest) (*model.UrlAddResp
 	return &response, nil
 }
 
-func AddFile(ctx context.Context, filePath string, reader io.Reader, raw bool) (*model.FileAddResponse, error) {
-	object, err := service.AddFile(ctx, filePath, reader, raw)
+func AddFile(ctx context.Context, filePath string, reader io.Reader, raw bool, tags []string, metadata map[string]string) (*model.FileAddResponse, error) {
+	object, err := service.AddFile(ctx, filePath, reader, raw, createFileMeta(tags, metadata))
 	if err != nil {
 		return nil, err
 	}
@@ -27,6 +27,24 @@ func AddFile(ctx context.Context, filePath string, reader io.Reader, raw bool) (
 	return &response, nil
 }
 
+func SetFileMeta(ctx context.Context, request model.FileMetaSetRequest) (*model.FileMetaSetResponse, error) {
+	object, err := service.SetFileMeta(ctx, request.Path, model.FileMeta{Tags: request.Tags, Metadata: request.Metadata})
+	if err != nil {
+		return nil, err
+	}
+	var response model.FileMetaSetResponse
+	response.Info = object
+	return &response, nil
+}
+
+// createFileMeta 标签与键值都没有传时返回nil，沿用被覆盖文件的元数据
+func createFileMeta(tags []string, metadata map[string]string) *model.FileMeta {
+	if tags == nil && metadata == nil {
+		return nil
+	}
+	return &model.FileMeta{Tags: tags, Metadata: metadata}
+}
+
 func RemoveFile(ctx context.Context, request model.FileRemoveRequest) (*model.FileRemoveResponse, error) {
 	object, err := service.RemoveFile(ctx, request.Path)
 	if err != nil {
@@ -48,12 +66,16 @@ func GetFileCompleteInfo(ctx context.Context, request model.FileCompleteInfoGetR
 }
 
 func ListFileSimpleInfo(ctx context.Context, request model.FileSimpleInfoListRequest) (*model.FileSimpleInfoListResponse, error) {
-	object, err := service.ListFileSimpleInfo(ctx, request.Path)
+	var response model.FileSimpleInfoListResponse
+	var err error
+	if request.Stat {
+		response.Stats, response.NextCursor, err = service.ListFileStatInfoPage(ctx, request)
+	} else {
+		response.Infos, response.NextCursor, err = service.ListFileSimpleInfoPage(ctx, request)
+	}
 	if err != nil {
 		return nil, err
 	}
-	var response model.FileSimpleInfoListResponse
-	response.Infos = object
 	return &response, nil
 }
 
@@ -66,3 +88,175 @@ func ListLastFileInfo(ctx context.Context, request model.LastFileInfoListRequest
 	response.Infos = object
 	return &response, nil
 }
+
+func RebuildFileIndex(ctx context.Context, request model.FileIndexRebuildRequest) (*model.FileIndexRebuildResponse, error) {
+	count, err := service.RebuildFileIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var response model.FileIndexRebuildResponse
+	response.Count = count
+	return &response, nil
+}
+
+func MoveFile(ctx context.Context, request model.FileMoveRequest) (*model.FileMoveResponse, error) {
+	object, err := service.MoveFile(ctx, request.FromPath, request.ToPath, request.Conflict)
+	if err != nil {
+		return nil, err
+	}
+	var response model.FileMoveResponse
+	response.Infos = object
+	return &response, nil
+}
+
+func CopyFile(ctx context.Context, request model.FileCopyRequest) (*model.FileCopyResponse, error) {
+	object, err := service.CopyFile(ctx, request.FromPath, request.ToPath, request.Conflict)
+	if err != nil {
+		return nil, err
+	}
+	var response model.FileCopyResponse
+	response.Infos = object
+	return &response, nil
+}
+
+func AddFolder(ctx context.Context, request model.FolderAddRequest) (*model.FolderAddResponse, error) {
+	object, err := service.AddFolder(ctx, request.Path)
+	if err != nil {
+		return nil, err
+	}
+	var response model.FolderAddResponse
+	response.Info = object
+	return &response, nil
+}
+
+func RemoveFolder(ctx context.Context, request model.FolderRemoveRequest) (*model.FolderRemoveResponse, error) {
+	object, trashPath, err := service.RemoveFolder(ctx, request.Path, request.DryRun)
+	if err != nil {
+		return nil, err
+	}
+	var response model.FolderRemoveResponse
+	response.Infos = object
+	response.TrashPath = trashPath
+	return &response, nil
+}
+
+func ListFileVersion(ctx context.Context, request model.FileVersionListRequest) (*model.FileVersionListResponse, error) {
+	object, err := service.ListFileVersion(ctx, request.Path)
+	if err != nil {
+		return nil, err
+	}
+	var response model.FileVersionListResponse
+	response.Versions = object
+	return &response, nil
+}
+
+func RestoreFileVersion(ctx context.Context, request model.FileVersionRestoreRequest) (*model.FileVersionRestoreResponse, error) {
+	object, err := service.RestoreFileVersion(ctx, request.Path, request.VersionId)
+	if err != nil {
+		return nil, err
+	}
+	var response model.FileVersionRestoreResponse
+	response.Info = object
+	return &response, nil
+}
+
+func ListQuotaUsage(ctx context.Context, request model.QuotaUsageListRequest) (*model.QuotaUsageListResponse, error) {
+	object, err := service.ListQuotaUsage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var response model.QuotaUsageListResponse
+	response.Usages = object
+	return &response, nil
+}
+
+func GetScrubReport(ctx context.Context, request model.ScrubReportGetRequest) (*model.ScrubReportGetResponse, error) {
+	object, err := service.GetScrubReport(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var response model.ScrubReportGetResponse
+	response.Report = object
+	return &response, nil
+}
+
+func ReencryptFile(ctx context.Context, request model.FileReencryptRequest) (*model.FileReencryptResponse, error) {
+	err := service.ReencryptFile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var response model.FileReencryptResponse
+	return &response, nil
+}
+
+func CreateMultipartUpload(ctx context.Context, request model.MultipartUploadCreateRequest) (*model.MultipartUploadCreateResponse, error) {
+	object, err := service.CreateMultipartUpload(ctx, request.Path, request.Raw)
+	if err != nil {
+		return nil, err
+	}
+	var response model.MultipartUploadCreateResponse
+	response.UploadId = object.Id
+	return &response, nil
+}
+
+func UploadMultipartPart(ctx context.Context, request model.MultipartUploadPartRequest, reader io.Reader) (*model.MultipartUploadPartResponse, error) {
+	object, err := service.WriteMultipartUpload(ctx, request.UploadId, request.PartNumber, request.Md5, reader)
+	if err != nil {
+		return nil, err
+	}
+	var response model.MultipartUploadPartResponse
+	response.Part = object
+	return &response, nil
+}
+
+func CompleteMultipartUpload(ctx context.Context, request model.MultipartUploadCompleteRequest) (*model.MultipartUploadCompleteResponse, error) {
+	object, err := service.CompleteMultipartUpload(ctx, request.UploadId, request.Parts)
+	if err != nil {
+		return nil, err
+	}
+	var response model.MultipartUploadCompleteResponse
+	response.Info = object
+	return &response, nil
+}
+
+func AbortMultipartUpload(ctx context.Context, request model.MultipartUploadAbortRequest) (*model.MultipartUploadAbortResponse, error) {
+	err := service.RemoveUpload(ctx, request.UploadId)
+	if err != nil {
+		return nil, err
+	}
+	var response model.MultipartUploadAbortResponse
+	return &response, nil
+}
+
+func SearchFile(ctx context.Context, request model.FileSearchRequest) (*model.FileSearchResponse, error) {
+	infos, nextCursor, err := service.SearchFile(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	var response model.FileSearchResponse
+	response.Infos = infos
+	response.NextCursor = nextCursor
+	return &response, nil
+}
+
+func ListFileHistory(ctx context.Context, request model.FileHistoryListRequest) (*model.FileHistoryListResponse, error) {
+	histories, nextCursor, err := service.ListFileHistory(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	var response model.FileHistoryListResponse
+	response.Histories = histories
+	response.NextCursor = nextCursor
+	return &response, nil
+}
+
+func SearchAudit(ctx context.Context, request model.AuditSearchRequest) (*model.AuditSearchResponse, error) {
+	records, nextCursor, err := service.SearchAudit(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	var response model.AuditSearchResponse
+	response.Records = records
+	response.NextCursor = nextCursor
+	return &response, nil
+}
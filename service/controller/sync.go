@@ -7,7 +7,7 @@ import (
 )
 
 func PushSyncFile(ctx context.Context, request model.PushSyncFileRequest) (*model.PushSyncFileResponse, error) {
-	err := service.PushSyncFile(ctx, request.Address, request.Secret, request.Path)
+	err := service.PushSyncFile(ctx, request.Address, request.Secret, request.RemoteBucket, request.Path, request.HashType)
 	if err != nil {
 		return nil, err
 	}
@@ -16,7 +16,7 @@ func PushSyncFile(ctx context.Context, request model.PushSyncFileRequest) (*mode
 }
 
 func PullSyncFile(ctx context.Context, request model.PullSyncFileRequest) (*model.PullSyncFileResponse, error) {
-	err := service.PullSyncFile(ctx, request.Address, request.Secret, request.Path)
+	err := service.PullSyncFile(ctx, request.Address, request.Secret, request.RemoteBucket, request.Path, request.HashType)
 	if err != nil {
 		return nil, err
 	}
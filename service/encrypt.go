@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/dao"
+	"github.com/sirupsen/logrus"
+	"sync"
+)
+
+// reencrypting 正在后台重新加密的床
+var reencrypting = make(map[string]bool)
+var reencryptLock sync.Mutex
+
+// ReencryptFile 轮换密钥后在后台重新加密所在床的全部文件
+func ReencryptFile(ctx context.Context) error {
+	if len(config.Config.EncryptKeys) == 0 {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{}).Error("重新加密文件，未开启加密")
+		return fmt.Errorf("重新加密文件，未开启加密")
+	}
+	bucket := config.GetBucketName(ctx)
+	reencryptLock.Lock()
+	defer reencryptLock.Unlock()
+	if reencrypting[bucket] {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"bucket": bucket}).Error("重新加密文件，上次重新加密未完成")
+		return fmt.Errorf("重新加密文件，上次重新加密未完成")
+	}
+	reencrypting[bucket] = true
+	go func() {
+		defer func() {
+			reencryptLock.Lock()
+			defer reencryptLock.Unlock()
+			delete(reencrypting, bucket)
+		}()
+		dao.ReencryptFile(config.SetBucket(util.GenCtx(), bucket))
+	}()
+	return nil
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"context"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/dao"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+)
+
+type fileEventHandler func(ctx context.Context, event model.FileEvent)
+
+var fileEventHandlers = []fileEventHandler{handleHistoryEvent}
+
+// InitWatch 开启监听时，外部直接改动床文件夹也产生与接口一致的文件事件
+func InitWatch(ctx context.Context) {
+	if !config.Config.WatchEnable {
+		return
+	}
+	buckets := config.ListBucket()
+	for i := range buckets {
+		err := dao.WatchFile(config.SetBucket(ctx, buckets[i].Name), publishFileEvent)
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"bucket": buckets[i].Name, "err": err}).Error("监听文件夹，启动异常")
+		}
+	}
+}
+
+func publishFileEvent(ctx context.Context, event model.FileEvent) {
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"event": event}).Info("文件事件")
+	for i := range fileEventHandlers {
+		fileEventHandlers[i](ctx, event)
+	}
+}
@@ -14,17 +14,19 @@ import (
 	"net/http"
 	"path"
 	"strings"
-	"sync"
 )
 
 const (
 	userAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_5) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/98.0.4758.102 Safari/537.36"
 )
 
-var lastFileInfos []model.FileSimpleInfo
-var lastFileInfoLock sync.Mutex
+// AddUrl meta为nil时沿用被覆盖文件的元数据
+func AddUrl(ctx context.Context, filePath string, url string, raw bool, meta *model.FileMeta) (*model.FileSimpleInfo, error) {
+	return addUrl(ctx, model.AuditAddUrl, filePath, url, raw, meta)
+}
 
-func AddUrl(ctx context.Context, filePath string, url string, raw bool) (*model.FileSimpleInfo, error) {
+// addUrl operation为审计记录的操作
+func addUrl(ctx context.Context, operation, filePath string, url string, raw bool, meta *model.FileMeta) (*model.FileSimpleInfo, error) {
 	if url == "" {
 		logrus.WithContext(ctx).WithFields(logrus.Fields{}).Error("添加链接，文件下载连接为空")
 		return nil, fmt.Errorf("添加链接，文件下载连接为空")
@@ -62,16 +64,30 @@ func AddUrl(ctx context.Context, filePath string, url string, raw bool) (*model.
 		return nil, fmt.Errorf("添加链接，http响应码失败: %+v", statusCode)
 	}
 
-	return AddFile(ctx, filePath, response.Body, raw)
+	return addFile(ctx, operation, filePath, response.Body, raw, meta)
 }
 
 //func AddTmpFile(ctx context.Context, reader io.Reader) (*model.FileSimpleInfo, error) {
 //	return AddFile(ctx, path.Join(".tmp", strconv.Itoa(int(util.GenId()))), reader, true)
 //}
 
-func AddFile(ctx context.Context, filePath string, reader io.Reader, raw bool) (*model.FileSimpleInfo, error) {
+// AddFile meta为nil时沿用被覆盖文件的元数据
+func AddFile(ctx context.Context, filePath string, reader io.Reader, raw bool, meta *model.FileMeta) (*model.FileSimpleInfo, error) {
+	return addFile(ctx, model.AuditAddFile, filePath, reader, raw, meta)
+}
+
+// addFile operation为审计记录的操作
+func addFile(ctx context.Context, operation, filePath string, reader io.Reader, raw bool, meta *model.FileMeta) (*model.FileSimpleInfo, error) {
 	filePath = util.ClearPath(ctx, path.Join("/", filePath))
-	logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath}).Info("删除文件")
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath}).Info("添加文件")
+	if filePath == "/" {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath}).Error("添加文件，路径为根目录")
+		return nil, fmt.Errorf("添加文件，路径为根目录: %w", model.ErrInvalidPath)
+	}
+	if model.IsReservedPath(filePath) {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath}).Error("添加文件，路径为保留路径")
+		return nil, fmt.Errorf("添加文件，路径为保留路径: %w", model.ErrReservedPath)
+	}
 
 	if !strings.HasPrefix(filePath, model.TrashPath) {
 		fileExt := path.Ext(filePath)
@@ -96,23 +112,175 @@ func AddFile(ctx context.Context, filePath string, reader io.Reader, raw bool) (
 				filePath = AddImageExtension(ctx, filePath)
 			}
 		}
+	}
 
-		_, err = RemoveFile(ctx, filePath)
+	if meta == nil {
+		var err error
+		meta, err = dao.SelectFileMeta(ctx, filePath)
 		if err != nil {
 			return nil, err
 		}
 	}
-
-	info, err := dao.InsertFile(ctx, filePath, reader)
+	oldInfo, err := dao.SelectStorageInfo(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := insertFile(ctx, filePath, reader)
 	if err != nil {
 		return nil, err
 	}
+	if meta != nil && !meta.IsEmpty() {
+		err = dao.UpdateFileMeta(ctx, info.Path, model.NormalizeFileMeta(*meta))
+		if err != nil {
+			return nil, err
+		}
+	}
 	info = initFileSimpleInfo(ctx, info)
-	addLastFileInfo(ctx, info)
+	publishFileEvent(ctx, model.FileEvent{Type: model.FileEventAdd, Path: info.Path, Source: model.FileEventSourceApi})
+	record := createFileAudit(ctx, operation, info.Path)
+	record.Overwrite = oldInfo != nil
+	addAudit(ctx, record)
 	return info, err
 }
 
+// SetFileMeta 整体替换文件的标签与自定义键值
+func SetFileMeta(ctx context.Context, filePath string, meta model.FileMeta) (*model.FileCompleteInfo, error) {
+	return setFileMeta(ctx, model.AuditSetFileMeta, filePath, meta)
+}
+
+func setFileMeta(ctx context.Context, operation, filePath string, meta model.FileMeta) (*model.FileCompleteInfo, error) {
+	filePath = util.ClearPath(ctx, path.Join("/", filePath))
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath, "meta": meta}).Info("设置文件元数据")
+	if model.IsReservedPath(filePath) {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath}).Error("设置文件元数据，路径为保留路径")
+		return nil, fmt.Errorf("设置文件元数据，路径为保留路径")
+	}
+	err := dao.UpdateFileMeta(ctx, filePath, model.NormalizeFileMeta(meta))
+	if err != nil {
+		return nil, err
+	}
+	addAudit(ctx, createFileAudit(ctx, operation, filePath))
+	return GetFileCompleteInfo(ctx, filePath)
+}
+
+// insertFile 写入失败时不影响原文件；开启版本或回收站时，新内容先完整写入暂存路径，再把原文件移走并替换
+func insertFile(ctx context.Context, filePath string, reader io.Reader) (*model.FileSimpleInfo, error) {
+	defer lockQuota(ctx, filePath)()
+	oldInfo, err := GetFileSimpleInfo(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if oldInfo != nil && !oldInfo.IsFile {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath}).Error("添加文件，路径为文件夹")
+		return nil, fmt.Errorf("添加文件，路径为文件夹: %w", model.ErrPathConflict)
+	}
+	if oldInfo == nil {
+		err = checkParentFolder(ctx, filePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	quotaReader, err := checkQuota(ctx, filePath, oldInfo, reader)
+	if err != nil {
+		return nil, err
+	}
+	if oldInfo == nil || strings.HasPrefix(filePath, model.TrashPath) || (!config.GetBucket(ctx).TrashEnable && !config.Config.VersionEnable()) {
+		info, err := dao.InsertFile(ctx, filePath, quotaReader)
+		return info, wrapQuotaError(quotaReader, err)
+	}
+
+	stagePath := createStagePath(ctx, filePath)
+	_, err = dao.InsertFile(ctx, stagePath, quotaReader)
+	if err != nil {
+		dao.DeleteFile(ctx, stagePath)
+		return nil, wrapQuotaError(quotaReader, err)
+	}
+	err = replaceStageFile(ctx, stagePath, filePath, replaceFile)
+	if err != nil {
+		dao.DeleteFile(ctx, stagePath)
+		return nil, err
+	}
+	return dao.SelectFileSimpleInfo(ctx, filePath)
+}
+
+// createStagePath 替换原文件前，新内容先放到的暂存路径
+func createStagePath(ctx context.Context, filePath string) string {
+	return path.Join(model.TmpPath, "stage", util.GetLogIdString(ctx), path.Base(filePath))
+}
+
+// replaceStageFile 先用replace移走原文件，再把暂存路径的新内容移到原路径；
+// replace为nil时原路径没有文件，移动失败时把移走的原文件移回去，暂存文件留给调用方处理
+func replaceStageFile(ctx context.Context, stagePath, filePath string, replace func(ctx context.Context, filePath string) (string, error)) error {
+	var oldPath string
+	var err error
+	if replace != nil {
+		oldPath, err = replace(ctx, filePath)
+		if err != nil {
+			return err
+		}
+	}
+	err = dao.MoveFile(ctx, stagePath, filePath)
+	if err != nil {
+		restoreReplacedFile(ctx, oldPath, filePath)
+		return err
+	}
+	return nil
+}
+
+// checkParentFolder 从根目录往下检查上级路径，遇到不存在的路径为止，中间是文件时无法在其下写入
+func checkParentFolder(ctx context.Context, filePath string) error {
+	names := strings.Split(strings.Trim(path.Dir(filePath), "/"), "/")
+	parentPath := "/"
+	for i := range names {
+		if names[i] == "" {
+			return nil
+		}
+		parentPath = path.Join(parentPath, names[i])
+		info, err := GetFileSimpleInfo(ctx, parentPath)
+		if info == nil || err != nil {
+			return err
+		}
+		if info.IsFile {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath, "parentPath": parentPath}).Error("添加文件，上级路径为文件")
+			return fmt.Errorf("添加文件，上级路径为文件: %+v: %w", parentPath, model.ErrPathConflict)
+		}
+	}
+	return nil
+}
+
+// replaceFile 被覆盖的文件，开启版本时存为历史版本，否则按删除处理；
+// 返回原文件被移到的历史版本或回收站路径，直接删除时为空
+func replaceFile(ctx context.Context, filePath string) (string, error) {
+	if config.Config.VersionEnable() && !strings.HasPrefix(filePath, model.TrashPath) {
+		return saveFileVersion(ctx, filePath)
+	}
+	_, err := removeFile(ctx, "", filePath)
+	if err != nil || !config.GetBucket(ctx).TrashEnable || strings.HasPrefix(filePath, model.TrashPath) {
+		return "", err
+	}
+	//与removeFile移入回收站的路径一致
+	return genTrashPath(ctx, filePath), nil
+}
+
+// restoreReplacedFile 新内容没能放到原路径时，把replaceFile移走的原文件移回去
+func restoreReplacedFile(ctx context.Context, oldPath, filePath string) {
+	if oldPath == "" {
+		return
+	}
+	err := dao.MoveFile(ctx, oldPath, filePath)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"oldPath": oldPath, "filePath": filePath, "err": err}).Error("覆盖文件失败，移回原文件异常")
+		return
+	}
+	publishFileEvent(ctx, model.FileEvent{Type: model.FileEventAdd, Path: filePath, Source: model.FileEventSourceApi})
+}
+
 func RemoveFile(ctx context.Context, filePath string) (*model.FileSimpleInfo, error) {
+	return removeFile(ctx, model.AuditRemoveFile, filePath)
+}
+
+// removeFile operation为审计记录的操作，为空时不记录，用于覆盖写入时移走原文件
+func removeFile(ctx context.Context, operation, filePath string) (*model.FileSimpleInfo, error) {
 	filePath = util.ClearPath(ctx, path.Join("/", filePath))
 	logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath}).Info("删除文件")
 
@@ -122,15 +290,23 @@ func RemoveFile(ctx context.Context, filePath string) (*model.FileSimpleInfo, er
 	}
 	if !info.IsFile {
 		logrus.WithContext(ctx).WithFields(logrus.Fields{}).Info("删除文件，不允许删除文件夹")
-		return nil, fmt.Errorf("删除文件，不允许删除文件夹")
+		return nil, fmt.Errorf("删除文件，不允许删除文件夹: %w", model.ErrPathConflict)
+	}
+	var record model.AuditRecord
+	if operation != "" {
+		record = createFileAudit(ctx, operation, filePath)
 	}
 
-	if !config.Config.TrashEnable || strings.HasPrefix(filePath, model.TrashPath) {
+	if !config.GetBucket(ctx).TrashEnable || strings.HasPrefix(filePath, model.TrashPath) {
 		info, err := dao.DeleteFile(ctx, filePath)
 		if err != nil {
 			return nil, err
 		}
 		info = initFileSimpleInfo(ctx, info)
+		publishFileEvent(ctx, model.FileEvent{Type: model.FileEventRemove, Path: filePath, Source: model.FileEventSourceApi})
+		if operation != "" {
+			addAudit(ctx, record)
+		}
 		return info, err
 	}
 
@@ -138,6 +314,10 @@ func RemoveFile(ctx context.Context, filePath string) (*model.FileSimpleInfo, er
 
 	err = dao.MoveFile(ctx, filePath, trashPath)
 	if err == nil {
+		publishFileEvent(ctx, model.FileEvent{Type: model.FileEventRemove, Path: filePath, Source: model.FileEventSourceApi})
+		if operation != "" {
+			addAudit(ctx, record)
+		}
 		return info, nil
 	}
 	dao.MoveFile(ctx, trashPath, filePath)
@@ -153,6 +333,23 @@ func GetFileSimpleInfo(ctx context.Context, fileOrFolderPath string) (*model.Fil
 	return info, err
 }
 
+func GetStorageInfo(ctx context.Context, fileOrFolderPath string) (*model.StorageInfo, error) {
+	fileOrFolderPath = util.ClearPath(ctx, path.Join("/", fileOrFolderPath))
+	if model.IsReservedPath(fileOrFolderPath) {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"fileOrFolderPath": fileOrFolderPath}).Warn("查询存储信息，路径为保留路径")
+		return nil, nil
+	}
+	return dao.SelectStorageInfo(ctx, fileOrFolderPath)
+}
+
+func GetReadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	return dao.GetReadFile(ctx, filePath)
+}
+
+func GetGzipReadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	return dao.GetGzipReadFile(ctx, filePath)
+}
+
 func GetFileCompleteInfo(ctx context.Context, fileOrFolderPath string) (*model.FileCompleteInfo, error) {
 	info, err := dao.SelectFileCompleteInfo(ctx, fileOrFolderPath)
 	if err != nil {
@@ -171,6 +368,23 @@ func ListFileSimpleInfo(ctx context.Context, folderPath string) ([]model.FileSim
 	return infos, err
 }
 
+// WalkManifest 逐个遍历路径下全部文件的清单，用于流式输出
+func WalkManifest(ctx context.Context, fileOrFolderPath string, handle func(entry model.ManifestEntry) error) error {
+	return dao.WalkManifest(ctx, fileOrFolderPath, handle)
+}
+
+func ListManifest(ctx context.Context, fileOrFolderPath string) ([]model.ManifestEntry, error) {
+	var entries []model.ManifestEntry
+	err := dao.WalkManifest(ctx, fileOrFolderPath, func(entry model.ManifestEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
 func ListFileCompleteInfo(ctx context.Context, folderPath string) ([]model.FileCompleteInfo, error) {
 	infos, err := dao.SelectFolderCompleteInfo(ctx, folderPath)
 	if err != nil {
@@ -180,28 +394,8 @@ func ListFileCompleteInfo(ctx context.Context, folderPath string) ([]model.FileC
 	return infos, err
 }
 
-func ListLastFileInfo(ctx context.Context) ([]model.FileSimpleInfo, error) {
-	return lastFileInfos, nil
-}
-
-func addLastFileInfo(ctx context.Context, info *model.FileSimpleInfo) {
-	if info == nil {
-		return
-	}
-	lastFileInfoLock.Lock()
-	defer lastFileInfoLock.Unlock()
-
-	if len(lastFileInfos) < config.Config.LastFileCount {
-		lastFileInfos = append([]model.FileSimpleInfo{*info}, lastFileInfos...)
-		return
-	}
-
-	infos := lastFileInfos
-	for i := len(infos) - 1; i > 0; i-- {
-		infos[i] = infos[i-1]
-	}
-	infos[0] = *info
-	lastFileInfos = infos
+func RebuildFileIndex(ctx context.Context) (int, error) {
+	return dao.RebuildFileIndex(ctx)
 }
 
 func initFileCompleteInfos(ctx context.Context, infos []model.FileCompleteInfo) []model.FileCompleteInfo {
@@ -234,6 +428,11 @@ func initFileSimpleInfo(ctx context.Context, info *model.FileSimpleInfo) *model.
 	return info
 }
 
+// IsReservedPath 路径是否为内部使用的保留路径
+func IsReservedPath(ctx context.Context, filePath string) bool {
+	return model.IsReservedPath(util.ClearPath(ctx, path.Join("/", filePath)))
+}
+
 func createUrl(ctx context.Context, filePath string) string {
-	return util.ClearPath(ctx, path.Join(model.FileUrl, filePath))
+	return util.ClearPath(ctx, model.CreateBucketFileUrl(config.GetBucketName(ctx), filePath))
 }
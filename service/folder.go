@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/dao"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+	"path"
+	"strings"
+)
+
+func AddFolder(ctx context.Context, folderPath string) (*model.FileSimpleInfo, error) {
+	folderPath = util.ClearPath(ctx, path.Join("/", folderPath))
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"folderPath": folderPath}).Info("添加文件夹")
+	if folderPath == "/" || model.IsReservedPath(folderPath) {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"folderPath": folderPath}).Error("添加文件夹，路径为保留路径")
+		return nil, fmt.Errorf("添加文件夹，路径为保留路径: %w", model.ErrReservedPath)
+	}
+	info, err := GetFileSimpleInfo(ctx, folderPath)
+	if err != nil {
+		return nil, err
+	}
+	if info != nil {
+		if info.IsFile {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"folderPath": folderPath}).Error("添加文件夹，路径为文件")
+			return nil, fmt.Errorf("添加文件夹，路径为文件: %w", model.ErrPathConflict)
+		}
+		return info, nil
+	}
+	info, err = dao.InsertFolder(ctx, folderPath)
+	if err != nil {
+		return nil, err
+	}
+	addAudit(ctx, model.AuditRecord{Operation: model.AuditAddFolder, Path: folderPath})
+	return initFileSimpleInfo(ctx, info), nil
+}
+
+// RemoveFolder 递归删除文件夹，开启回收站时整个文件夹作为一个整体移入回收站，dryRun时只返回将被删除的文件
+func RemoveFolder(ctx context.Context, folderPath string, dryRun bool) ([]model.FileSimpleInfo, string, error) {
+	return removeFolder(ctx, model.AuditRemoveFolder, folderPath, dryRun)
+}
+
+// removeFolder operation为审计记录的操作
+func removeFolder(ctx context.Context, operation, folderPath string, dryRun bool) ([]model.FileSimpleInfo, string, error) {
+	folderPath = util.ClearPath(ctx, path.Join("/", folderPath))
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"folderPath": folderPath, "dryRun": dryRun}).Info("删除文件夹")
+	if folderPath == "/" || model.IsReservedPath(folderPath) {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"folderPath": folderPath}).Error("删除文件夹，路径为保留路径")
+		return nil, "", fmt.Errorf("删除文件夹，路径为保留路径: %w", model.ErrReservedPath)
+	}
+	info, err := GetFileSimpleInfo(ctx, folderPath)
+	if info == nil || err != nil {
+		return nil, "", err
+	}
+	if info.IsFile {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"folderPath": folderPath}).Error("删除文件夹，路径为文件")
+		return nil, "", fmt.Errorf("删除文件夹，路径为文件: %w", model.ErrPathConflict)
+	}
+
+	var infos []model.FileSimpleInfo
+	err = listAllFileSimpleInfo(ctx, folderPath, &infos)
+	if err != nil {
+		return nil, "", err
+	}
+	var trashPath string
+	if config.GetBucket(ctx).TrashEnable && !strings.HasPrefix(folderPath, model.TrashPath) {
+		trashPath = genTrashPath(ctx, folderPath)
+	}
+	if dryRun {
+		return infos, trashPath, nil
+	}
+
+	if trashPath == "" {
+		err = dao.DeleteFolder(ctx, folderPath)
+	} else {
+		err = dao.MoveFile(ctx, folderPath, trashPath)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	publishFileEvent(ctx, model.FileEvent{Type: model.FileEventRemove, Path: folderPath, Source: model.FileEventSourceApi})
+	addAudit(ctx, model.AuditRecord{Operation: operation, Path: folderPath})
+	return infos, trashPath, nil
+}
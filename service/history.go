@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/dao"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	historyDefaultLimit = 100
+	historyMaxLimit     = 1000
+)
+
+// handleHistoryEvent 文件事件写入上传历史，回收站里的文件不记录
+func handleHistoryEvent(ctx context.Context, event model.FileEvent) {
+	if strings.HasPrefix(event.Path, model.TrashPath) && event.Type != model.FileEventMove {
+		return
+	}
+	var history model.FileHistory
+	history.Type = event.Type
+	history.Path = event.Path
+	history.FromPath = event.FromPath
+	if event.Type == model.FileEventAdd {
+		indexes, err := dao.SelectFileIndexes(ctx, []string{event.Path})
+		if err != nil {
+			return
+		}
+		if index, ok := indexes[event.Path]; ok {
+			history.Uploader = index.Uploader
+			history.Size = index.Size
+		} else {
+			info, err := dao.SelectStorageInfo(ctx, event.Path)
+			if info == nil || info.IsDir || err != nil {
+				return
+			}
+			history.Size = info.Size
+		}
+	}
+	dao.InsertFileHistory(ctx, history)
+}
+
+// ListFileHistory 按上传时间倒序分页查询仍然存在的上传记录，返回下一页的游标
+func ListFileHistory(ctx context.Context, request model.FileHistoryListRequest) ([]model.FileHistory, string, error) {
+	limit := request.Limit
+	if limit <= 0 {
+		limit = historyDefaultLimit
+	}
+	if historyMaxLimit < limit {
+		limit = historyMaxLimit
+	}
+	var beforeId int64
+	if request.Cursor != "" {
+		var err error
+		beforeId, err = strconv.ParseInt(request.Cursor, 10, 64)
+		if err != nil || beforeId <= 0 {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"cursor": request.Cursor, "err": err}).Error("查询上传历史，非法游标")
+			return nil, "", fmt.Errorf("查询上传历史，非法游标")
+		}
+	}
+	prefix := request.Prefix
+	if prefix != "" {
+		prefix = path.Join("/", prefix)
+	}
+
+	histories, more, err := dao.SelectFileHistory(ctx, beforeId, limit, func(history model.FileHistory) bool {
+		if request.StartTime > 0 && history.Time.Unix() < request.StartTime {
+			return false
+		}
+		if request.EndTime > 0 && history.Time.Unix() > request.EndTime {
+			return false
+		}
+		if prefix != "" && !strings.HasPrefix(history.Path, prefix) {
+			return false
+		}
+		if request.Uploader != "" && history.Uploader != request.Uploader {
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	for i := range histories {
+		histories[i].Url = createUrl(ctx, histories[i].Path)
+	}
+	var nextCursor string
+	if more && len(histories) > 0 {
+		nextCursor = strconv.FormatInt(histories[len(histories)-1].Id, 10)
+	}
+	return histories, nextCursor, nil
+}
+
+// ListLastFileInfo 最近上传的LastFileCount个文件
+func ListLastFileInfo(ctx context.Context) ([]model.FileSimpleInfo, error) {
+	var request model.FileHistoryListRequest
+	request.Limit = config.Config.LastFileCount
+	histories, _, err := ListFileHistory(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]model.FileSimpleInfo, 0, len(histories))
+	for i := range histories {
+		var info model.FileSimpleInfo
+		info.Path = histories[i].Path
+		info.Name = path.Base(histories[i].Path)
+		info.IsFile = true
+		info.Url = histories[i].Url
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
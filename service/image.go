@@ -7,11 +7,31 @@ import (
 	"github.com/cellargalaxy/go_file_bed/config"
 	"github.com/disintegration/imaging"
 	"github.com/sirupsen/logrus"
+	"image"
+	"io"
 	"math"
 )
 
+// imageHeaderSize 解析图片宽高最多读取的字节数
+const imageHeaderSize = 64 * 1024
+
 func AddImageExtension(ctx context.Context, filePath string) string {
-	return fmt.Sprintf("%s.%+v", filePath, config.Config.ImageSaveFormat)
+	return fmt.Sprintf("%s.%+v", filePath, config.GetBucket(ctx).ImageSaveFormat)
+}
+
+// getImageSize 只读取图片头部解析宽高，无法解析时返回0
+func getImageSize(ctx context.Context, filePath string) (int, int) {
+	reader, err := GetReadFile(ctx, filePath)
+	if err != nil {
+		return 0, 0
+	}
+	defer reader.Close()
+	config, _, err := image.DecodeConfig(io.LimitReader(reader, imageHeaderSize))
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath, "err": err}).Warn("解析图片宽高，异常")
+		return 0, 0
+	}
+	return config.Width, config.Height
 }
 
 func CompressionImage(ctx context.Context, buffer *bytes.Buffer) (*bytes.Buffer, error) {
@@ -26,7 +46,7 @@ func CompressionImage(ctx context.Context, buffer *bytes.Buffer) (*bytes.Buffer,
 	encodeOption := createJPEGQuality(ctx, imageSize)
 
 	newBuffer := &bytes.Buffer{}
-	err = imaging.Encode(newBuffer, img, config.Config.ImageSaveFormat, encodeOption)
+	err = imaging.Encode(newBuffer, img, config.GetBucket(ctx).ImageSaveFormat, encodeOption)
 	if err != nil {
 		logrus.WithContext(ctx).WithFields(logrus.Fields{"err": err}).Error("压缩图片，图片压缩异常")
 		return nil, fmt.Errorf("压缩图片，图片压缩异常: %+v", err)
@@ -42,9 +62,10 @@ func CompressionImage(ctx context.Context, buffer *bytes.Buffer) (*bytes.Buffer,
 // ratio    0.999497609  0.99  0.975187187  0.949843809  0.773145054  0.597753274  0.21358261   0.076314984  0.005823977
 // quality  79.96985657  79.4  78.51123123  76.99062854  66.38870321  55.86519643  32.81495663  24.57889903  20.34943861
 func createJPEGQuality(ctx context.Context, size int) imaging.EncodeOption {
-	power := float64(size) / config.Config.ImageTargetSize
+	bucket := config.GetBucket(ctx)
+	power := float64(size) / bucket.ImageTargetSize
 	qualityRatio := math.Pow(0.99, power)
-	quality := int(config.Config.JpegMinQuality + (config.Config.JpegMaxQuality-config.Config.JpegMinQuality)*qualityRatio)
+	quality := int(bucket.JpegMinQuality + (bucket.JpegMaxQuality-bucket.JpegMinQuality)*qualityRatio)
 	logrus.WithContext(ctx).WithFields(logrus.Fields{"power": power, "qualityRatio": qualityRatio, "quality": quality}).Info("JPEG图片质量")
 	return imaging.JPEGQuality(quality)
 }
@@ -0,0 +1,255 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/dao"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+	"mime"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// listCursor 上一页最后一项的排序键，下一页从排在它之后的项开始
+type listCursor struct {
+	Sort    string `json:"s"`
+	Name    string `json:"n"`
+	Size    int64  `json:"z"`
+	ModTime int64  `json:"t"`
+}
+
+// ListFileSimpleInfoPage 按请求过滤、排序并分页罗列文件夹，返回下一页的游标
+func ListFileSimpleInfoPage(ctx context.Context, request model.FileSimpleInfoListRequest) ([]model.FileSimpleInfo, string, error) {
+	list, nextCursor, err := listStorageInfoPage(ctx, request)
+	if err != nil {
+		return nil, "", err
+	}
+	infos := make([]model.FileSimpleInfo, 0, len(list))
+	for i := range list {
+		infos = append(infos, createListSimpleInfo(list[i]))
+	}
+	infos = initFileSimpleInfos(ctx, infos)
+	return infos, nextCursor, nil
+}
+
+// ListFileStatInfoPage 与ListFileSimpleInfoPage相同，但带上大小、修改时间、MIME与图片宽高
+func ListFileStatInfoPage(ctx context.Context, request model.FileSimpleInfoListRequest) ([]model.FileStatInfo, string, error) {
+	list, nextCursor, err := listStorageInfoPage(ctx, request)
+	if err != nil {
+		return nil, "", err
+	}
+	filePaths := make([]string, 0, len(list))
+	for i := range list {
+		if !list[i].IsDir {
+			filePaths = append(filePaths, list[i].Path)
+		}
+	}
+	indexes, err := dao.SelectFileIndexes(ctx, filePaths)
+	if err != nil {
+		return nil, "", err
+	}
+	infos := make([]model.FileStatInfo, 0, len(list))
+	for i := range list {
+		var info model.FileStatInfo
+		info.FileSimpleInfo = createListSimpleInfo(list[i])
+		info.Url = createUrl(ctx, info.Path)
+		info.ModTime = list[i].ModTime
+		if list[i].IsDir {
+			infos = append(infos, info)
+			continue
+		}
+		info.Size = list[i].Size
+		index, ok := indexes[list[i].Path]
+		if ok {
+			info.MimeType = index.Mime
+			info.Width = index.Width
+			info.Height = index.Height
+			info.FileMeta = index.FileMeta
+		} else {
+			//没有索引时按扩展名识别MIME，图片只读取头部解析宽高
+			info.MimeType = mime.TypeByExtension(path.Ext(info.Name))
+			if strings.HasPrefix(info.MimeType, "image/") {
+				info.Width, info.Height = getImageSize(ctx, info.Path)
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos, nextCursor, nil
+}
+
+// listStorageInfoPage 返回的Path为文件在床里的路径；路径为文件时只返回该文件
+func listStorageInfoPage(ctx context.Context, request model.FileSimpleInfoListRequest) ([]model.StorageInfo, string, error) {
+	folderPath := util.ClearPath(ctx, path.Join("/", request.Path))
+	if request.Sort == "" {
+		request.Sort = model.ListSortName
+	}
+	if request.Sort != model.ListSortName && request.Sort != model.ListSortSize && request.Sort != model.ListSortMtime {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"sort": request.Sort}).Error("罗列文件，非法排序字段")
+		return nil, "", fmt.Errorf("罗列文件，非法排序字段")
+	}
+	if request.Glob != "" {
+		_, err := path.Match(request.Glob, "")
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"glob": request.Glob, "err": err}).Error("罗列文件，非法glob")
+			return nil, "", fmt.Errorf("罗列文件，非法glob: %+v", err)
+		}
+	}
+	var cursor *model.StorageInfo
+	if request.Cursor != "" {
+		var err error
+		cursor, err = parseListCursor(ctx, request.Cursor, request.Sort)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	if model.IsReservedPath(folderPath) {
+		return nil, "", nil
+	}
+	pathInfo, err := dao.SelectStorageInfo(ctx, folderPath)
+	if pathInfo == nil || err != nil {
+		return nil, "", err
+	}
+	if !pathInfo.IsDir {
+		pathInfo.Path = folderPath
+		pathInfo.Name = path.Base(folderPath)
+		return []model.StorageInfo{*pathInfo}, "", nil
+	}
+	storageInfos, err := dao.SelectFolderStorageInfo(ctx, folderPath)
+	if err != nil {
+		return nil, "", err
+	}
+	list := make([]model.StorageInfo, 0, len(storageInfos))
+	for i := range storageInfos {
+		storageInfos[i].Path = path.Join(folderPath, storageInfos[i].Name)
+		if model.IsReservedPath(storageInfos[i].Path) || !matchListRequest(request, storageInfos[i]) {
+			continue
+		}
+		list = append(list, storageInfos[i])
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return compareListInfo(request, list[i], list[j]) < 0
+	})
+	if cursor != nil {
+		index := sort.Search(len(list), func(i int) bool {
+			return compareListInfo(request, list[i], *cursor) > 0
+		})
+		list = list[index:]
+	}
+	var nextCursor string
+	if request.Limit > 0 && request.Limit < len(list) {
+		list = list[:request.Limit]
+		nextCursor = createListCursor(request.Sort, list[len(list)-1])
+	}
+	return list, nextCursor, nil
+}
+
+func createListSimpleInfo(info model.StorageInfo) model.FileSimpleInfo {
+	var simpleInfo model.FileSimpleInfo
+	simpleInfo.Path = info.Path
+	simpleInfo.Name = info.Name
+	simpleInfo.IsFile = !info.IsDir
+	return simpleInfo
+}
+
+func matchListRequest(request model.FileSimpleInfoListRequest, info model.StorageInfo) bool {
+	if request.Kind == model.ListKindFile && info.IsDir {
+		return false
+	}
+	if request.Kind == model.ListKindDir && !info.IsDir {
+		return false
+	}
+	if request.Glob != "" {
+		match, _ := path.Match(request.Glob, info.Name)
+		if !match {
+			return false
+		}
+	}
+	if info.IsDir {
+		return true
+	}
+	if !matchFileExt(request.Exts, info.Name) {
+		return false
+	}
+	if request.MimeType != "" && !strings.HasPrefix(mime.TypeByExtension(path.Ext(info.Name)), request.MimeType) {
+		return false
+	}
+	return true
+}
+
+// matchFileExt exts为空时不过滤，扩展名不区分大小写，可带或不带点
+func matchFileExt(exts []string, name string) bool {
+	if len(exts) == 0 {
+		return true
+	}
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+	for i := range exts {
+		if ext == strings.ToLower(strings.TrimPrefix(exts[i], ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// compareListInfo 按排序字段比较，相同时按名称比较，保证顺序稳定
+func compareListInfo(request model.FileSimpleInfoListRequest, a, b model.StorageInfo) int {
+	result := 0
+	switch request.Sort {
+	case model.ListSortSize:
+		result = compareInt64(a.Size, b.Size)
+	case model.ListSortMtime:
+		result = compareInt64(a.ModTime.UnixNano(), b.ModTime.UnixNano())
+	}
+	if result == 0 {
+		result = strings.Compare(a.Name, b.Name)
+	}
+	if request.Desc {
+		result = -result
+	}
+	return result
+}
+
+func compareInt64(a, b int64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+func createListCursor(sort string, info model.StorageInfo) string {
+	var cursor listCursor
+	cursor.Sort = sort
+	cursor.Name = info.Name
+	cursor.Size = info.Size
+	cursor.ModTime = info.ModTime.UnixNano()
+	return base64.RawURLEncoding.EncodeToString(util.ToJson(cursor))
+}
+
+func parseListCursor(ctx context.Context, text, sort string) (*model.StorageInfo, error) {
+	data, err := base64.RawURLEncoding.DecodeString(text)
+	var cursor listCursor
+	if err == nil {
+		err = util.UnmarshalJson(data, &cursor)
+	}
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"cursor": text, "err": err}).Error("罗列文件，非法游标")
+		return nil, fmt.Errorf("罗列文件，非法游标: %+v", err)
+	}
+	if cursor.Sort != sort {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"cursor": cursor, "sort": sort}).Error("罗列文件，游标与排序字段不一致")
+		return nil, fmt.Errorf("罗列文件，游标与排序字段不一致")
+	}
+	var info model.StorageInfo
+	info.Name = cursor.Name
+	info.Size = cursor.Size
+	info.ModTime = time.Unix(0, cursor.ModTime)
+	return &info, nil
+}
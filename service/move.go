@@ -0,0 +1,279 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/dao"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+	"path"
+	"strings"
+)
+
+type transferPath struct {
+	fromPath string
+	toPath   string
+	exist    bool
+}
+
+// MoveFile 移动或重命名文件/文件夹，返回实际移动后的文件
+func MoveFile(ctx context.Context, fromPath, toPath, conflict string) ([]model.FileSimpleInfo, error) {
+	return transferFile(ctx, fromPath, toPath, conflict, false)
+}
+
+// CopyFile 复制文件/文件夹，返回实际复制出的文件
+func CopyFile(ctx context.Context, fromPath, toPath, conflict string) ([]model.FileSimpleInfo, error) {
+	return transferFile(ctx, fromPath, toPath, conflict, true)
+}
+
+func transferFile(ctx context.Context, fromPath, toPath, conflict string, isCopy bool) ([]model.FileSimpleInfo, error) {
+	fromPath = util.ClearPath(ctx, path.Join("/", fromPath))
+	toPath = util.ClearPath(ctx, path.Join("/", toPath))
+	if conflict == "" {
+		conflict = model.ConflictFail
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"fromPath": fromPath, "toPath": toPath, "conflict": conflict, "copy": isCopy}).Info("转移文件")
+	if conflict != model.ConflictOverwrite && conflict != model.ConflictSkip && conflict != model.ConflictFail {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"conflict": conflict}).Error("转移文件，冲突策略非法")
+		return nil, fmt.Errorf("转移文件，冲突策略非法: %+v", conflict)
+	}
+	if model.IsReservedPath(fromPath) || model.IsReservedPath(toPath) {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{}).Error("转移文件，路径为保留路径")
+		return nil, fmt.Errorf("转移文件，路径为保留路径: %w", model.ErrReservedPath)
+	}
+	if fromPath == "/" || fromPath == toPath || strings.HasPrefix(toPath, fromPath+"/") {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{}).Error("转移文件，目标路径不能是源路径或其子路径")
+		return nil, fmt.Errorf("转移文件，目标路径不能是源路径或其子路径: %w", model.ErrInvalidPath)
+	}
+
+	fromInfo, err := GetFileSimpleInfo(ctx, fromPath)
+	if err != nil {
+		return nil, err
+	}
+	if fromInfo == nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{}).Error("转移文件，源路径不存在")
+		return nil, fmt.Errorf("转移文件，源路径不存在")
+	}
+	toInfo, err := GetFileSimpleInfo(ctx, toPath)
+	if err != nil {
+		return nil, err
+	}
+	if toInfo != nil && toInfo.IsFile != fromInfo.IsFile {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{}).Error("转移文件，源路径与目标路径类型不一致")
+		return nil, fmt.Errorf("转移文件，源路径与目标路径类型不一致: %w", model.ErrPathConflict)
+	}
+
+	var folders []transferPath
+	if !fromInfo.IsFile {
+		folders, err = listTransferFolder(ctx, fromPath, toPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	paths, err := listTransferPath(ctx, fromPath, toPath, fromInfo.IsFile, conflict)
+	if err != nil {
+		return nil, err
+	}
+
+	//先建好目标文件夹，空文件夹也随之转移
+	for i := range folders {
+		if folders[i].exist {
+			continue
+		}
+		_, err = dao.InsertFolder(ctx, folders[i].toPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var infos []model.FileSimpleInfo
+	for i := range paths {
+		if paths[i].exist && conflict == model.ConflictSkip {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"toPath": paths[i].toPath}).Info("转移文件，目标已存在，跳过")
+			continue
+		}
+		var info *model.FileSimpleInfo
+		if isCopy {
+			info, err = copyOneFile(ctx, paths[i])
+		} else {
+			info, err = moveOneFile(ctx, paths[i])
+		}
+		if err != nil {
+			return infos, err
+		}
+		infos = append(infos, *info)
+	}
+	if !isCopy {
+		err = removeTransferFolder(ctx, folders)
+		if err != nil {
+			return infos, err
+		}
+	}
+	return infos, nil
+}
+
+// listTransferFolder 列出需要转移的全部文件夹，含源文件夹本身，父文件夹在前；目标为文件时整体失败
+func listTransferFolder(ctx context.Context, fromPath, toPath string) ([]transferPath, error) {
+	var fromPaths []string
+	err := listAllFolderPath(ctx, fromPath, &fromPaths)
+	if err != nil {
+		return nil, err
+	}
+	folders := make([]transferPath, 0, len(fromPaths))
+	for i := range fromPaths {
+		object := transferPath{fromPath: fromPaths[i], toPath: toPath + strings.TrimPrefix(fromPaths[i], fromPath)}
+		info, err := GetFileSimpleInfo(ctx, object.toPath)
+		if err != nil {
+			return nil, err
+		}
+		if info != nil && info.IsFile {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"toPath": object.toPath}).Error("转移文件，目标路径为文件")
+			return nil, fmt.Errorf("转移文件，目标路径为文件: %+v: %w", object.toPath, model.ErrPathConflict)
+		}
+		object.exist = info != nil
+		folders = append(folders, object)
+	}
+	return folders, nil
+}
+
+// listAllFolderPath 递归罗列文件夹本身及其下的全部文件夹，父文件夹在前
+func listAllFolderPath(ctx context.Context, folderPath string, folderPaths *[]string) error {
+	*folderPaths = append(*folderPaths, folderPath)
+	list, err := ListFileSimpleInfo(ctx, folderPath)
+	if err != nil {
+		return err
+	}
+	for i := range list {
+		if list[i].IsFile {
+			continue
+		}
+		err = listAllFolderPath(ctx, list[i].Path, folderPaths)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeTransferFolder 移动后从深到浅删除已经移空的源文件夹，因跳过而留下文件的文件夹保留
+func removeTransferFolder(ctx context.Context, folders []transferPath) error {
+	for i := len(folders) - 1; i >= 0; i-- {
+		_, err := dao.DeleteEmptyFolder(ctx, folders[i].fromPath)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listTransferPath 列出需要转移的全部文件；冲突策略为fail时，任一目标已存在即整体失败，不做任何改动
+func listTransferPath(ctx context.Context, fromPath, toPath string, isFile bool, conflict string) ([]transferPath, error) {
+	var fromInfos []model.FileSimpleInfo
+	if isFile {
+		fromInfos = append(fromInfos, model.FileSimpleInfo{Path: fromPath, IsFile: true})
+	} else {
+		err := listAllFileSimpleInfo(ctx, fromPath, &fromInfos)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	paths := make([]transferPath, 0, len(fromInfos))
+	for i := range fromInfos {
+		object := transferPath{fromPath: fromInfos[i].Path, toPath: toPath + strings.TrimPrefix(fromInfos[i].Path, fromPath)}
+		info, err := GetFileSimpleInfo(ctx, object.toPath)
+		if err != nil {
+			return nil, err
+		}
+		if info != nil && !info.IsFile {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"toPath": object.toPath}).Error("转移文件，目标路径为文件夹")
+			return nil, fmt.Errorf("转移文件，目标路径为文件夹: %+v: %w", object.toPath, model.ErrPathConflict)
+		}
+		object.exist = info != nil
+		if object.exist && conflict == model.ConflictFail {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"toPath": object.toPath}).Error("转移文件，目标已存在")
+			return nil, fmt.Errorf("转移文件，目标已存在: %+v: %w", object.toPath, model.ErrPathConflict)
+		}
+		paths = append(paths, object)
+	}
+	return paths, nil
+}
+
+// listAllFileSimpleInfo 递归罗列文件夹下的全部文件，不含文件夹
+func listAllFileSimpleInfo(ctx context.Context, folderPath string, infos *[]model.FileSimpleInfo) error {
+	list, err := ListFileSimpleInfo(ctx, folderPath)
+	if err != nil {
+		return err
+	}
+	for i := range list {
+		if !list[i].IsFile {
+			err = listAllFileSimpleInfo(ctx, list[i].Path, infos)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		*infos = append(*infos, list[i])
+	}
+	return nil
+}
+
+func moveOneFile(ctx context.Context, object transferPath) (*model.FileSimpleInfo, error) {
+	defer lockQuota(ctx, object.toPath)()
+	err := checkMoveQuota(ctx, object)
+	if err != nil {
+		return nil, err
+	}
+	var oldPath string
+	if object.exist {
+		var err error
+		oldPath, err = replaceFile(ctx, object.toPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = dao.MoveFile(ctx, object.fromPath, object.toPath)
+	if err != nil {
+		restoreReplacedFile(ctx, oldPath, object.toPath)
+		return nil, err
+	}
+	info, err := GetFileSimpleInfo(ctx, object.toPath)
+	if err != nil {
+		return nil, err
+	}
+	publishFileEvent(ctx, model.FileEvent{Type: model.FileEventMove, Path: object.toPath, FromPath: object.fromPath, Source: model.FileEventSourceApi})
+	record := createFileAudit(ctx, model.AuditMoveFile, object.toPath)
+	record.FromPath = object.fromPath
+	record.Overwrite = object.exist
+	addAudit(ctx, record)
+	return info, nil
+}
+
+func copyOneFile(ctx context.Context, object transferPath) (*model.FileSimpleInfo, error) {
+	meta, err := dao.SelectFileMeta(ctx, object.fromPath)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := dao.GetReadFile(ctx, object.fromPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	info, err := insertFile(ctx, object.toPath, reader)
+	if err != nil {
+		return nil, err
+	}
+	if meta != nil && !meta.IsEmpty() {
+		err = dao.UpdateFileMeta(ctx, info.Path, *meta)
+		if err != nil {
+			return nil, err
+		}
+	}
+	info = initFileSimpleInfo(ctx, info)
+	publishFileEvent(ctx, model.FileEvent{Type: model.FileEventAdd, Path: info.Path, Source: model.FileEventSourceApi})
+	record := createFileAudit(ctx, model.AuditCopyFile, info.Path)
+	record.FromPath = object.fromPath
+	record.Overwrite = object.exist
+	addAudit(ctx, record)
+	return info, nil
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+	"io"
+	"sort"
+	"sync"
+)
+
+// quotaLocks 每个床的每个配额路径一把锁，检查配额到写入完成期间持有，避免并发写入一起超出配额
+var quotaLocks sync.Map
+
+// ListQuotaUsage 罗列ctx所在床的配额用量
+func ListQuotaUsage(ctx context.Context) ([]model.QuotaUsage, error) {
+	quotas := config.GetBucket(ctx).Quotas
+	usages := make([]model.QuotaUsage, 0, len(quotas))
+	for i := range quotas {
+		usage, err := getQuotaUsage(ctx, quotas[i])
+		if err != nil {
+			return nil, err
+		}
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+func getQuotaUsage(ctx context.Context, quota model.Quota) (model.QuotaUsage, error) {
+	var usage model.QuotaUsage
+	usage.Quota = quota
+	info, err := GetFileCompleteInfo(ctx, quota.Path)
+	if info == nil || err != nil {
+		return usage, err
+	}
+	usage.Size = info.Size
+	usage.Count = info.Count
+	return usage, nil
+}
+
+// checkQuota 写入前检查文件数配额，并返回写入超过剩余字节配额时会报错的reader
+func checkQuota(ctx context.Context, filePath string, oldInfo *model.FileSimpleInfo, reader io.Reader) (io.Reader, error) {
+	remain, err := getQuotaRemain(ctx, filePath, "", oldInfo)
+	if err != nil {
+		return nil, err
+	}
+	if remain < 0 {
+		return reader, nil
+	}
+	return &quotaReader{ctx: ctx, reader: reader, filePath: filePath, remain: remain}, nil
+}
+
+// checkSizeQuota 写入大小已知时，检查文件数配额与写入后的空间配额
+func checkSizeQuota(ctx context.Context, filePath string, oldInfo *model.FileSimpleInfo, size int64) error {
+	remain, err := getQuotaRemain(ctx, filePath, "", oldInfo)
+	if err != nil {
+		return err
+	}
+	if 0 <= remain && remain < size {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath, "size": size}).Error("检查配额，超出空间配额")
+		return fmt.Errorf("检查配额，超出空间配额: %+v: %w", filePath, model.ErrQuotaExceeded)
+	}
+	return nil
+}
+
+// checkMoveQuota 移动到源路径不在的配额下时，按目标配额检查文件数与文件大小；同一配额下移动不改变用量
+func checkMoveQuota(ctx context.Context, object transferPath) error {
+	var oldInfo *model.FileSimpleInfo
+	if object.exist {
+		oldInfo = &model.FileSimpleInfo{Path: object.toPath, IsFile: true}
+	}
+	remain, err := getQuotaRemain(ctx, object.toPath, object.fromPath, oldInfo)
+	if err != nil {
+		return err
+	}
+	if remain < 0 {
+		return nil
+	}
+	info, err := GetFileCompleteInfo(ctx, object.fromPath)
+	if err != nil {
+		return err
+	}
+	if info != nil && remain < info.Size {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"fromPath": object.fromPath, "toPath": object.toPath}).Error("检查配额，超出空间配额")
+		return fmt.Errorf("检查配额，超出空间配额: %+v: %w", object.toPath, model.ErrQuotaExceeded)
+	}
+	return nil
+}
+
+// getQuotaRemain 检查filePath所在配额的文件数，返回最小的剩余字节数，没有字节配额时为-1；
+// 同时覆盖skipPath的配额不检查
+func getQuotaRemain(ctx context.Context, filePath, skipPath string, oldInfo *model.FileSimpleInfo) (int64, error) {
+	var oldSize int64
+	if oldInfo != nil {
+		info, err := GetFileCompleteInfo(ctx, filePath)
+		if err != nil {
+			return 0, err
+		}
+		if info != nil {
+			oldSize = info.Size
+		}
+	}
+	remain := int64(-1)
+	quotas := config.GetBucket(ctx).Quotas
+	for i := range quotas {
+		quota := quotas[i]
+		if !isQuotaPath(quota, filePath) || (skipPath != "" && isQuotaPath(quota, skipPath)) {
+			continue
+		}
+		if quota.MaxSize <= 0 && quota.MaxCount <= 0 {
+			continue
+		}
+		usage, err := getQuotaUsage(ctx, quota)
+		if err != nil {
+			return 0, err
+		}
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"usage": usage}).Info("检查配额")
+		if quota.MaxCount > 0 && oldInfo == nil && quota.MaxCount <= usage.Count {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"usage": usage}).Error("检查配额，超出文件数配额")
+			return 0, fmt.Errorf("检查配额，超出文件数配额: %+v最多%+v个文件: %w", quota.Path, quota.MaxCount, model.ErrQuotaExceeded)
+		}
+		if quota.MaxSize <= 0 {
+			continue
+		}
+		quotaRemain := quota.MaxSize - usage.Size + oldSize
+		if quotaRemain < 0 {
+			quotaRemain = 0
+		}
+		if remain < 0 || quotaRemain < remain {
+			remain = quotaRemain
+		}
+	}
+	return remain, nil
+}
+
+// lockQuota 按配额路径的顺序给filePath所在的配额加锁，返回解锁函数
+func lockQuota(ctx context.Context, filePath string) func() {
+	var quotaPaths []string
+	quotas := config.GetBucket(ctx).Quotas
+	for i := range quotas {
+		if isQuotaPath(quotas[i], filePath) && (quotas[i].MaxSize > 0 || quotas[i].MaxCount > 0) {
+			quotaPaths = append(quotaPaths, quotas[i].Path)
+		}
+	}
+	sort.Strings(quotaPaths)
+	locks := make([]*sync.Mutex, 0, len(quotaPaths))
+	for i := range quotaPaths {
+		if 0 < i && quotaPaths[i] == quotaPaths[i-1] {
+			continue
+		}
+		object, _ := quotaLocks.LoadOrStore(config.GetBucketName(ctx)+":"+quotaPaths[i], &sync.Mutex{})
+		lock := object.(*sync.Mutex)
+		lock.Lock()
+		locks = append(locks, lock)
+	}
+	return func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			locks[i].Unlock()
+		}
+	}
+}
+
+func isQuotaPath(quota model.Quota, filePath string) bool {
+	return model.IsSubPath(quota.Path, filePath)
+}
+
+// quotaReader 读取超过剩余配额时返回错误，写入随之失败，不影响原文件
+type quotaReader struct {
+	ctx      context.Context
+	reader   io.Reader
+	filePath string
+	remain   int64
+}
+
+func (this *quotaReader) Read(p []byte) (int, error) {
+	n, err := this.reader.Read(p)
+	this.remain -= int64(n)
+	if this.remain < 0 {
+		logrus.WithContext(this.ctx).WithFields(logrus.Fields{"filePath": this.filePath}).Error("检查配额，超出空间配额")
+		return n, fmt.Errorf("检查配额，超出空间配额: %+v: %w", this.filePath, model.ErrQuotaExceeded)
+	}
+	return n, err
+}
+
+// wrapQuotaError 存储层不一定保留读取错误的类型，reader超出配额时重新包上ErrQuotaExceeded
+func wrapQuotaError(reader io.Reader, err error) error {
+	if err == nil || errors.Is(err, model.ErrQuotaExceeded) {
+		return err
+	}
+	if object, ok := reader.(*quotaReader); ok && object.remain < 0 {
+		return fmt.Errorf("%+v: %w", err, model.ErrQuotaExceeded)
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/dao"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/cellargalaxy/go_file_bed/sdk"
+	"github.com/sirupsen/logrus"
+	"sync"
+)
+
+// scrubReports 按床记录最近一次的校验报告
+var scrubReports = make(map[string]*model.ScrubReport)
+var scrubReportLock sync.Mutex
+var scrubbing bool
+
+// ScrubFile 校验全部床的文件，配置了自动修复时从pull同步的对端重新拉取损坏的文件
+func ScrubFile(ctx context.Context) {
+	scrubReportLock.Lock()
+	if scrubbing {
+		scrubReportLock.Unlock()
+		logrus.WithContext(ctx).WithFields(logrus.Fields{}).Warn("校验文件，上次校验未完成，跳过")
+		return
+	}
+	scrubbing = true
+	scrubReportLock.Unlock()
+	defer func() {
+		scrubReportLock.Lock()
+		scrubbing = false
+		scrubReportLock.Unlock()
+	}()
+
+	buckets := config.ListBucket()
+	for i := range buckets {
+		scrubBucket(config.SetBucket(ctx, buckets[i].Name))
+	}
+}
+
+func scrubBucket(ctx context.Context) {
+	bucket := config.GetBucketName(ctx)
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"bucket": bucket}).Info("校验文件，开始")
+	report, err := dao.ScrubFile(ctx, config.Config.ScrubRateLimit)
+	if err != nil {
+		return
+	}
+	if config.Config.ScrubRepair {
+		for i := range report.Results {
+			err = repairFile(ctx, report.Results[i])
+			report.Results[i].Repaired = err == nil
+		}
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"bucket": bucket, "checked": report.Checked, "skipped": report.Skipped, "bad": len(report.Results)}).Info("校验文件，完成")
+
+	scrubReportLock.Lock()
+	defer scrubReportLock.Unlock()
+	scrubReports[bucket] = report
+}
+
+// repairFile 对端文件的摘要与索引记录一致时才拉取覆盖
+func repairFile(ctx context.Context, result model.ScrubResult) error {
+	if config.Config.PullSyncHost == "" {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{}).Error("修复文件，未配置同步对端")
+		return fmt.Errorf("修复文件，未配置同步对端")
+	}
+	client, err := sdk.NewDefaultBucketFileBedClient(ctx, config.Config.PullSyncHost, config.Config.PullSyncSecret, config.GetBucketName(ctx))
+	if err != nil {
+		return err
+	}
+	var request model.FileCompleteInfoGetRequest
+	request.Path = result.Path
+	remoteInfo, err := client.GetFileCompleteInfo(ctx, request)
+	if err != nil {
+		return err
+	}
+	if remoteInfo == nil || remoteInfo.GetHash(model.HashSha256) != result.ExpectSha256 {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"result": result}).Error("修复文件，对端文件不一致")
+		return fmt.Errorf("修复文件，对端文件不一致: %+v", result.Path)
+	}
+	url, err := client.GetFileDownloadUrl(ctx, result.Path)
+	if err != nil {
+		return err
+	}
+	_, err = AddUrl(ctx, result.Path, url, true, nil)
+	if err != nil {
+		return err
+	}
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"path": result.Path}).Info("修复文件，完成")
+	return nil
+}
+
+// GetScrubReport 查询所在床最近一次的校验报告，未校验过时为空
+func GetScrubReport(ctx context.Context) (*model.ScrubReport, error) {
+	scrubReportLock.Lock()
+	defer scrubReportLock.Unlock()
+	return scrubReports[config.GetBucketName(ctx)], nil
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/dao"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// SearchFile 按请求在路径下搜索文件，结果按路径排序，返回下一页的游标
+func SearchFile(ctx context.Context, request model.FileSearchRequest) ([]model.FileStatInfo, string, error) {
+	folderPath := util.ClearPath(ctx, path.Join("/", request.Path))
+	request.Tags = model.NormalizeFileMeta(model.FileMeta{Tags: request.Tags}).Tags
+	matchName, err := createSearchNameMatcher(ctx, request)
+	if err != nil {
+		return nil, "", err
+	}
+	limit := request.Limit
+	if limit <= 0 {
+		limit = model.SearchDefaultLimit
+	}
+	if model.SearchMaxLimit < limit {
+		limit = model.SearchMaxLimit
+	}
+	var after string
+	if request.Cursor != "" {
+		data, err := base64.RawURLEncoding.DecodeString(request.Cursor)
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"cursor": request.Cursor, "err": err}).Error("搜索文件，非法游标")
+			return nil, "", fmt.Errorf("搜索文件，非法游标: %+v", err)
+		}
+		after = string(data)
+	}
+
+	indexes, more, err := dao.SearchFileIndex(ctx, folderPath, after, limit, func(index model.FileIndex) bool {
+		return matchName(path.Base(index.Path)) && matchSearchRequest(request, index)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	infos := make([]model.FileStatInfo, 0, len(indexes))
+	for i := range indexes {
+		var info model.FileStatInfo
+		info.Path = indexes[i].Path
+		info.Name = path.Base(indexes[i].Path)
+		info.IsFile = true
+		info.Url = createUrl(ctx, info.Path)
+		info.Size = indexes[i].Size
+		info.ModTime = indexes[i].ModTime
+		info.MimeType = indexes[i].Mime
+		info.Width = indexes[i].Width
+		info.Height = indexes[i].Height
+		info.FileMeta = indexes[i].FileMeta
+		infos = append(infos, info)
+	}
+	var nextCursor string
+	if more && len(indexes) > 0 {
+		nextCursor = base64.RawURLEncoding.EncodeToString([]byte(indexes[len(indexes)-1].Path))
+	}
+	return infos, nextCursor, nil
+}
+
+// createSearchNameMatcher 按模式创建文件名匹配函数，名称为空时全部匹配
+func createSearchNameMatcher(ctx context.Context, request model.FileSearchRequest) (func(name string) bool, error) {
+	name := request.Name
+	if name == "" {
+		return func(string) bool { return true }, nil
+	}
+	switch request.Mode {
+	case "", model.SearchModeSubstring:
+		if request.IgnoreCase {
+			name = strings.ToLower(name)
+			return func(fileName string) bool { return strings.Contains(strings.ToLower(fileName), name) }, nil
+		}
+		return func(fileName string) bool { return strings.Contains(fileName, name) }, nil
+	case model.SearchModeGlob:
+		if request.IgnoreCase {
+			name = strings.ToLower(name)
+		}
+		_, err := path.Match(name, "")
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"name": name, "err": err}).Error("搜索文件，非法glob")
+			return nil, fmt.Errorf("搜索文件，非法glob: %+v", err)
+		}
+		return func(fileName string) bool {
+			if request.IgnoreCase {
+				fileName = strings.ToLower(fileName)
+			}
+			match, _ := path.Match(name, fileName)
+			return match
+		}, nil
+	case model.SearchModeRegex:
+		if request.IgnoreCase {
+			name = "(?i)" + name
+		}
+		reg, err := regexp.Compile(name)
+		if err != nil {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"name": name, "err": err}).Error("搜索文件，非法正则")
+			return nil, fmt.Errorf("搜索文件，非法正则: %+v", err)
+		}
+		return reg.MatchString, nil
+	default:
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"mode": request.Mode}).Error("搜索文件，非法匹配模式")
+		return nil, fmt.Errorf("搜索文件，非法匹配模式")
+	}
+}
+
+func matchSearchRequest(request model.FileSearchRequest, index model.FileIndex) bool {
+	if request.MinSize > 0 && index.Size < request.MinSize {
+		return false
+	}
+	if request.MaxSize > 0 && index.Size > request.MaxSize {
+		return false
+	}
+	if request.MinMtime > 0 && index.ModTime.Unix() < request.MinMtime {
+		return false
+	}
+	if request.MaxMtime > 0 && index.ModTime.Unix() > request.MaxMtime {
+		return false
+	}
+	if !matchFileExt(request.Exts, index.Path) {
+		return false
+	}
+	if request.MimeType != "" && !strings.HasPrefix(index.Mime, request.MimeType) {
+		return false
+	}
+	if !index.HasTags(request.Tags) {
+		return false
+	}
+	return true
+}
@@ -4,121 +4,146 @@ import (
 	"context"
 	"fmt"
 	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/config"
 	"github.com/cellargalaxy/go_file_bed/dao"
 	"github.com/cellargalaxy/go_file_bed/model"
 	"github.com/cellargalaxy/go_file_bed/sdk"
 	"github.com/sirupsen/logrus"
 	"path"
+	"strings"
 )
 
-func PushSyncFile(ctx context.Context, address, secret, path string) error {
-	client, err := NewFileSyncClient(ctx, address, secret)
+// PushSyncFile 把ctx所在床推送到对端的bucket床
+func PushSyncFile(ctx context.Context, address, secret, bucket, path, hashType string) error {
+	client, err := NewFileSyncClient(ctx, address, secret, bucket, hashType)
 	if err != nil {
 		return err
 	}
 	return client.Push(ctx, path, path)
 }
 
-func PullSyncFile(ctx context.Context, address, secret, path string) error {
-	client, err := NewFileSyncClient(ctx, address, secret)
+// PullSyncFile 把对端的bucket床拉取到ctx所在床
+func PullSyncFile(ctx context.Context, address, secret, bucket, path, hashType string) error {
+	client, err := NewFileSyncClient(ctx, address, secret, bucket, hashType)
 	if err != nil {
 		return err
 	}
 	return client.Pull(ctx, path, path)
 }
 
-func NewFileSyncClient(ctx context.Context, address, secret string) (model.FileSyncInter, error) {
-	client, err := sdk.NewDefaultFileBedClient(ctx, address, secret)
+func NewFileSyncClient(ctx context.Context, address, secret, bucket, hashType string) (model.FileSyncInter, error) {
+	client, err := sdk.NewDefaultBucketFileBedClient(ctx, address, secret, bucket)
 	if err != nil {
 		return nil, err
 	}
 	if client == nil {
 		return nil, fmt.Errorf("创建FileBedClient为空")
 	}
-	return FileSyncClient{client: *client}, nil
+	if hashType == "" {
+		hashType = config.Config.SyncHashType
+	}
+	return FileSyncClient{client: *client, hashType: hashType}, nil
 }
 
 type FileSyncClient struct {
-	client sdk.FileBedClient
+	client   sdk.FileBedClient
+	hashType string
 }
 
+// Push 先取两端的清单，只上传对端缺失或摘要不一致的文件，元数据不一致时同步元数据；
+// 推送了的文件记录审计，Path为对端路径，FromPath为本地路径
 func (this FileSyncClient) Push(ctx context.Context, localPath, remotePath string) error {
-	localPath = util.ClearPath(ctx, localPath)
-	remotePath = util.ClearPath(ctx, remotePath)
+	localPath = util.ClearPath(ctx, path.Join("/", localPath))
+	remotePath = util.ClearPath(ctx, path.Join("/", remotePath))
 
-	localInfos, err := ListFileSimpleInfo(ctx, localPath)
+	localEntries, err := ListManifest(ctx, localPath)
 	if err != nil {
 		return err
 	}
-
-	for i := range localInfos {
-		local := path.Join(localPath, localInfos[i].Name)
-		remote := path.Join(remotePath, localInfos[i].Name)
-
-		if !localInfos[i].IsFile {
-			this.Push(ctx, local, remote)
-			continue
-		}
-
-		localInfo, err := GetFileCompleteInfo(ctx, local)
-		if localInfo == nil || err != nil {
-			continue
-		}
-
-		var request model.FileCompleteInfoGetRequest
-		request.Path = remote
-		logrus.WithContext(ctx).WithFields(logrus.Fields{"FileCompleteInfoGetRequest": request}).Info("Push文件，创建请求体")
-		remoteInfo, err := this.client.GetFileCompleteInfo(ctx, request)
-		if err != nil {
-			continue
+	var request model.ManifestGetRequest
+	request.Path = remotePath
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"ManifestGetRequest": request}).Info("Push文件，创建请求体")
+	remoteEntries, err := this.client.GetManifest(ctx, request)
+	if err != nil {
+		return err
+	}
+	remoteMap := createManifestMap(remotePath, remoteEntries)
+
+	for i := range localEntries {
+		relative := getManifestRelativePath(localPath, localEntries[i].Path)
+		local := path.Join(localPath, relative)
+		remote := path.Join(remotePath, relative)
+
+		remoteEntry, ok := remoteMap[relative]
+		pushed := false
+		if !ok || !this.matchEntry(ctx, localEntries[i], remoteEntry) {
+			file, err := dao.GetReadFile(ctx, local)
+			if file == nil || err != nil {
+				continue
+			}
+			if config.Config.SyncLargeSize <= localEntries[i].Size {
+				_, err = this.client.UploadLarge(ctx, remote, file, true)
+			} else {
+				_, err = this.client.AddFile(ctx, remote, file, true)
+			}
+			file.Close()
+			if err != nil {
+				continue
+			}
+			pushed = true
 		}
-		if remoteInfo != nil && localInfo.Md5 == remoteInfo.Md5 {
-			continue
+		//覆盖上传时对端沿用原来的元数据，与本地不一致时再设置
+		if !localEntries[i].FileMeta.Equal(remoteEntry.FileMeta) {
+			var request model.FileMetaSetRequest
+			request.Path = remote
+			request.Tags = localEntries[i].Tags
+			request.Metadata = localEntries[i].Metadata
+			_, err = this.client.SetFileMeta(ctx, request)
+			if err == nil {
+				pushed = true
+			}
 		}
-
-		file, err := dao.GetReadFile(ctx, local)
-		if file == nil || err != nil {
-			continue
+		if pushed {
+			var record model.AuditRecord
+			record.Operation = model.AuditPushSync
+			record.Path = remote
+			record.FromPath = local
+			record.Size = localEntries[i].Size
+			record.Hash = localEntries[i].Sha256
+			addAudit(ctx, record)
 		}
-		this.client.AddFile(ctx, remote, file, true)
 	}
 	return nil
 }
 
+// Pull 先取两端的清单，只拉取本地缺失或摘要不一致的文件，元数据不一致时同步元数据
 func (this FileSyncClient) Pull(ctx context.Context, localPath, remotePath string) error {
-	localPath = util.ClearPath(ctx, localPath)
-	remotePath = util.ClearPath(ctx, remotePath)
+	localPath = util.ClearPath(ctx, path.Join("/", localPath))
+	remotePath = util.ClearPath(ctx, path.Join("/", remotePath))
 
-	var request model.FileSimpleInfoListRequest
+	var request model.ManifestGetRequest
 	request.Path = remotePath
-	logrus.WithContext(ctx).WithFields(logrus.Fields{"FileSimpleInfoListRequest": request}).Info("Pull文件，创建请求体")
-	remoteInfos, err := this.client.ListFileSimpleInfo(ctx, request)
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"ManifestGetRequest": request}).Info("Pull文件，创建请求体")
+	remoteEntries, err := this.client.GetManifest(ctx, request)
 	if err != nil {
 		return err
 	}
-
-	for i := range remoteInfos {
-		local := path.Join(localPath, remoteInfos[i].Name)
-		remote := path.Join(remotePath, remoteInfos[i].Name)
-
-		if !remoteInfos[i].IsFile {
-			this.Pull(ctx, local, remote)
-			continue
-		}
-
-		var request model.FileCompleteInfoGetRequest
-		request.Path = remote
-		logrus.WithContext(ctx).WithFields(logrus.Fields{"FileCompleteInfoGetRequest": request}).Info("Pull文件，创建请求体")
-		remoteInfo, err := this.client.GetFileCompleteInfo(ctx, request)
-		if remoteInfo == nil || err != nil {
-			continue
-		}
-		localInfo, err := GetFileCompleteInfo(ctx, local)
-		if err != nil {
-			continue
-		}
-		match := this.matchInfo(ctx, localInfo, remoteInfo)
-		if match {
+	localEntries, err := ListManifest(ctx, localPath)
+	if err != nil {
+		return err
+	}
+	localMap := createManifestMap(localPath, localEntries)
+
+	for i := range remoteEntries {
+		relative := getManifestRelativePath(remotePath, remoteEntries[i].Path)
+		local := path.Join(localPath, relative)
+		remote := path.Join(remotePath, relative)
+
+		localEntry, ok := localMap[relative]
+		if ok && this.matchEntry(ctx, localEntry, remoteEntries[i]) {
+			if !localEntry.FileMeta.Equal(remoteEntries[i].FileMeta) {
+				setFileMeta(ctx, model.AuditPullSync, local, remoteEntries[i].FileMeta)
+			}
 			continue
 		}
 
@@ -126,20 +151,42 @@ func (this FileSyncClient) Pull(ctx context.Context, localPath, remotePath strin
 		if err != nil {
 			continue
 		}
-		AddUrl(ctx, local, url, true)
+		addUrl(ctx, model.AuditPullSync, local, url, true, &remoteEntries[i].FileMeta)
 	}
 	return nil
 }
 
-func (this FileSyncClient) matchInfo(ctx context.Context, localInfo, remoteInfo *model.FileCompleteInfo) bool {
-	if remoteInfo == nil {
-		return true
+// createManifestMap 以相对root的路径为key
+func createManifestMap(root string, entries []model.ManifestEntry) map[string]model.ManifestEntry {
+	entryMap := make(map[string]model.ManifestEntry, len(entries))
+	for i := range entries {
+		entryMap[getManifestRelativePath(root, entries[i].Path)] = entries[i]
 	}
-	if localInfo == nil {
-		return false
+	return entryMap
+}
+
+// getManifestRelativePath root与清单路径都是以/开头的床路径，root为文件时返回空
+func getManifestRelativePath(root, filePath string) string {
+	if root == "/" {
+		return strings.TrimPrefix(filePath, "/")
+	}
+	if filePath == root {
+		return ""
+	}
+	return strings.TrimPrefix(filePath, root+"/")
+}
+
+func (this FileSyncClient) matchEntry(ctx context.Context, localEntry, remoteEntry model.ManifestEntry) bool {
+	localHash := localEntry.GetHash(this.hashType)
+	remoteHash := remoteEntry.GetHash(this.hashType)
+	if localHash != "" && remoteHash != "" {
+		return localHash == remoteHash
 	}
-	if localInfo.Md5 != "" && remoteInfo.Md5 != "" {
-		return localInfo.Md5 == remoteInfo.Md5
+	//对端版本较旧时可能没有所选摘要，退回比较md5
+	localHash = localEntry.GetHash(model.HashMd5)
+	remoteHash = remoteEntry.GetHash(model.HashMd5)
+	if localHash != "" && remoteHash != "" {
+		return localHash == remoteHash
 	}
-	return localInfo.Size == remoteInfo.Size
+	return localEntry.Size == remoteEntry.Size
 }
@@ -0,0 +1,490 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/cellargalaxy/go_file_bed/service"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// auditSeedId 启动前写入审计日志的记录Id，比当前时间生成的Id大，重启后新记录应该排在它后面
+var auditSeedId = util.GenIdByTime(time.Now().Add(time.Hour))
+
+// 使用resource/go_file_bed.yml的内存存储，索引、上传历史与审计日志仍写在当前目录，测试结束后删除
+func TestMain(m *testing.M) {
+	os.MkdirAll(path.Dir(config.Config.AuditPath), 0755)
+	ioutil.WriteFile(config.Config.AuditPath, append(util.ToJson(model.AuditRecord{Id: auditSeedId, Path: "/audit_seed"}), '\n'), 0644)
+	code := m.Run()
+	os.RemoveAll(model.FileBedPath)
+	os.RemoveAll(path.Dir(config.Config.AuditPath))
+	os.Exit(code)
+}
+
+func addFile(ctx context.Context, test *testing.T, filePath, data string) {
+	_, err := service.AddFile(ctx, filePath, strings.NewReader(data), true, nil)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+}
+
+func readFile(ctx context.Context, test *testing.T, filePath string) string {
+	reader, err := service.GetReadFile(ctx, filePath)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	return string(data)
+}
+
+func existFile(ctx context.Context, test *testing.T, filePath string) *model.FileSimpleInfo {
+	info, err := service.GetFileSimpleInfo(ctx, filePath)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	return info
+}
+
+func TestMoveFileConflict(test *testing.T) {
+	ctx := util.GenCtx()
+	addFile(ctx, test, "/conflict/a.txt", "aaa")
+	addFile(ctx, test, "/conflict/b.txt", "bbb")
+
+	_, err := service.MoveFile(ctx, "/conflict/a.txt", "/conflict/b.txt", model.ConflictFail)
+	if err == nil {
+		test.Error("目标已存在时应该失败")
+		test.FailNow()
+	}
+	if readFile(ctx, test, "/conflict/a.txt") != "aaa" || readFile(ctx, test, "/conflict/b.txt") != "bbb" {
+		test.Error("失败时不应该改动文件")
+		test.FailNow()
+	}
+
+	infos, err := service.MoveFile(ctx, "/conflict/a.txt", "/conflict/b.txt", model.ConflictSkip)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if len(infos) != 0 || readFile(ctx, test, "/conflict/b.txt") != "bbb" || existFile(ctx, test, "/conflict/a.txt") == nil {
+		test.Error("跳过时不应该改动文件")
+		test.FailNow()
+	}
+
+	infos, err = service.MoveFile(ctx, "/conflict/a.txt", "/conflict/b.txt", model.ConflictOverwrite)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if len(infos) != 1 || readFile(ctx, test, "/conflict/b.txt") != "aaa" || existFile(ctx, test, "/conflict/a.txt") != nil {
+		test.Error("覆盖后目标应该是源文件")
+		test.FailNow()
+	}
+
+	infos, err = service.CopyFile(ctx, "/conflict/b.txt", "/conflict/c.txt", model.ConflictFail)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if len(infos) != 1 || readFile(ctx, test, "/conflict/c.txt") != "aaa" || readFile(ctx, test, "/conflict/b.txt") != "aaa" {
+		test.Error("复制后两边内容应该一致")
+		test.FailNow()
+	}
+}
+
+func TestMoveFolder(test *testing.T) {
+	ctx := util.GenCtx()
+	addFile(ctx, test, "/move_folder/src/sub/a.txt", "aaa")
+	_, err := service.AddFolder(ctx, "/move_folder/src/empty")
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	_, err = service.MoveFile(ctx, "/move_folder/src", "/move_folder/dst", model.ConflictFail)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if readFile(ctx, test, "/move_folder/dst/sub/a.txt") != "aaa" {
+		test.Error("文件没有移动")
+		test.FailNow()
+	}
+	info := existFile(ctx, test, "/move_folder/dst/empty")
+	if info == nil || info.IsFile {
+		test.Error("空文件夹没有移动")
+		test.FailNow()
+	}
+	if existFile(ctx, test, "/move_folder/src") != nil {
+		test.Error("源文件夹没有删除")
+		test.FailNow()
+	}
+
+	_, err = service.MoveFile(ctx, "/move_folder/dst/empty", "/move_folder/moved", model.ConflictFail)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if existFile(ctx, test, "/move_folder/moved") == nil || existFile(ctx, test, "/move_folder/dst/empty") != nil {
+		test.Error("空文件夹移动后应该只在目标路径")
+		test.FailNow()
+	}
+}
+
+func TestFileVersion(test *testing.T) {
+	ctx := util.GenCtx()
+	config.Config.VersionMaxCount = 2
+	defer func() {
+		config.Config.VersionMaxCount = 0
+	}()
+	for _, data := range []string{"v1", "v2", "v3", "v4"} {
+		addFile(ctx, test, "/version/a.txt", data)
+		time.Sleep(time.Millisecond)
+	}
+	versions, err := service.ListFileVersion(ctx, "/version/a.txt")
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if len(versions) != 2 {
+		test.Errorf("应该保留2个历史版本: %+v", versions)
+		test.FailNow()
+	}
+	_, reader, err := service.GetFileVersion(ctx, "/version/a.txt", versions[0].VersionId)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	defer reader.Close()
+	data, _ := ioutil.ReadAll(reader)
+	if string(data) != "v3" || readFile(ctx, test, "/version/a.txt") != "v4" {
+		test.Errorf("最新的历史版本应该是v3: %+v", string(data))
+		test.FailNow()
+	}
+}
+
+func TestQuota(test *testing.T) {
+	ctx := util.GenCtx()
+	config.Config.Quotas = []model.Quota{{Path: "/quota", MaxSize: 10, MaxCount: 2}}
+	defer func() {
+		config.Config.Quotas = nil
+	}()
+	addFile(ctx, test, "/quota/a.txt", "aaaaaa")
+	_, err := service.AddFile(ctx, "/quota/b.txt", strings.NewReader("bbbbbb"), true, nil)
+	if err == nil || existFile(ctx, test, "/quota/b.txt") != nil {
+		test.Error("超出空间配额时应该失败")
+		test.FailNow()
+	}
+	addFile(ctx, test, "/quota/b.txt", "bbbb")
+	_, err = service.AddFile(ctx, "/quota/c.txt", strings.NewReader("c"), true, nil)
+	if err == nil {
+		test.Error("超出文件数配额时应该失败")
+		test.FailNow()
+	}
+	addFile(ctx, test, "/quota/a.txt", "aa")
+
+	addFile(ctx, test, "/no_quota/d.txt", "ddddd")
+	_, err = service.MoveFile(ctx, "/no_quota/d.txt", "/quota/d.txt", model.ConflictFail)
+	if err == nil || existFile(ctx, test, "/quota/d.txt") != nil {
+		test.Error("移动到配额下超出配额时应该失败")
+		test.FailNow()
+	}
+}
+
+func TestRestoreFileVersionQuota(test *testing.T) {
+	ctx := util.GenCtx()
+	config.Config.VersionMaxCount = 2
+	config.Config.Quotas = []model.Quota{{Path: "/version_quota", MaxSize: 8}}
+	defer func() {
+		config.Config.VersionMaxCount = 0
+		config.Config.Quotas = nil
+	}()
+	addFile(ctx, test, "/version_quota/a.txt", "aaaaaa")
+	addFile(ctx, test, "/version_quota/a.txt", "aa")
+	addFile(ctx, test, "/version_quota/b.txt", "bbbb")
+	versions, err := service.ListFileVersion(ctx, "/version_quota/a.txt")
+	if err != nil || len(versions) != 1 {
+		test.Error("应该有1个历史版本", versions, err)
+		test.FailNow()
+	}
+	_, err = service.RestoreFileVersion(ctx, "/version_quota/a.txt", versions[0].VersionId)
+	if !errors.Is(err, model.ErrQuotaExceeded) || readFile(ctx, test, "/version_quota/a.txt") != "aa" {
+		test.Error("恢复后超出空间配额时应该失败", err)
+		test.FailNow()
+	}
+
+	addFile(ctx, test, "/version_quota/b.txt", "b")
+	_, err = service.RestoreFileVersion(ctx, "/version_quota/a.txt", versions[0].VersionId)
+	if err != nil || readFile(ctx, test, "/version_quota/a.txt") != "aaaaaa" {
+		test.Error("恢复历史版本失败", err)
+		test.FailNow()
+	}
+}
+
+func TestListFileCursor(test *testing.T) {
+	ctx := util.GenCtx()
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		addFile(ctx, test, "/list/"+name+".txt", name)
+	}
+	var request model.FileSimpleInfoListRequest
+	request.Path = "/list"
+	request.Limit = 2
+	infos, cursor, err := service.ListFileSimpleInfoPage(ctx, request)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	names := make([]string, 0, 5)
+	for i := range infos {
+		names = append(names, infos[i].Name)
+	}
+	//翻页之间在游标之前新增与删除文件，不影响后面的页
+	addFile(ctx, test, "/list/0.txt", "0")
+	_, err = service.RemoveFile(ctx, "/list/a.txt")
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	for cursor != "" {
+		request.Cursor = cursor
+		infos, cursor, err = service.ListFileSimpleInfoPage(ctx, request)
+		if err != nil {
+			test.Error(err)
+			test.FailNow()
+		}
+		for i := range infos {
+			names = append(names, infos[i].Name)
+		}
+	}
+	if strings.Join(names, ",") != "a.txt,b.txt,c.txt,d.txt,e.txt" {
+		test.Errorf("翻页结果不稳定: %+v", names)
+		test.FailNow()
+	}
+}
+
+func TestListManifest(test *testing.T) {
+	ctx := util.GenCtx()
+	addFile(ctx, test, "/manifest/sub/a.txt", "aaa")
+	entries, err := service.ListManifest(ctx, "manifest")
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if len(entries) != 1 || entries[0].Path != "/manifest/sub/a.txt" || entries[0].Size != 3 || entries[0].Md5 != "47bce5c74f589f4867dbd57e9ca9f808" {
+		test.Errorf("清单不正确: %+v", entries)
+		test.FailNow()
+	}
+}
+
+func TestFileHistory(test *testing.T) {
+	ctx := util.GenCtx()
+	addFile(ctx, test, "/history/a.txt", "aaa")
+	addFile(ctx, test, "/history/c.txt", "ccc")
+	_, err := service.MoveFile(ctx, "/history/a.txt", "/history/b.txt", model.ConflictFail)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	_, err = service.RemoveFile(ctx, "/history/c.txt")
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	var request model.FileHistoryListRequest
+	request.Prefix = "/history"
+	histories, _, err := service.ListFileHistory(ctx, request)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if len(histories) != 1 || histories[0].Path != "/history/b.txt" || histories[0].Size != 3 {
+		test.Errorf("上传历史应该只有移动后的文件: %+v", histories)
+		test.FailNow()
+	}
+
+	addFile(ctx, test, "/history/b.txt", "bbbb")
+	histories, _, err = service.ListFileHistory(ctx, request)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if len(histories) != 1 || histories[0].Path != "/history/b.txt" || histories[0].Size != 4 {
+		test.Errorf("覆盖后同一路径应该只有一条上传历史: %+v", histories)
+		test.FailNow()
+	}
+	addFile(ctx, test, "/history/d.txt", "ddddd")
+	_, err = service.MoveFile(ctx, "/history/d.txt", "/history/b.txt", model.ConflictOverwrite)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	histories, _, err = service.ListFileHistory(ctx, request)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if len(histories) != 1 || histories[0].Path != "/history/b.txt" || histories[0].Size != 5 {
+		test.Errorf("覆盖后同一路径应该只有一条上传历史: %+v", histories)
+		test.FailNow()
+	}
+}
+
+func TestSearchAudit(test *testing.T) {
+	ctx := util.GenCtx()
+	for _, name := range []string{"a", "b", "c"} {
+		addFile(ctx, test, "/audit_page/"+name+".txt", name)
+	}
+	var request model.AuditSearchRequest
+	request.Prefix = "/audit_page"
+	request.Limit = 2
+	records, cursor, err := service.SearchAudit(ctx, request)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if len(records) != 2 || cursor == "" || records[0].Path != "/audit_page/c.txt" || records[1].Path != "/audit_page/b.txt" {
+		test.Errorf("第一页不正确: %+v", records)
+		test.FailNow()
+	}
+	request.Cursor = cursor
+	records, cursor, err = service.SearchAudit(ctx, request)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if len(records) != 1 || cursor != "" || records[0].Path != "/audit_page/a.txt" || records[0].Operation != model.AuditAddFile {
+		test.Errorf("第二页不正确: %+v", records)
+		test.FailNow()
+	}
+}
+
+func TestSearchSkipTrash(test *testing.T) {
+	ctx := util.GenCtx()
+	config.Config.TrashEnable = true
+	defer func() {
+		config.Config.TrashEnable = false
+	}()
+	addFile(ctx, test, "/search_trash/search_trash_a.txt", "aaa")
+	addFile(ctx, test, "/search_trash/search_trash_b.txt", "bbb")
+	_, err := service.RemoveFile(ctx, "/search_trash/search_trash_b.txt")
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	var request model.FileSearchRequest
+	request.Name = "search_trash_"
+	for _, rebuild := range []bool{false, true} {
+		if rebuild {
+			_, err = service.RebuildFileIndex(ctx)
+			if err != nil {
+				test.Error(err)
+				test.FailNow()
+			}
+		}
+		infos, _, err := service.SearchFile(ctx, request)
+		if err != nil {
+			test.Error(err)
+			test.FailNow()
+		}
+		if len(infos) != 1 || infos[0].Path != "/search_trash/search_trash_a.txt" {
+			test.Errorf("搜索结果不应该有回收站的文件: %+v", infos)
+			test.FailNow()
+		}
+	}
+	request.Path = model.TrashPath
+	infos, _, err := service.SearchFile(ctx, request)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if len(infos) != 1 || !strings.HasPrefix(infos[0].Path, model.TrashPath+"/search_trash/") {
+		test.Errorf("在回收站下搜索应该搜到回收站的文件: %+v", infos)
+		test.FailNow()
+	}
+}
+
+func TestSearchAuditScan(test *testing.T) {
+	ctx := util.GenCtx()
+	//记录跨越多个读取块
+	count := 400
+	for i := 0; i < count; i++ {
+		addFile(ctx, test, fmt.Sprintf("/audit_scan/%03d.txt", i), "a")
+	}
+	var request model.AuditSearchRequest
+	request.Prefix = "/audit_scan"
+	request.Limit = 100
+	var records []model.AuditRecord
+	for {
+		page, cursor, err := service.SearchAudit(ctx, request)
+		if err != nil {
+			test.Error(err)
+			test.FailNow()
+		}
+		records = append(records, page...)
+		if cursor == "" {
+			break
+		}
+		request.Cursor = cursor
+	}
+	if len(records) != count {
+		test.Errorf("审计记录数量不正确: %+v", len(records))
+		test.FailNow()
+	}
+	for i := range records {
+		if records[i].Path != fmt.Sprintf("/audit_scan/%03d.txt", count-1-i) || records[i].Id <= auditSeedId {
+			test.Errorf("审计记录顺序或Id不正确: %+v", records[i])
+			test.FailNow()
+		}
+	}
+
+	request = model.AuditSearchRequest{Prefix: "/audit_seed"}
+	records, _, err := service.SearchAudit(ctx, request)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if len(records) != 1 || records[0].Id != auditSeedId {
+		test.Errorf("应该读到启动前的审计记录: %+v", records)
+		test.FailNow()
+	}
+}
+
+// TestBrokenBucket 床存储创建失败时只有这个床的操作返回错误，定时任务跳过它继续执行
+func TestBrokenBucket(test *testing.T) {
+	filePath := path.Join(test.TempDir(), "file")
+	err := ioutil.WriteFile(filePath, []byte("aaa"), 0644)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	buckets := config.Config.Buckets
+	config.Config.Buckets = append(config.Config.Buckets, model.Bucket{Name: "broken_bucket", Root: path.Join(filePath, "root")})
+	defer func() {
+		config.Config.Buckets = buckets
+	}()
+
+	ctx := config.SetBucket(util.GenCtx(), "broken_bucket")
+	_, err = service.AddFile(ctx, "/broken/a.txt", strings.NewReader("aaa"), true, nil)
+	if err == nil {
+		test.Error("床存储创建失败时写入应该失败")
+		test.FailNow()
+	}
+	service.ClearTrash(util.GenCtx())
+	service.ClearUpload(util.GenCtx())
+	service.ScrubFile(util.GenCtx())
+	addFile(util.GenCtx(), test, "/broken/b.txt", "bbb")
+}
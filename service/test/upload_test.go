@@ -0,0 +1,111 @@
+package test
+
+import (
+	"errors"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/cellargalaxy/go_file_bed/service"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMultipartUpload(test *testing.T) {
+	ctx := util.GenCtx()
+	config.Config.UploadMaxSize = 4
+	defer func() {
+		config.Config.UploadMaxSize = 0
+	}()
+	upload, err := service.CreateMultipartUpload(ctx, "/multipart/a.txt", true)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	_, err = service.WriteMultipartUpload(ctx, upload.Id, 1, "", strings.NewReader("12345"))
+	if err == nil {
+		test.Error("分片超过上限时应该失败")
+		test.FailNow()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	part1, err := service.WriteMultipartUpload(ctx, upload.Id, 1, "", strings.NewReader("1234"))
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	object, err := service.GetUpload(ctx, upload.Id)
+	if err != nil || object == nil || !upload.ExpireTime.Before(object.ExpireTime) {
+		test.Error("上传分片后应该刷新过期时间", object, err)
+		test.FailNow()
+	}
+	part2, err := service.WriteMultipartUpload(ctx, upload.Id, 2, "", strings.NewReader("5"))
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	config.Config.UploadMaxSize = 0
+	_, err = service.CompleteMultipartUpload(ctx, upload.Id, []model.UploadPart{*part1, *part2})
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if readFile(ctx, test, "/multipart/a.txt") != "12345" {
+		test.Error("合并后内容不一致")
+		test.FailNow()
+	}
+}
+
+func TestMultipartUploadLock(test *testing.T) {
+	ctx := util.GenCtx()
+	upload, err := service.CreateMultipartUpload(ctx, "/multipart/lock.txt", true)
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	part1, err := service.WriteMultipartUpload(ctx, upload.Id, 1, "", strings.NewReader("1"))
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+
+	//分片2写了一半时，分片3可以并发写入，合并要被拒绝
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := service.WriteMultipartUpload(ctx, upload.Id, 2, "", reader)
+		done <- err
+	}()
+	_, err = writer.Write([]byte("2"))
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	part3, err := service.WriteMultipartUpload(ctx, upload.Id, 3, "", strings.NewReader("3"))
+	if err != nil {
+		test.Error("分片应该可以并发写入", err)
+		test.FailNow()
+	}
+	_, err = service.CompleteMultipartUpload(ctx, upload.Id, []model.UploadPart{*part1, *part3})
+	if !errors.Is(err, model.ErrUploadLocked) {
+		test.Error("分片写入期间合并应该被拒绝", err)
+		test.FailNow()
+	}
+	writer.Close()
+	err = <-done
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+
+	_, err = service.CompleteMultipartUpload(ctx, upload.Id, []model.UploadPart{*part1, *part3})
+	if err != nil {
+		test.Error(err)
+		test.FailNow()
+	}
+	if readFile(ctx, test, "/multipart/lock.txt") != "13" {
+		test.Error("合并后内容不一致")
+		test.FailNow()
+	}
+}
@@ -13,8 +13,12 @@ import (
 	"time"
 )
 
+// ClearTrash 清理全部床的回收站
 func ClearTrash(ctx context.Context) {
-	clearTrash(ctx, model.TrashPath)
+	buckets := config.ListBucket()
+	for i := range buckets {
+		clearTrash(config.SetBucket(ctx, buckets[i].Name), model.TrashPath)
+	}
 }
 
 func clearTrash(ctx context.Context, folderPath string) error {
@@ -24,7 +28,15 @@ func clearTrash(ctx context.Context, folderPath string) error {
 	}
 	for i := range infos {
 		if !infos[i].IsFile {
-			clearTrash(ctx, infos[i].Path)
+			//整体移入回收站的文件夹整体清理，否则是回收站里的普通目录
+			folderPath := util.ClearPath(ctx, path.Join("/", infos[i].Path))
+			_, logId := parseTrashPath(ctx, folderPath)
+			trashTime, err := util.ParseId(ctx, logId)
+			if err != nil {
+				clearTrash(ctx, folderPath)
+			} else if config.GetBucket(ctx).TrashSaveTime <= time.Now().Sub(trashTime) {
+				removeFolder(ctx, model.AuditClearTrash, folderPath, false)
+			}
 			continue
 		}
 
@@ -34,8 +46,8 @@ func clearTrash(ctx context.Context, folderPath string) error {
 		}
 		_, logId := parseTrashPath(ctx, filePath)
 		trashTime, err := util.ParseId(ctx, logId)
-		if config.Config.TrashSaveTime <= time.Now().Sub(trashTime) || err != nil {
-			RemoveFile(ctx, filePath)
+		if config.GetBucket(ctx).TrashSaveTime <= time.Now().Sub(trashTime) || err != nil {
+			removeFile(ctx, model.AuditClearTrash, filePath)
 		}
 	}
 	return nil
@@ -51,6 +63,14 @@ func parseTrashPath(ctx context.Context, filePath string) (string, int64) {
 	if logIdExt != "" {
 		logId = util.String2Int64(logIdExt[1:])
 	}
+	if _, err := util.ParseId(ctx, logId); err == nil || fileExt == "" {
+		return filePath, logId
+	}
+	//没有拓展名的文件或文件夹，logId就是最后一个拓展名
+	extLogId := util.String2Int64(fileExt[1:])
+	if _, err := util.ParseId(ctx, extLogId); err == nil {
+		return logIdPath, extLogId
+	}
 	return filePath, logId
 }
 func genTrashPath(ctx context.Context, filePath string) string {
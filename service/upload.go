@@ -0,0 +1,344 @@
+package service
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/dao"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const uploadPartSize = 1024 * 1024 * 8 //8M
+
+// uploading 正在写入或合并的上传任务，-1为独占，正数为正在写入的分片数
+var uploading = make(map[string]int)
+var uploadLock sync.Mutex
+
+// CreateUpload 创建tus上传任务，大小为0时直接完成上传
+func CreateUpload(ctx context.Context, filePath string, size int64, raw bool) (*model.Upload, *model.FileSimpleInfo, error) {
+	upload, err := createUpload(ctx, filePath, size, raw, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if upload.Size > 0 {
+		return upload, nil, nil
+	}
+	info, err := completeUpload(ctx, *upload, nil)
+	return upload, info, err
+}
+
+// CreateMultipartUpload 创建Multipart上传任务，分片可以并发上传
+func CreateMultipartUpload(ctx context.Context, filePath string, raw bool) (*model.Upload, error) {
+	return createUpload(ctx, filePath, 0, raw, true)
+}
+
+func createUpload(ctx context.Context, filePath string, size int64, raw, multipart bool) (*model.Upload, error) {
+	filePath = util.ClearPath(ctx, path.Join("/", filePath))
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath, "size": size, "multipart": multipart}).Info("创建上传任务")
+	if model.IsReservedPath(filePath) {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath}).Error("创建上传任务，路径为保留路径")
+		return nil, fmt.Errorf("创建上传任务，路径为保留路径: %w", model.ErrReservedPath)
+	}
+	if size < 0 {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"size": size}).Error("创建上传任务，非法大小")
+		return nil, fmt.Errorf("创建上传任务，非法大小")
+	}
+	err := checkUploadSize(ctx, size)
+	if err != nil {
+		return nil, err
+	}
+	var upload model.Upload
+	upload.Id = util.GenStringId()
+	upload.Path = filePath
+	upload.Raw = raw
+	upload.Multipart = multipart
+	upload.Size = size
+	upload.CreateTime = time.Now()
+	upload.ExpireTime = upload.CreateTime.Add(config.Config.UploadExpireTime)
+	err = dao.InsertUpload(ctx, upload)
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func getMultipartMaxPartSize() int64 {
+	if 0 < config.Config.UploadMaxSize && config.Config.UploadMaxSize < model.MultipartMaxPartSize {
+		return config.Config.UploadMaxSize
+	}
+	return model.MultipartMaxPartSize
+}
+
+func checkUploadSize(ctx context.Context, size int64) error {
+	if 0 < config.Config.UploadMaxSize && config.Config.UploadMaxSize < size {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"size": size}).Error("上传文件，文件过大")
+		return fmt.Errorf("上传文件，文件过大: %w", model.ErrTooLarge)
+	}
+	return nil
+}
+
+// GetUpload 上传任务不存在或已过期时返回nil
+func GetUpload(ctx context.Context, id string) (*model.Upload, error) {
+	upload, err := dao.SelectUpload(ctx, id)
+	if upload == nil || err != nil {
+		return nil, err
+	}
+	if upload.ExpireTime.Before(time.Now()) {
+		return nil, nil
+	}
+	return upload, nil
+}
+
+// WriteUpload 从offset处续传tus上传任务，全部上传后合并写入床并返回文件信息
+func WriteUpload(ctx context.Context, id string, offset int64, reader io.Reader) (*model.Upload, *model.FileSimpleInfo, error) {
+	unlock, err := lockUpload(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
+	upload, err := GetUpload(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if upload == nil || upload.Multipart {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"id": id}).Error("写入上传分片，上传任务不存在")
+		return nil, nil, fmt.Errorf("写入上传分片，上传任务不存在: %w", model.ErrUploadNotFound)
+	}
+	if upload.Offset != offset {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"id": id, "offset": offset, "upload": upload}).Error("写入上传分片，偏移量不一致")
+		return nil, nil, fmt.Errorf("写入上传分片，偏移量不一致: %w", model.ErrUploadConflict)
+	}
+
+	//请求体按固定大小切成多个分片，每写完一个就记录偏移量，中断时只丢失最后一个分片
+	//分片以偏移量命名，在同一偏移量重传会覆盖未记录的分片
+	for upload.Offset < upload.Size {
+		partSize := upload.Size - upload.Offset
+		if uploadPartSize < partSize {
+			partSize = uploadPartSize
+		}
+		written, err := dao.InsertUploadPart(ctx, id, fmt.Sprintf("%020d", upload.Offset), io.LimitReader(reader, partSize))
+		if err != nil {
+			return upload, nil, err
+		}
+		if written == 0 {
+			break
+		}
+		upload.Offset += written
+		upload.ExpireTime = time.Now().Add(config.Config.UploadExpireTime)
+		err = dao.InsertUpload(ctx, *upload)
+		if err != nil {
+			return upload, nil, err
+		}
+		if written < partSize {
+			break
+		}
+	}
+	if upload.Offset < upload.Size {
+		return upload, nil, nil
+	}
+	parts, err := dao.ListUploadPart(ctx, id)
+	if err != nil {
+		return upload, nil, err
+	}
+	names := make([]string, 0, len(parts))
+	for i := range parts {
+		names = append(names, parts[i].Name)
+	}
+	info, err := completeUpload(ctx, *upload, names)
+	return upload, info, err
+}
+
+// WriteMultipartUpload 写入一个编号分片，同一编号重复上传会覆盖；md5不为空时校验分片数据
+func WriteMultipartUpload(ctx context.Context, id string, partNumber int, partMd5 string, reader io.Reader) (*model.UploadPart, error) {
+	//分片之间可以并发写入，但合并期间不能写入，否则合并可能读到写了一半的分片
+	unlock, err := lockUploadShared(ctx, id, true)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	upload, err := GetUpload(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if upload == nil || !upload.Multipart {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"id": id}).Error("写入上传分片，上传任务不存在")
+		return nil, fmt.Errorf("写入上传分片，上传任务不存在: %w", model.ErrUploadNotFound)
+	}
+	if partNumber < 1 || model.MultipartMaxPartNumber < partNumber {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"partNumber": partNumber}).Error("写入上传分片，非法分片编号")
+		return nil, fmt.Errorf("写入上传分片，非法分片编号")
+	}
+	name := createMultipartPartName(partNumber)
+	maxPartSize := getMultipartMaxPartSize()
+	hash := md5.New()
+	//多读一个字节，超过上限时能发现
+	written, err := dao.InsertUploadPart(ctx, id, name, io.TeeReader(io.LimitReader(reader, maxPartSize+1), hash))
+	if err != nil {
+		dao.DeleteUploadPart(ctx, id, name)
+		return nil, err
+	}
+	if maxPartSize < written {
+		dao.DeleteUploadPart(ctx, id, name)
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"partNumber": partNumber, "maxPartSize": maxPartSize}).Error("写入上传分片，分片过大")
+		return nil, fmt.Errorf("写入上传分片，分片过大: %w", model.ErrTooLarge)
+	}
+	//分片上传期间任务不过期；并发上传的分片同时刷新，只有过期时间不同，互相覆盖无妨
+	upload.ExpireTime = time.Now().Add(config.Config.UploadExpireTime)
+	err = dao.InsertUpload(ctx, *upload)
+	if err != nil {
+		return nil, err
+	}
+	var part model.UploadPart
+	part.PartNumber = partNumber
+	part.Size = written
+	part.Md5 = hex.EncodeToString(hash.Sum(nil))
+	if partMd5 != "" && !strings.EqualFold(partMd5, part.Md5) {
+		dao.DeleteUploadPart(ctx, id, name)
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"part": part, "partMd5": partMd5}).Error("写入上传分片，分片md5不一致")
+		return nil, fmt.Errorf("写入上传分片，分片md5不一致")
+	}
+	return &part, nil
+}
+
+// CompleteMultipartUpload 按清单合并分片，清单里的分片必须都已上传且大小一致，清单外的分片丢弃
+func CompleteMultipartUpload(ctx context.Context, id string, parts []model.UploadPart) (*model.FileSimpleInfo, error) {
+	unlock, err := lockUpload(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	upload, err := GetUpload(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if upload == nil || !upload.Multipart {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"id": id}).Error("完成上传任务，上传任务不存在")
+		return nil, fmt.Errorf("完成上传任务，上传任务不存在: %w", model.ErrUploadNotFound)
+	}
+	storedParts, err := dao.ListUploadPart(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	storedSizes := make(map[string]int64, len(storedParts))
+	for i := range storedParts {
+		storedSizes[storedParts[i].Name] = storedParts[i].Size
+	}
+	names := make([]string, 0, len(parts))
+	var size int64
+	for i := range parts {
+		if i > 0 && parts[i].PartNumber <= parts[i-1].PartNumber {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"part": parts[i]}).Error("完成上传任务，分片编号未升序")
+			return nil, fmt.Errorf("完成上传任务，分片编号未升序")
+		}
+		name := createMultipartPartName(parts[i].PartNumber)
+		storedSize, ok := storedSizes[name]
+		if !ok || storedSize != parts[i].Size {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{"part": parts[i], "storedSize": storedSize}).Error("完成上传任务，分片不存在或大小不一致")
+			return nil, fmt.Errorf("完成上传任务，分片不存在或大小不一致: %w", model.ErrUploadConflict)
+		}
+		names = append(names, name)
+		size += storedSize
+	}
+	err = checkUploadSize(ctx, size)
+	if err != nil {
+		return nil, err
+	}
+	upload.Size = size
+	upload.Offset = size
+	return completeUpload(ctx, *upload, names)
+}
+
+func createMultipartPartName(partNumber int) string {
+	return fmt.Sprintf("%05d", partNumber)
+}
+
+// lockUpload 同一上传任务同时只允许一个请求写入或合并
+func lockUpload(ctx context.Context, id string) (func(), error) {
+	return lockUploadShared(ctx, id, false)
+}
+
+// lockUploadShared shared为true时可与其他分片写入并发，但与写入或合并互斥
+func lockUploadShared(ctx context.Context, id string, shared bool) (func(), error) {
+	key := config.GetBucketName(ctx) + "/" + id
+	uploadLock.Lock()
+	defer uploadLock.Unlock()
+	count := uploading[key]
+	if count < 0 || (count > 0 && !shared) {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"id": id}).Error("上传任务正在写入")
+		return nil, fmt.Errorf("上传任务正在写入: %w", model.ErrUploadLocked)
+	}
+	if shared {
+		uploading[key] = count + 1
+	} else {
+		uploading[key] = -1
+	}
+	return func() {
+		uploadLock.Lock()
+		defer uploadLock.Unlock()
+		if !shared || uploading[key] <= 1 {
+			delete(uploading, key)
+			return
+		}
+		uploading[key]--
+	}, nil
+}
+
+// completeUpload 按names的顺序合并分片，与AddFile走同样的处理，成功后删除上传任务
+func completeUpload(ctx context.Context, upload model.Upload, names []string) (*model.FileSimpleInfo, error) {
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"upload": upload}).Info("完成上传任务")
+	reader, err := dao.GetUploadReader(ctx, upload.Id, names)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	info, err := AddFile(ctx, upload.Path, reader, upload.Raw, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = dao.DeleteUpload(ctx, upload.Id)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"upload": upload, "err": err}).Warn("完成上传任务，删除上传任务异常")
+	}
+	return info, nil
+}
+
+func RemoveUpload(ctx context.Context, id string) error {
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"id": id}).Info("删除上传任务")
+	return dao.DeleteUpload(ctx, id)
+}
+
+// ClearUpload 清理全部床里过期未完成的上传任务
+func ClearUpload(ctx context.Context) {
+	buckets := config.ListBucket()
+	for i := range buckets {
+		clearUpload(config.SetBucket(ctx, buckets[i].Name))
+	}
+}
+
+func clearUpload(ctx context.Context) error {
+	uploads, err := dao.ListUpload(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for i := range uploads {
+		if now.Before(uploads[i].ExpireTime) {
+			continue
+		}
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"upload": uploads[i]}).Info("清理上传任务，上传任务过期")
+		dao.DeleteUpload(ctx, uploads[i].Id)
+	}
+	return nil
+}
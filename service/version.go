@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/config"
+	"github.com/cellargalaxy/go_file_bed/dao"
+	"github.com/cellargalaxy/go_file_bed/model"
+	"github.com/sirupsen/logrus"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ClearVersion 按保留个数与保留时长清理全部床的历史版本
+func ClearVersion(ctx context.Context) {
+	buckets := config.ListBucket()
+	for i := range buckets {
+		clearVersion(config.SetBucket(ctx, buckets[i].Name), model.VersionPath)
+	}
+}
+
+func clearVersion(ctx context.Context, folderPath string) error {
+	infos, err := dao.SelectFolderStorageInfo(ctx, folderPath)
+	if err != nil {
+		return err
+	}
+	hasVersion := false
+	for i := range infos {
+		if infos[i].IsDir {
+			clearVersion(ctx, infos[i].Path)
+			continue
+		}
+		hasVersion = true
+	}
+	if hasVersion {
+		return clearFileVersion(ctx, strings.TrimPrefix(folderPath, model.VersionPath))
+	}
+	return nil
+}
+
+// saveFileVersion 把当前文件移入历史版本，版本号为生成时间的ID，返回历史版本的路径
+func saveFileVersion(ctx context.Context, filePath string) (string, error) {
+	versionPath := createVersionPath(ctx, filePath, util.GenStringId())
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath, "versionPath": versionPath}).Info("保存历史版本")
+	err := dao.MoveFile(ctx, filePath, versionPath)
+	if err != nil {
+		return "", err
+	}
+	clearFileVersion(ctx, filePath)
+	return versionPath, nil
+}
+
+// clearFileVersion 最近的VersionMaxCount个版本与VersionSaveTime内的版本保留，其余删除
+func clearFileVersion(ctx context.Context, filePath string) error {
+	if !config.Config.VersionEnable() {
+		return nil
+	}
+	versions, err := ListFileVersion(ctx, filePath)
+	if err != nil {
+		return err
+	}
+	for i := range versions {
+		if config.Config.VersionMaxCount > 0 && i < config.Config.VersionMaxCount {
+			continue
+		}
+		versionTime, err := util.ParseStringId(ctx, versions[i].VersionId)
+		if config.Config.VersionSaveTime > 0 && err == nil && time.Now().Sub(versionTime) < config.Config.VersionSaveTime {
+			continue
+		}
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"version": versions[i]}).Info("清理历史版本")
+		_, err = dao.DeleteFile(ctx, createVersionPath(ctx, filePath, versions[i].VersionId))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListFileVersion 罗列文件的历史版本，新版本在前
+func ListFileVersion(ctx context.Context, filePath string) ([]model.FileVersion, error) {
+	filePath = util.ClearPath(ctx, path.Join("/", filePath))
+	infos, err := dao.SelectFolderStorageInfo(ctx, path.Join(model.VersionPath, filePath))
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]model.FileVersion, 0, len(infos))
+	for i := range infos {
+		if infos[i].IsDir {
+			continue
+		}
+		var version model.FileVersion
+		version.Path = filePath
+		version.VersionId = infos[i].Name
+		version.Size = infos[i].Size
+		version.ModTime = infos[i].ModTime
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].VersionId > versions[j].VersionId
+	})
+	return versions, nil
+}
+
+func GetFileVersion(ctx context.Context, filePath, versionId string) (*model.StorageInfo, io.ReadCloser, error) {
+	filePath = util.ClearPath(ctx, path.Join("/", filePath))
+	versionPath, err := checkVersionPath(ctx, filePath, versionId)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := dao.SelectStorageInfo(ctx, versionPath)
+	if info == nil || err != nil {
+		return nil, nil, err
+	}
+	reader, err := dao.GetReadFile(ctx, versionPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	info.Name = path.Base(filePath)
+	return info, reader, nil
+}
+
+// RestoreFileVersion 把历史版本恢复为当前文件，当前文件存为新的历史版本
+func RestoreFileVersion(ctx context.Context, filePath, versionId string) (*model.FileSimpleInfo, error) {
+	filePath = util.ClearPath(ctx, path.Join("/", filePath))
+	logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath, "versionId": versionId}).Info("恢复历史版本")
+	if model.IsReservedPath(filePath) {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath}).Error("恢复历史版本，路径为保留路径")
+		return nil, fmt.Errorf("恢复历史版本，路径为保留路径")
+	}
+	versionPath, err := checkVersionPath(ctx, filePath, versionId)
+	if err != nil {
+		return nil, err
+	}
+	versionInfo, err := dao.SelectStorageInfo(ctx, versionPath)
+	if err != nil {
+		return nil, err
+	}
+	if versionInfo == nil || versionInfo.IsDir {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"versionPath": versionPath}).Error("恢复历史版本，版本不存在")
+		return nil, fmt.Errorf("恢复历史版本，版本不存在")
+	}
+
+	defer lockQuota(ctx, filePath)()
+	oldInfo, err := GetFileSimpleInfo(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if oldInfo != nil && !oldInfo.IsFile {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"filePath": filePath}).Error("恢复历史版本，路径为文件夹")
+		return nil, fmt.Errorf("恢复历史版本，路径为文件夹: %w", model.ErrPathConflict)
+	}
+	if oldInfo == nil {
+		err = checkParentFolder(ctx, filePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = checkSizeQuota(ctx, filePath, oldInfo, versionInfo.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	//先把要恢复的版本移到暂存路径，避免保存当前文件时被清理掉
+	stagePath := createStagePath(ctx, filePath)
+	err = dao.MoveFile(ctx, versionPath, stagePath)
+	if err != nil {
+		return nil, err
+	}
+	var replace func(ctx context.Context, filePath string) (string, error)
+	if oldInfo != nil {
+		replace = saveFileVersion
+	}
+	err = replaceStageFile(ctx, stagePath, filePath, replace)
+	if err != nil {
+		dao.MoveFile(ctx, stagePath, versionPath)
+		return nil, err
+	}
+	publishFileEvent(ctx, model.FileEvent{Type: model.FileEventAdd, Path: filePath, Source: model.FileEventSourceApi})
+	record := createFileAudit(ctx, model.AuditRestoreFileVersion, filePath)
+	record.Overwrite = oldInfo != nil
+	addAudit(ctx, record)
+	return GetFileSimpleInfo(ctx, filePath)
+}
+
+func checkVersionPath(ctx context.Context, filePath, versionId string) (string, error) {
+	_, err := util.ParseStringId(ctx, versionId)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{"versionId": versionId}).Error("历史版本，非法版本号")
+		return "", fmt.Errorf("历史版本，非法版本号: %+v", versionId)
+	}
+	return createVersionPath(ctx, filePath, versionId), nil
+}
+
+func createVersionPath(ctx context.Context, filePath, versionId string) string {
+	return path.Join(model.VersionPath, filePath, versionId)
+}
@@ -2,13 +2,14 @@ package test
 
 import (
 	"github.com/cellargalaxy/go_common/util"
+	"github.com/cellargalaxy/go_file_bed/model"
 	"github.com/cellargalaxy/go_file_bed/service"
 	"testing"
 )
 
 func TestPushFile(test *testing.T) {
 	ctx := util.GenCtx()
-	err := service.PushSyncFile(ctx, "http://127.0.0.1:8880/", "secret", "")
+	err := service.PushSyncFile(ctx, "http://127.0.0.1:8880/", "secret", "", "", model.HashMd5)
 	if err != nil {
 		test.Error(err)
 		test.FailNow()
@@ -17,7 +18,7 @@ func TestPushFile(test *testing.T) {
 
 func TestPullFile(test *testing.T) {
 	ctx := util.GenCtx()
-	err := service.PullSyncFile(ctx, "http://127.0.0.1:8880/", "secret", "")
+	err := service.PullSyncFile(ctx, "http://127.0.0.1:8880/", "secret", "", "", model.HashMd5)
 	if err != nil {
 		test.Error(err)
 		test.FailNow()